@@ -90,11 +90,6 @@ func RegisterConversions(s *runtime.Scheme) error {
 	}); err != nil {
 		return err
 	}
-	if err := s.AddGeneratedConversionFunc((*v1beta1.ExperimentStatus)(nil), (*ExperimentStatus)(nil), func(a, b interface{}, scope conversion.Scope) error {
-		return Convert_v1beta1_ExperimentStatus_To_v1alpha1_ExperimentStatus(a.(*v1beta1.ExperimentStatus), b.(*ExperimentStatus), scope)
-	}); err != nil {
-		return err
-	}
 	if err := s.AddGeneratedConversionFunc((*HelmValue)(nil), (*v1beta1.HelmValue)(nil), func(a, b interface{}, scope conversion.Scope) error {
 		return Convert_v1alpha1_HelmValue_To_v1beta1_HelmValue(a.(*HelmValue), b.(*v1beta1.HelmValue), scope)
 	}); err != nil {
@@ -140,11 +135,6 @@ func RegisterConversions(s *runtime.Scheme) error {
 	}); err != nil {
 		return err
 	}
-	if err := s.AddGeneratedConversionFunc((*v1beta1.NamespaceTemplateSpec)(nil), (*NamespaceTemplateSpec)(nil), func(a, b interface{}, scope conversion.Scope) error {
-		return Convert_v1beta1_NamespaceTemplateSpec_To_v1alpha1_NamespaceTemplateSpec(a.(*v1beta1.NamespaceTemplateSpec), b.(*NamespaceTemplateSpec), scope)
-	}); err != nil {
-		return err
-	}
 	if err := s.AddGeneratedConversionFunc((*Optimization)(nil), (*v1beta1.Optimization)(nil), func(a, b interface{}, scope conversion.Scope) error {
 		return Convert_v1alpha1_Optimization_To_v1beta1_Optimization(a.(*Optimization), b.(*v1beta1.Optimization), scope)
 	}); err != nil {
@@ -330,6 +320,16 @@ func RegisterConversions(s *runtime.Scheme) error {
 	}); err != nil {
 		return err
 	}
+	if err := s.AddConversionFunc((*v1beta1.ExperimentStatus)(nil), (*ExperimentStatus)(nil), func(a, b interface{}, scope conversion.Scope) error {
+		return Convert_v1beta1_ExperimentStatus_To_v1alpha1_ExperimentStatus(a.(*v1beta1.ExperimentStatus), b.(*ExperimentStatus), scope)
+	}); err != nil {
+		return err
+	}
+	if err := s.AddConversionFunc((*v1beta1.NamespaceTemplateSpec)(nil), (*NamespaceTemplateSpec)(nil), func(a, b interface{}, scope conversion.Scope) error {
+		return Convert_v1beta1_NamespaceTemplateSpec_To_v1alpha1_NamespaceTemplateSpec(a.(*v1beta1.NamespaceTemplateSpec), b.(*NamespaceTemplateSpec), scope)
+	}); err != nil {
+		return err
+	}
 	if err := s.AddConversionFunc((*v1beta1.TrialSpec)(nil), (*TrialSpec)(nil), func(a, b interface{}, scope conversion.Scope) error {
 		return Convert_v1beta1_TrialSpec_To_v1alpha1_TrialSpec(a.(*v1beta1.TrialSpec), b.(*TrialSpec), scope)
 	}); err != nil {
@@ -594,6 +594,7 @@ func autoConvert_v1alpha1_ExperimentSpec_To_v1beta1_ExperimentSpec(in *Experimen
 
 func autoConvert_v1beta1_ExperimentSpec_To_v1alpha1_ExperimentSpec(in *v1beta1.ExperimentSpec, out *ExperimentSpec, s conversion.Scope) error {
 	out.Replicas = in.Replicas
+	// WARNING: in.Replication requires manual conversion: does not exist in peer-type
 	if in.Optimization != nil {
 		in, out := &in.Optimization, &out.Optimization
 		*out = make([]Optimization, len(*in))
@@ -649,6 +650,7 @@ func autoConvert_v1beta1_ExperimentSpec_To_v1alpha1_ExperimentSpec(in *v1beta1.E
 	} else {
 		out.Patches = nil
 	}
+	// WARNING: in.AbortConditions requires manual conversion: does not exist in peer-type
 	out.NamespaceSelector = in.NamespaceSelector
 	if in.NamespaceTemplate != nil {
 		in, out := &in.NamespaceTemplate, &out.NamespaceTemplate
@@ -659,6 +661,7 @@ func autoConvert_v1beta1_ExperimentSpec_To_v1alpha1_ExperimentSpec(in *v1beta1.E
 	} else {
 		out.NamespaceTemplate = nil
 	}
+	// WARNING: in.Clone requires manual conversion: does not exist in peer-type
 	out.Selector = in.Selector
 	// WARNING: in.TrialTemplate requires manual conversion: does not exist in peer-type
 	return nil
@@ -678,14 +681,12 @@ func Convert_v1alpha1_ExperimentStatus_To_v1beta1_ExperimentStatus(in *Experimen
 func autoConvert_v1beta1_ExperimentStatus_To_v1alpha1_ExperimentStatus(in *v1beta1.ExperimentStatus, out *ExperimentStatus, s conversion.Scope) error {
 	out.Phase = in.Phase
 	out.ActiveTrials = in.ActiveTrials
+	// WARNING: in.CreatedNamespaces requires manual conversion: does not exist in peer-type
+	// WARNING: in.NamespacePool requires manual conversion: does not exist in peer-type
+	// WARNING: in.NamespaceFailure requires manual conversion: does not exist in peer-type
 	return nil
 }
 
-// Convert_v1beta1_ExperimentStatus_To_v1alpha1_ExperimentStatus is an autogenerated conversion function.
-func Convert_v1beta1_ExperimentStatus_To_v1alpha1_ExperimentStatus(in *v1beta1.ExperimentStatus, out *ExperimentStatus, s conversion.Scope) error {
-	return autoConvert_v1beta1_ExperimentStatus_To_v1alpha1_ExperimentStatus(in, out, s)
-}
-
 func autoConvert_v1alpha1_HelmValue_To_v1beta1_HelmValue(in *HelmValue, out *v1beta1.HelmValue, s conversion.Scope) error {
 	out.Name = in.Name
 	out.ForceString = in.ForceString
@@ -824,10 +825,21 @@ func autoConvert_v1beta1_Metric_To_v1alpha1_Metric(in *v1beta1.Metric, out *Metr
 	out.Type = MetricType(in.Type)
 	out.Query = in.Query
 	out.ErrorQuery = in.ErrorQuery
+	// WARNING: in.Aggregation requires manual conversion: does not exist in peer-type
+	// WARNING: in.Step requires manual conversion: does not exist in peer-type
+	// WARNING: in.Samples requires manual conversion: does not exist in peer-type
+	// WARNING: in.Timeout requires manual conversion: does not exist in peer-type
 	out.Scheme = in.Scheme
 	out.Selector = in.Selector
+	// WARNING: in.PodSelector requires manual conversion: does not exist in peer-type
+	// WARNING: in.PodAggregation requires manual conversion: does not exist in peer-type
 	out.Port = in.Port
 	out.Path = in.Path
+	// WARNING: in.Method requires manual conversion: does not exist in peer-type
+	// WARNING: in.Body requires manual conversion: does not exist in peer-type
+	// WARNING: in.Authentication requires manual conversion: does not exist in peer-type
+	// WARNING: in.Pricing requires manual conversion: does not exist in peer-type
+	// WARNING: in.Datadog requires manual conversion: does not exist in peer-type
 	// NB(bradbeam): The following is okay; we will not handle down converting URL
 	// WARNING: in.URL requires manual conversion: does not exist in peer-type
 	return nil
@@ -847,14 +859,14 @@ func Convert_v1alpha1_NamespaceTemplateSpec_To_v1beta1_NamespaceTemplateSpec(in
 func autoConvert_v1beta1_NamespaceTemplateSpec_To_v1alpha1_NamespaceTemplateSpec(in *v1beta1.NamespaceTemplateSpec, out *NamespaceTemplateSpec, s conversion.Scope) error {
 	out.ObjectMeta = in.ObjectMeta
 	out.Spec = in.Spec
+	// WARNING: in.ResourceQuota requires manual conversion: does not exist in peer-type
+	// WARNING: in.LimitRange requires manual conversion: does not exist in peer-type
+	// WARNING: in.NetworkPolicy requires manual conversion: does not exist in peer-type
+	// WARNING: in.TTLSecondsAfterFinished requires manual conversion: does not exist in peer-type
+	// WARNING: in.PoolSize requires manual conversion: does not exist in peer-type
 	return nil
 }
 
-// Convert_v1beta1_NamespaceTemplateSpec_To_v1alpha1_NamespaceTemplateSpec is an autogenerated conversion function.
-func Convert_v1beta1_NamespaceTemplateSpec_To_v1alpha1_NamespaceTemplateSpec(in *v1beta1.NamespaceTemplateSpec, out *NamespaceTemplateSpec, s conversion.Scope) error {
-	return autoConvert_v1beta1_NamespaceTemplateSpec_To_v1alpha1_NamespaceTemplateSpec(in, out, s)
-}
-
 func autoConvert_v1alpha1_Optimization_To_v1beta1_Optimization(in *Optimization, out *v1beta1.Optimization, s conversion.Scope) error {
 	out.Name = in.Name
 	out.Value = in.Value
@@ -1416,13 +1428,20 @@ func autoConvert_v1beta1_TrialSpec_To_v1alpha1_TrialSpec(in *v1beta1.TrialSpec,
 	} else {
 		out.Assignments = nil
 	}
+	// WARNING: in.AssignmentsDelivery requires manual conversion: does not exist in peer-type
 	out.Selector = in.Selector
 	// WARNING: in.JobTemplate requires manual conversion: does not exist in peer-type
+	// WARNING: in.ResultsFile requires manual conversion: does not exist in peer-type
+	// WARNING: in.RunTemplate requires manual conversion: does not exist in peer-type
 	out.InitialDelaySeconds = in.InitialDelaySeconds
 	out.StartTimeOffset = in.StartTimeOffset
 	out.ApproximateRuntime = in.ApproximateRuntime
+	// WARNING: in.RunTimeout requires manual conversion: does not exist in peer-type
+	// WARNING: in.SetupTimeout requires manual conversion: does not exist in peer-type
+	// WARNING: in.ReadinessTimeout requires manual conversion: does not exist in peer-type
 	out.TTLSecondsAfterFinished = in.TTLSecondsAfterFinished
 	out.TTLSecondsAfterFailure = in.TTLSecondsAfterFailure
+	// WARNING: in.RetryPolicy requires manual conversion: does not exist in peer-type
 	if in.ReadinessGates != nil {
 		in, out := &in.ReadinessGates, &out.ReadinessGates
 		*out = make([]TrialReadinessGate, len(*in))
@@ -1434,6 +1453,9 @@ func autoConvert_v1beta1_TrialSpec_To_v1alpha1_TrialSpec(in *v1beta1.TrialSpec,
 	} else {
 		out.ReadinessGates = nil
 	}
+	// WARNING: in.FailOnOOMKill requires manual conversion: does not exist in peer-type
+	// WARNING: in.FailOnRestart requires manual conversion: does not exist in peer-type
+	// WARNING: in.SkipCapacityCheck requires manual conversion: does not exist in peer-type
 	if in.Values != nil {
 		in, out := &in.Values, &out.Values
 		*out = make([]Value, len(*in))
@@ -1507,6 +1529,9 @@ func autoConvert_v1beta1_TrialStatus_To_v1alpha1_TrialStatus(in *v1beta1.TrialSt
 	}
 	// WARNING: in.PatchOperations requires manual conversion: does not exist in peer-type
 	// WARNING: in.ReadinessChecks requires manual conversion: does not exist in peer-type
+	// WARNING: in.MetricSamples requires manual conversion: does not exist in peer-type
+	// WARNING: in.TargetRestarts requires manual conversion: does not exist in peer-type
+	// WARNING: in.TargetOOMKills requires manual conversion: does not exist in peer-type
 	return nil
 }
 
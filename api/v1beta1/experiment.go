@@ -42,6 +42,14 @@ func (in *Experiment) SetReplicas(r int) {
 	}
 }
 
+// ReplicationCount returns the number of trials to run for each suggestion
+func (in *Experiment) ReplicationCount() int32 {
+	if in != nil && in.Spec.Replication != nil && in.Spec.Replication.Count > 1 {
+		return in.Spec.Replication.Count
+	}
+	return 1
+}
+
 // TrialSelector returns a label selector for matching trials associated with the experiment
 func (in *Experiment) TrialSelector() *metav1.LabelSelector {
 	if in.Spec.Selector != nil {
@@ -18,6 +18,7 @@ package v1beta1
 
 import (
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
@@ -93,8 +94,83 @@ const (
 	MetricDatadog MetricType = "datadog"
 	// MetricJSONPath metrics fetch a JSON resource from the matched service. Queries are JSON path expression evaluated against the resource.
 	MetricJSONPath MetricType = "jsonpath"
+	// MetricJob metrics parse the termination message of the trial run job containers as JSON. Queries are JSON path
+	// expressions evaluated against the message.
+	MetricJob MetricType = "job"
+	// MetricKubernetes metrics sample resource usage from the Kubernetes metrics API while the trial run job is executing.
+	// Queries are resource names (e.g. "cpu" or "memory"), optionally prefixed with "pods/" or "nodes/" (default "pods/").
+	MetricKubernetes MetricType = "kubernetes"
+	// MetricDerived metrics are computed from the values of other metrics in the same trial. Queries are arithmetic
+	// expressions referencing other metrics by name, the errors of the referenced metrics are propagated.
+	MetricDerived MetricType = "derived"
+	// MetricSynthetic metrics evaluate a well-known benchmark function using the trial assignments, primarily useful for
+	// testing. Queries are the function name (one of "branin", "rosenbrock", "hartmann3" or "hartmann6"), the error
+	// query is the optional standard deviation of noise added to the value.
+	MetricSynthetic MetricType = "synthetic"
+	// MetricCost metrics sample the hourly cost of the pods matched by the selector while the trial run job is executing.
+	// Queries are the basis of the cost, one of "requests" or "usage".
+	MetricCost MetricType = "cost"
 )
 
+// MetricAuthentication represents the credentials and TLS configuration used to connect to a metric endpoint. All of the
+// referenced secrets must be in the same namespace as the experiment.
+type MetricAuthentication struct {
+	// BearerToken selects a secret key whose value is sent as a bearer token in the "Authorization" header
+	BearerToken *corev1.SecretKeySelector `json:"bearerToken,omitempty"`
+	// BasicAuth selects the secret keys used for HTTP basic authentication
+	BasicAuth *MetricBasicAuth `json:"basicAuth,omitempty"`
+	// TLS is the TLS configuration used when the scheme is "https"
+	TLS *MetricTLSConfig `json:"tls,omitempty"`
+	// Headers are additional HTTP headers to include in each request
+	Headers []MetricHeader `json:"headers,omitempty"`
+}
+
+// MetricBasicAuth represents the secret keys used for HTTP basic authentication
+type MetricBasicAuth struct {
+	// Username selects the secret key containing the username
+	Username corev1.SecretKeySelector `json:"username"`
+	// Password selects the secret key containing the password
+	Password corev1.SecretKeySelector `json:"password"`
+}
+
+// MetricTLSConfig represents the TLS configuration used to connect to a metric endpoint
+type MetricTLSConfig struct {
+	// CA selects the secret key containing the PEM encoded certificate authority bundle used to verify the server
+	CA *corev1.SecretKeySelector `json:"ca,omitempty"`
+	// Cert selects the secret key containing the PEM encoded client certificate
+	Cert *corev1.SecretKeySelector `json:"cert,omitempty"`
+	// Key selects the secret key containing the PEM encoded client certificate private key
+	Key *corev1.SecretKeySelector `json:"key,omitempty"`
+	// ServerName is used to verify the host name of the server, defaults to the host of the request
+	ServerName string `json:"serverName,omitempty"`
+	// InsecureSkipVerify disables verification of the server certificate
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// MetricHeader represents an additional HTTP header used when collecting a metric
+type MetricHeader struct {
+	// The name of the header
+	Name string `json:"name"`
+	// The value of the header
+	Value string `json:"value,omitempty"`
+	// ValueFrom selects a secret key containing the value of the header
+	ValueFrom *corev1.SecretKeySelector `json:"valueFrom,omitempty"`
+}
+
+// DatadogMetricConfig represents the configuration used to collect a metric from the Datadog API
+type DatadogMetricConfig struct {
+	// Site is the Datadog site hosting the account, e.g. "datadoghq.com" or "datadoghq.eu"; defaults to the
+	// "DATADOG_HOST" environment variable or the US site
+	Site string `json:"site,omitempty"`
+	// APIKey selects the secret key containing the Datadog API key, defaults to the "DATADOG_API_KEY" environment variable
+	APIKey *corev1.SecretKeySelector `json:"apiKey,omitempty"`
+	// ApplicationKey selects the secret key containing the Datadog application key, defaults to the "DATADOG_APP_KEY"
+	// environment variable
+	ApplicationKey *corev1.SecretKeySelector `json:"applicationKey,omitempty"`
+	// SeriesAggregation used to combine the values of multiple series, one of: avg|max|min|last|sum|pNN, default: avg
+	SeriesAggregation string `json:"seriesAggregation,omitempty"`
+}
+
 // Metric represents an observable outcome from a trial run
 type Metric struct {
 	// The name of the metric
@@ -102,24 +178,50 @@ type Metric struct {
 	// Indicator that the goal of the experiment is to minimize the value of this metric
 	Minimize bool `json:"minimize,omitempty"`
 
-	// The metric collection type, one of: local|pods|prometheus|datadog|jsonpath, default: local
+	// The metric collection type, one of: local|pods|prometheus|datadog|jsonpath|job|kubernetes|derived|synthetic|cost, default: local
 	Type MetricType `json:"type,omitempty"`
-	// Collection type specific query, e.g. Go template for "local", PromQL for "prometheus" or a JSON pointer expression (with curly braces) for "jsonpath"
+	// Collection type specific query, e.g. Go template for "local", PromQL for "prometheus" or a JSON pointer expression (with curly braces) for "jsonpath" and "job"
 	Query string `json:"query"`
 	// Collection type specific query for the error associated with collected metric value
 	ErrorQuery string `json:"errorQuery,omitempty"`
+	// Aggregation used to reduce multiple observations to a single value, one of: avg|max|min|last|sum|pNN, default: avg
+	Aggregation string `json:"aggregation,omitempty"`
+	// The resolution of a "prometheus" range query, when set the query is evaluated over the entire trial run instead of
+	// only at the completion time and the results are reduced using the aggregation
+	Step *metav1.Duration `json:"step,omitempty"`
+	// The number of times a "prometheus" or "datadog" metric is sampled, when greater than one the trial run is divided
+	// into equal sub-windows and the query is evaluated for each one; the value is the mean of the samples and the error
+	// is their standard deviation
+	Samples int32 `json:"samples,omitempty"`
+	// The maximum amount of time allowed to capture the metric value, defaults to 30 seconds
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
 
 	// The scheme to use when collecting metrics
 	Scheme string `json:"scheme,omitempty"`
 	// Selector matching services to collect this metric from, only the first matched service to provide a value is used
 	Selector *metav1.LabelSelector `json:"selector,omitempty"`
-	// The port number or name on the matched service to collect the metric value from
+	// PodSelector matching pods in the trial namespace to collect this metric from directly, mutually exclusive with Selector
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+	// Aggregation used to combine the values collected from individual pods (matched by the pod selector or the endpoints
+	// of a headless service), one of: avg|max|min|last|sum|pNN, default: avg
+	PodAggregation string `json:"podAggregation,omitempty"`
+	// The port number or name on the matched service (or pod) to collect the metric value from
 	Port intstr.IntOrString `json:"port,omitempty"`
 	// URL path component used to collect the metric value from an endpoint (used as a prefix for the Prometheus API)
 	Path string `json:"path,omitempty"`
+	// The HTTP method used to collect a "jsonpath" metric, defaults to "POST" if a body is specified, otherwise "GET"
+	Method string `json:"method,omitempty"`
+	// The HTTP request body used to collect a "jsonpath" metric, evaluated as a Go template using the same rules as queries
+	Body string `json:"body,omitempty"`
+	// Authentication used to collect the metric value from an endpoint, only used for "prometheus" and "jsonpath" metrics
+	Authentication *MetricAuthentication `json:"authentication,omitempty"`
+	// Pricing selects the config map key containing the resource prices, only used for "cost" metrics
+	Pricing *corev1.ConfigMapKeySelector `json:"pricing,omitempty"`
+	// Datadog specific configuration, only used for "datadog" metrics
+	Datadog *DatadogMetricConfig `json:"datadog,omitempty"`
 	// URL to query for fetching metrics.
 	// If this parameter is specified, it will be preferred over Scheme, Selector, Port, and Path.
-	// This is only used for MetricPrometheus and MetricJSONPath metric types.
+	// This is only used for MetricPrometheus, MetricJSONPath and MetricDatadog metric types.
 	URL string `json:"-"`
 }
 
@@ -163,6 +265,20 @@ type NamespaceTemplateSpec struct {
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 	// Specification of the namespace
 	Spec corev1.NamespaceSpec `json:"spec,omitempty"`
+	// ResourceQuota is used to create a quota named "redsky-trial-quota" in each namespace created from the template
+	ResourceQuota *corev1.ResourceQuotaSpec `json:"resourceQuota,omitempty"`
+	// LimitRange is used to create a limit range named "redsky-trial-limits" in each namespace created from the
+	// template
+	LimitRange *corev1.LimitRangeSpec `json:"limitRange,omitempty"`
+	// NetworkPolicy is used to create a network policy named "redsky-trial-network-policy" in each namespace created
+	// from the template
+	NetworkPolicy *networkingv1.NetworkPolicySpec `json:"networkPolicy,omitempty"`
+	// TTLSecondsAfterFinished limits the lifetime of a namespace created from the template once all of the trials
+	// that ran in it have finished; created namespaces are always deleted when the experiment is deleted
+	TTLSecondsAfterFinished *int32 `json:"ttlSecondsAfterFinished,omitempty"`
+	// PoolSize is the number of namespaces created from the template ahead of time and reused between trials; when
+	// set, trials only run in pooled namespaces and the objects created by setup tasks are kept between trials
+	PoolSize int32 `json:"poolSize,omitempty"`
 }
 
 // TrialTemplateSpec is used as a template for creating new trials
@@ -173,10 +289,68 @@ type TrialTemplateSpec struct {
 	Spec TrialSpec `json:"spec,omitempty"`
 }
 
+// AbortConditionType represents the allowable types of abort conditions
+type AbortConditionType string
+
+const (
+	// AbortPrometheus aborts the trial when the value of a Prometheus query exceeds the threshold
+	AbortPrometheus AbortConditionType = "prometheus"
+	// AbortRestarts aborts the trial when the number of container restarts exceeds the threshold
+	AbortRestarts AbortConditionType = "restarts"
+	// AbortOOMKilled aborts the trial when a container is terminated for exceeding its memory limit
+	AbortOOMKilled AbortConditionType = "oomKilled"
+)
+
+// AbortCondition is checked periodically while the trial run job is executing, if the condition is met the trial run
+// job is terminated and the trial fails
+type AbortCondition struct {
+	// The name of the abort condition
+	Name string `json:"name"`
+	// The type of check, one of: prometheus|restarts|oomKilled
+	Type AbortConditionType `json:"type"`
+	// The Prometheus query to evaluate, the query is a Go template evaluated using the same rules as metric queries
+	Query string `json:"query,omitempty"`
+	// The trial is aborted when the value of the query or the number of restarts is greater than the threshold,
+	// defaults to 0
+	Threshold *resource.Quantity `json:"threshold,omitempty"`
+	// Selector matching the Prometheus services for "prometheus" conditions, otherwise the pods to check; by default
+	// all of the pods in the trial namespace that are not part of the trial setup or run jobs are checked
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// The scheme used to connect to Prometheus
+	Scheme string `json:"scheme,omitempty"`
+	// The port number or name on the matched Prometheus service
+	Port intstr.IntOrString `json:"port,omitempty"`
+	// URL path component used as a prefix for the Prometheus API
+	Path string `json:"path,omitempty"`
+}
+
+// TrialReplication describes how the assignments of a single suggestion are run multiple times
+type TrialReplication struct {
+	// Count is the number of trials run for each suggestion, defaults to 1
+	Count int32 `json:"count,omitempty"`
+	// Parallel allows the replica trials to run concurrently (subject to the experiment replicas and the available
+	// namespaces), otherwise each replica trial is started after the previous replica finishes
+	Parallel bool `json:"parallel,omitempty"`
+}
+
+// ApplicationClone describes the application objects copied into each trial namespace
+type ApplicationClone struct {
+	// Namespace is the namespace containing the original application objects, defaults to the experiment namespace
+	Namespace string `json:"namespace,omitempty"`
+	// Selector matches additional supporting objects to copy from the source namespace
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+	// Kinds are the types of supporting objects matched by the selector, defaults to config maps, secrets, services,
+	// service accounts, deployments and stateful sets
+	Kinds []metav1.GroupVersionKind `json:"kinds,omitempty"`
+}
+
 // ExperimentSpec defines the desired state of Experiment
 type ExperimentSpec struct {
 	// Replicas is the number of trials to execute concurrently, defaults to 1
 	Replicas *int32 `json:"replicas,omitempty"`
+	// Replication runs the assignments of each suggestion multiple times, the values of the replica trials are
+	// aggregated into a single observation; the retry policy of the trial template is not applied to replica trials
+	Replication *TrialReplication `json:"replication,omitempty"`
 	// Optimization defines additional configuration for the optimization
 	Optimization []Optimization `json:"optimization,omitempty"`
 	// Parameters defines the search space for the experiment
@@ -188,11 +362,16 @@ type ExperimentSpec struct {
 	// Patches is a sequence of templates written against the experiment parameters that will be used to put the
 	// cluster into the desired state
 	Patches []PatchTemplate `json:"patches,omitempty"`
+	// AbortConditions are checked while the trial run job is executing to stop unsuccessful trials early
+	AbortConditions []AbortCondition `json:"abortConditions,omitempty"`
 	// NamespaceSelector is used to locate existing namespaces for trials
 	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
 	// NamespaceTemplate can be specified to create new namespaces for trials; if specified created namespaces must be
 	// matched by the namespace selector
 	NamespaceTemplate *NamespaceTemplateSpec `json:"namespaceTemplate,omitempty"`
+	// Clone copies the patch targets (and any matching supporting objects) from a source namespace into each trial
+	// namespace before the patches are applied
+	Clone *ApplicationClone `json:"clone,omitempty"`
 	// Selector locates trial resources that are part of this experiment
 	Selector *metav1.LabelSelector `json:"selector,omitempty"`
 	// TrialTemplate for creating a new trial. The resulting trial must be matched by Selector. The template can provide an
@@ -201,12 +380,41 @@ type ExperimentSpec struct {
 	TrialTemplate TrialTemplateSpec `json:"trialTemplate,omitempty"`
 }
 
+// NamespacePoolStatus describes the health of the namespace pool
+type NamespacePoolStatus struct {
+	// Size is the desired number of pooled namespaces
+	Size int32 `json:"size"`
+	// Ready is the number of pooled namespaces which exist and are not being deleted
+	Ready int32 `json:"ready"`
+	// InUse is the number of pooled namespaces with an active trial
+	InUse int32 `json:"inUse"`
+	// Warm is the number of idle pooled namespaces that already have the setup tasks applied
+	Warm int32 `json:"warm"`
+}
+
+// NamespaceFailure describes why trial namespaces could not be created from the namespace template
+type NamespaceFailure struct {
+	// Message describes the error which prevented the namespace from being created
+	Message string `json:"message"`
+	// TemplateHash identifies the namespace template which failed, namespaces are not created from the same template
+	// until the failure is retried
+	TemplateHash string `json:"templateHash"`
+	// LastAttemptTime is the last time namespace creation failed
+	LastAttemptTime metav1.Time `json:"lastAttemptTime"`
+}
+
 // ExperimentStatus defines the observed state of Experiment
 type ExperimentStatus struct {
 	// Phase is a brief human readable description of the experiment status
 	Phase string `json:"phase"`
 	// ActiveTrials is the observed number of running trials
 	ActiveTrials int32 `json:"activeTrials"`
+	// CreatedNamespaces are the names of the namespaces created from the namespace template which still exist
+	CreatedNamespaces []string `json:"createdNamespaces,omitempty"`
+	// NamespacePool is the observed state of the namespace pool
+	NamespacePool *NamespacePoolStatus `json:"namespacePool,omitempty"`
+	// NamespaceFailure is the most recent failure to create a namespace from the namespace template
+	NamespaceFailure *NamespaceFailure `json:"namespaceFailure,omitempty"`
 	// TODO Number of trials: Succeeded, Failed int32 (this would need to be fetch remotely, falling back to the in cluster count)
 }
 
@@ -21,6 +21,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
@@ -172,6 +173,16 @@ type Value struct {
 	AttemptsRemaining int `json:"attemptsRemaining,omitempty"`
 }
 
+// MetricSamples represents the observations of a metric that is sampled while the trial run job is executing
+type MetricSamples struct {
+	// The metric name the samples correspond to
+	Name string `json:"name"`
+	// The observed float64 values, formatted as strings
+	Values []string `json:"values,omitempty"`
+	// LastSampleTime is the timestamp of the last observation
+	LastSampleTime *metav1.Time `json:"lastSampleTime,omitempty"`
+}
+
 // TrialConditionType represents the possible observable conditions for a trial
 type TrialConditionType string
 
@@ -208,6 +219,78 @@ type TrialCondition struct {
 	Message string `json:"message,omitempty"`
 }
 
+// AssignmentsFormat is the format used to render assignments to a file
+type AssignmentsFormat string
+
+const (
+	// AssignmentsJSON renders assignments as a JSON object
+	AssignmentsJSON AssignmentsFormat = "json"
+	// AssignmentsYAML renders assignments as a YAML document
+	AssignmentsYAML AssignmentsFormat = "yaml"
+	// AssignmentsProperties renders assignments as Java style properties
+	AssignmentsProperties AssignmentsFormat = "properties"
+)
+
+// AssignmentsDelivery describes how assignments are made available to the trial run and setup task containers
+type AssignmentsDelivery struct {
+	// EnvPrefix is prepended to the name of each assignment environment variable
+	EnvPrefix string `json:"envPrefix,omitempty"`
+	// EnvCase is the case of the assignment environment variable names, one of "upper" (default), "lower" or "preserve"
+	EnvCase string `json:"envCase,omitempty"`
+	// NormalizeEnv replaces every character which is not valid in an environment variable name (e.g. "-") with an
+	// underscore, by default only "." is replaced
+	NormalizeEnv bool `json:"normalizeEnv,omitempty"`
+	// DisableEnv prevents the assignments from being added as environment variables
+	DisableEnv bool `json:"disableEnv,omitempty"`
+	// FilePath is the absolute path of a file containing all of the assignments, no file is mounted if empty
+	FilePath string `json:"filePath,omitempty"`
+	// FileFormat is the format of the assignments file, one of "json" (default), "yaml" or "properties"
+	FileFormat AssignmentsFormat `json:"fileFormat,omitempty"`
+}
+
+// TrialFailureClass identifies the stage of the trial lifecycle at which a trial failed
+type TrialFailureClass string
+
+const (
+	// FailureSetup indicates a setup task failed
+	FailureSetup TrialFailureClass = "setup"
+	// FailurePatch indicates a patch could not be applied
+	FailurePatch TrialFailureClass = "patch"
+	// FailureReadiness indicates the trial did not become ready
+	FailureReadiness TrialFailureClass = "readiness"
+	// FailureJob indicates the trial run job failed
+	FailureJob TrialFailureClass = "job"
+	// FailureMetric indicates a metric could not be collected
+	FailureMetric TrialFailureClass = "metric"
+)
+
+// TrialRetryPolicy describes how failed trials are retried before the failure is reported
+type TrialRetryPolicy struct {
+	// Limit is the maximum number of times the assignments of a failed trial are retried
+	Limit int32 `json:"limit"`
+	// RetryOn is the list of failure classes which are retried, defaults to all failure classes
+	RetryOn []TrialFailureClass `json:"retryOn,omitempty"`
+}
+
+// TrialRunTemplate describes an arbitrary resource (e.g. a workflow or a custom resource) used as the trial run
+type TrialRunTemplate struct {
+	// Resource is the resource to create for the trial run, it must include the API version and kind and may be a Go template
+	// +kubebuilder:pruning:PreserveUnknownFields
+	// +kubebuilder:validation:EmbeddedResource
+	Resource runtime.RawExtension `json:"resource"`
+	// SuccessCondition is a selector expression over the fields of the resource which indicates the trial run succeeded,
+	// e.g. "status.phase == Succeeded"
+	SuccessCondition string `json:"successCondition"`
+	// FailureCondition is a selector expression over the fields of the resource which indicates the trial run failed,
+	// e.g. "status.phase in (Failed, Error)"
+	FailureCondition string `json:"failureCondition,omitempty"`
+	// StartTimePath is a JSONPath expression for the time the trial run started, defaults to the creation timestamp
+	StartTimePath string `json:"startTimePath,omitempty"`
+	// CompletionTimePath is a JSONPath expression for the time the trial run finished, defaults to the time the
+	// success or failure condition was first observed
+	CompletionTimePath string `json:"completionTimePath,omitempty"`
+}
+
 // TrialSpec defines the desired state of Trial
 type TrialSpec struct {
 	// ExperimentRef is the reference to the experiment that contains the definitions to use for this trial,
@@ -215,22 +298,45 @@ type TrialSpec struct {
 	ExperimentRef *corev1.ObjectReference `json:"experimentRef,omitempty"`
 	// Assignments are used to patch the cluster state prior to the trial run
 	Assignments []Assignment `json:"assignments,omitempty"`
+	// AssignmentsDelivery controls how the assignments are exposed to the trial run and setup task containers
+	AssignmentsDelivery *AssignmentsDelivery `json:"assignmentsDelivery,omitempty"`
 	// Selector matches the job representing the trial run
 	Selector *metav1.LabelSelector `json:"selector,omitempty"`
 	// JobTemplate is the job template used to create trial run jobs
 	JobTemplate *batchv1beta1.JobTemplateSpec `json:"jobTemplate,omitempty"`
+	// ResultsFile mounts a shared directory into the trial run job containers, a JSON results file written to the path
+	// in the REDSKY_RESULTS_FILE environment variable is reported by an additional container and used by "job" metrics
+	ResultsFile bool `json:"resultsFile,omitempty"`
+	// RunTemplate is the template of an arbitrary resource used for the trial run instead of a job
+	RunTemplate *TrialRunTemplate `json:"runTemplate,omitempty"`
 	// InitialDelaySeconds is number of seconds to wait after a trial becomes ready before starting the trial run job
 	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
 	// The offset used to adjust the start time to account for spin up of the trial run
 	StartTimeOffset *metav1.Duration `json:"startTimeOffset,omitempty"`
 	// The approximate amount of time the trial run should execute (not inclusive of the start time offset)
 	ApproximateRuntime *metav1.Duration `json:"approximateRuntime,omitempty"`
+	// The maximum amount of time the trial run may take (measured from when the trial became ready, inclusive of the
+	// initial delay) before it is stopped and the trial is failed
+	RunTimeout *metav1.Duration `json:"runTimeout,omitempty"`
+	// The maximum amount of time a setup task job may take before it is stopped and the trial is failed
+	SetupTimeout *metav1.Duration `json:"setupTimeout,omitempty"`
+	// The maximum amount of time to wait for the trial to become ready before the trial is failed
+	ReadinessTimeout *metav1.Duration `json:"readinessTimeout,omitempty"`
 	// The minimum number of seconds before an attempt should be made to clean up the trial, if unset or negative no attempt is made to clean up the trial
 	TTLSecondsAfterFinished *int32 `json:"ttlSecondsAfterFinished,omitempty"`
 	// The minimum number of seconds before an attempt should be made to clean up a failed trial, defaults to TTLSecondsAfterFinished
 	TTLSecondsAfterFailure *int32 `json:"ttlSecondsAfterFailure,omitempty"`
+	// The retry policy for trials which fail due to transient problems
+	RetryPolicy *TrialRetryPolicy `json:"retryPolicy,omitempty"`
 	// The readiness gates to check before running the trial job
 	ReadinessGates []TrialReadinessGate `json:"readinessGates,omitempty"`
+	// FailOnOOMKill fails the trial if a container in the pods of a patched target is OOMKilled during the trial run
+	FailOnOOMKill bool `json:"failOnOOMKill,omitempty"`
+	// FailOnRestart fails the trial if a container in the pods of a patched target restarts during the trial run
+	FailOnRestart bool `json:"failOnRestart,omitempty"`
+	// SkipCapacityCheck disables the estimate of whether the patched resource requests fit on the cluster nodes,
+	// e.g. when a cluster autoscaler can add nodes on demand
+	SkipCapacityCheck bool `json:"skipCapacityCheck,omitempty"`
 
 	// Values are the collected metrics at the end of the trial run
 	Values []Value `json:"values,omitempty"`
@@ -265,6 +371,12 @@ type TrialStatus struct {
 	PatchOperations []PatchOperation `json:"patchOperations,omitempty"`
 	// ReadinessChecks are the all of the objects whose conditions need to be inspected for this trial
 	ReadinessChecks []ReadinessCheck `json:"readinessChecks,omitempty"`
+	// MetricSamples are the observations of metrics which are sampled while the trial run job is executing
+	MetricSamples []MetricSamples `json:"metricSamples,omitempty"`
+	// TargetRestarts is the number of container restarts observed in the pods of the patched targets during the trial run
+	TargetRestarts int32 `json:"targetRestarts,omitempty"`
+	// TargetOOMKills is the number of OOMKilled containers observed in the pods of the patched targets during the trial run
+	TargetOOMKills int32 `json:"targetOOMKills,omitempty"`
 }
 
 // +genclient
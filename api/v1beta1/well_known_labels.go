@@ -25,9 +25,16 @@ const (
 	AnnotationNextTrialURL = "redskyops.dev/next-trial-url"
 	// AnnotationReportTrialURL is the URL used to report trial observations
 	AnnotationReportTrialURL = "redskyops.dev/report-trial-url"
+	// AnnotationNamespaceTemplate is the namespace and name of the experiment whose namespace template was used to
+	// create a namespace
+	AnnotationNamespaceTemplate = "redskyops.dev/namespace-template"
+	// AnnotationSetupHash is a hash of the setup task configuration currently applied to a pooled namespace
+	AnnotationSetupHash = "redskyops.dev/setup-hash"
 
 	// LabelExperiment is the name of the experiment associated with an object
 	LabelExperiment = "redskyops.dev/experiment"
+	// LabelNamespacePool is the name of the experiment whose namespace pool a namespace belongs to
+	LabelNamespacePool = "redskyops.dev/namespace-pool"
 )
 
 // Trial labels and annotations
@@ -36,9 +43,17 @@ const (
 	// AnnotationInitializer is a comma-delimited list of initializing processes. Similar to a "finalizer", the trial
 	// will not start executing until the initializer is empty.
 	AnnotationInitializer = "redskyops.dev/initializer"
+	// AnnotationTrialRetries is the number of times the assignments of a trial have been retried after a failure
+	AnnotationTrialRetries = "redskyops.dev/trial-retries"
+	// AnnotationTrialRetriedBy is the name of the trial which replaced a failed trial
+	AnnotationTrialRetriedBy = "redskyops.dev/trial-retried-by"
 
 	// LabelTrial contains the name of the trial associated with an object
 	LabelTrial = "redskyops.dev/trial"
 	// LabelTrialRole contains the role in trial execution
 	LabelTrialRole = "redskyops.dev/trial-role"
+	// LabelTrialGroup contains the name of the first trial in a group of replica trials sharing the same assignments
+	LabelTrialGroup = "redskyops.dev/trial-group"
+	// LabelTrialReplica contains the index of a replica trial within its group
+	LabelTrialReplica = "redskyops.dev/trial-replica"
 )
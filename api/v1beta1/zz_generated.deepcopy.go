@@ -22,12 +22,64 @@ package v1beta1
 
 import (
 	batchv1beta1 "k8s.io/api/batch/v1beta1"
-	corev1 "k8s.io/api/core/v1"
+	"k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
-	"k8s.io/apimachinery/pkg/apis/meta/v1"
-	runtime "k8s.io/apimachinery/pkg/runtime"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AbortCondition) DeepCopyInto(out *AbortCondition) {
+	*out = *in
+	if in.Threshold != nil {
+		in, out := &in.Threshold, &out.Threshold
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	out.Port = in.Port
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AbortCondition.
+func (in *AbortCondition) DeepCopy() *AbortCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(AbortCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ApplicationClone) DeepCopyInto(out *ApplicationClone) {
+	*out = *in
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Kinds != nil {
+		in, out := &in.Kinds, &out.Kinds
+		*out = make([]metav1.GroupVersionKind, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ApplicationClone.
+func (in *ApplicationClone) DeepCopy() *ApplicationClone {
+	if in == nil {
+		return nil
+	}
+	out := new(ApplicationClone)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Assignment) DeepCopyInto(out *Assignment) {
 	*out = *in
@@ -43,6 +95,21 @@ func (in *Assignment) DeepCopy() *Assignment {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *AssignmentsDelivery) DeepCopyInto(out *AssignmentsDelivery) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new AssignmentsDelivery.
+func (in *AssignmentsDelivery) DeepCopy() *AssignmentsDelivery {
+	if in == nil {
+		return nil
+	}
+	out := new(AssignmentsDelivery)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ConfigMapHelmValuesFromSource) DeepCopyInto(out *ConfigMapHelmValuesFromSource) {
 	*out = *in
@@ -84,13 +151,38 @@ func (in *Constraint) DeepCopy() *Constraint {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *DatadogMetricConfig) DeepCopyInto(out *DatadogMetricConfig) {
+	*out = *in
+	if in.APIKey != nil {
+		in, out := &in.APIKey, &out.APIKey
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ApplicationKey != nil {
+		in, out := &in.ApplicationKey, &out.ApplicationKey
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new DatadogMetricConfig.
+func (in *DatadogMetricConfig) DeepCopy() *DatadogMetricConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(DatadogMetricConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Experiment) DeepCopyInto(out *Experiment) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Experiment.
@@ -151,6 +243,11 @@ func (in *ExperimentSpec) DeepCopyInto(out *ExperimentSpec) {
 		*out = new(int32)
 		**out = **in
 	}
+	if in.Replication != nil {
+		in, out := &in.Replication, &out.Replication
+		*out = new(TrialReplication)
+		**out = **in
+	}
 	if in.Optimization != nil {
 		in, out := &in.Optimization, &out.Optimization
 		*out = make([]Optimization, len(*in))
@@ -182,9 +279,16 @@ func (in *ExperimentSpec) DeepCopyInto(out *ExperimentSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.AbortConditions != nil {
+		in, out := &in.AbortConditions, &out.AbortConditions
+		*out = make([]AbortCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 	if in.NamespaceSelector != nil {
 		in, out := &in.NamespaceSelector, &out.NamespaceSelector
-		*out = new(v1.LabelSelector)
+		*out = new(metav1.LabelSelector)
 		(*in).DeepCopyInto(*out)
 	}
 	if in.NamespaceTemplate != nil {
@@ -192,9 +296,14 @@ func (in *ExperimentSpec) DeepCopyInto(out *ExperimentSpec) {
 		*out = new(NamespaceTemplateSpec)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.Clone != nil {
+		in, out := &in.Clone, &out.Clone
+		*out = new(ApplicationClone)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Selector != nil {
 		in, out := &in.Selector, &out.Selector
-		*out = new(v1.LabelSelector)
+		*out = new(metav1.LabelSelector)
 		(*in).DeepCopyInto(*out)
 	}
 	in.TrialTemplate.DeepCopyInto(&out.TrialTemplate)
@@ -213,6 +322,21 @@ func (in *ExperimentSpec) DeepCopy() *ExperimentSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ExperimentStatus) DeepCopyInto(out *ExperimentStatus) {
 	*out = *in
+	if in.CreatedNamespaces != nil {
+		in, out := &in.CreatedNamespaces, &out.CreatedNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NamespacePool != nil {
+		in, out := &in.NamespacePool, &out.NamespacePool
+		*out = new(NamespacePoolStatus)
+		**out = **in
+	}
+	if in.NamespaceFailure != nil {
+		in, out := &in.NamespaceFailure, &out.NamespaceFailure
+		*out = new(NamespaceFailure)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExperimentStatus.
@@ -289,12 +413,42 @@ func (in *HelmValuesFromSource) DeepCopy() *HelmValuesFromSource {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Metric) DeepCopyInto(out *Metric) {
 	*out = *in
+	if in.Step != nil {
+		in, out := &in.Step, &out.Step
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.Timeout != nil {
+		in, out := &in.Timeout, &out.Timeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
 	if in.Selector != nil {
 		in, out := &in.Selector, &out.Selector
-		*out = new(v1.LabelSelector)
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PodSelector != nil {
+		in, out := &in.PodSelector, &out.PodSelector
+		*out = new(metav1.LabelSelector)
 		(*in).DeepCopyInto(*out)
 	}
 	out.Port = in.Port
+	if in.Authentication != nil {
+		in, out := &in.Authentication, &out.Authentication
+		*out = new(MetricAuthentication)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Pricing != nil {
+		in, out := &in.Pricing, &out.Pricing
+		*out = new(v1.ConfigMapKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Datadog != nil {
+		in, out := &in.Datadog, &out.Datadog
+		*out = new(DatadogMetricConfig)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Metric.
@@ -307,11 +461,190 @@ func (in *Metric) DeepCopy() *Metric {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricAuthentication) DeepCopyInto(out *MetricAuthentication) {
+	*out = *in
+	if in.BearerToken != nil {
+		in, out := &in.BearerToken, &out.BearerToken
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.BasicAuth != nil {
+		in, out := &in.BasicAuth, &out.BasicAuth
+		*out = new(MetricBasicAuth)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(MetricTLSConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Headers != nil {
+		in, out := &in.Headers, &out.Headers
+		*out = make([]MetricHeader, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricAuthentication.
+func (in *MetricAuthentication) DeepCopy() *MetricAuthentication {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricAuthentication)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricBasicAuth) DeepCopyInto(out *MetricBasicAuth) {
+	*out = *in
+	in.Username.DeepCopyInto(&out.Username)
+	in.Password.DeepCopyInto(&out.Password)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricBasicAuth.
+func (in *MetricBasicAuth) DeepCopy() *MetricBasicAuth {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricBasicAuth)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricHeader) DeepCopyInto(out *MetricHeader) {
+	*out = *in
+	if in.ValueFrom != nil {
+		in, out := &in.ValueFrom, &out.ValueFrom
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricHeader.
+func (in *MetricHeader) DeepCopy() *MetricHeader {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricHeader)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricSamples) DeepCopyInto(out *MetricSamples) {
+	*out = *in
+	if in.Values != nil {
+		in, out := &in.Values, &out.Values
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastSampleTime != nil {
+		in, out := &in.LastSampleTime, &out.LastSampleTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricSamples.
+func (in *MetricSamples) DeepCopy() *MetricSamples {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricSamples)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricTLSConfig) DeepCopyInto(out *MetricTLSConfig) {
+	*out = *in
+	if in.CA != nil {
+		in, out := &in.CA, &out.CA
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Cert != nil {
+		in, out := &in.Cert, &out.Cert
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Key != nil {
+		in, out := &in.Key, &out.Key
+		*out = new(v1.SecretKeySelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricTLSConfig.
+func (in *MetricTLSConfig) DeepCopy() *MetricTLSConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricTLSConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespaceFailure) DeepCopyInto(out *NamespaceFailure) {
+	*out = *in
+	in.LastAttemptTime.DeepCopyInto(&out.LastAttemptTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespaceFailure.
+func (in *NamespaceFailure) DeepCopy() *NamespaceFailure {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespaceFailure)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NamespacePoolStatus) DeepCopyInto(out *NamespacePoolStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespacePoolStatus.
+func (in *NamespacePoolStatus) DeepCopy() *NamespacePoolStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NamespacePoolStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NamespaceTemplateSpec) DeepCopyInto(out *NamespaceTemplateSpec) {
 	*out = *in
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
+	if in.ResourceQuota != nil {
+		in, out := &in.ResourceQuota, &out.ResourceQuota
+		*out = new(v1.ResourceQuotaSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.LimitRange != nil {
+		in, out := &in.LimitRange, &out.LimitRange
+		*out = new(v1.LimitRangeSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NetworkPolicy != nil {
+		in, out := &in.NetworkPolicy, &out.NetworkPolicy
+		*out = new(networkingv1.NetworkPolicySpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.TTLSecondsAfterFinished != nil {
+		in, out := &in.TTLSecondsAfterFinished, &out.TTLSecondsAfterFinished
+		*out = new(int32)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NamespaceTemplateSpec.
@@ -425,7 +758,7 @@ func (in *PatchTemplate) DeepCopyInto(out *PatchTemplate) {
 	*out = *in
 	if in.TargetRef != nil {
 		in, out := &in.TargetRef, &out.TargetRef
-		*out = new(corev1.ObjectReference)
+		*out = new(v1.ObjectReference)
 		**out = **in
 	}
 	if in.ReadinessGates != nil {
@@ -451,7 +784,7 @@ func (in *ReadinessCheck) DeepCopyInto(out *ReadinessCheck) {
 	out.TargetRef = in.TargetRef
 	if in.Selector != nil {
 		in, out := &in.Selector, &out.Selector
-		*out = new(v1.LabelSelector)
+		*out = new(metav1.LabelSelector)
 		(*in).DeepCopyInto(*out)
 	}
 	if in.ConditionTypes != nil {
@@ -480,7 +813,7 @@ func (in *SetupTask) DeepCopyInto(out *SetupTask) {
 	*out = *in
 	if in.VolumeMounts != nil {
 		in, out := &in.VolumeMounts, &out.VolumeMounts
-		*out = make([]corev1.VolumeMount, len(*in))
+		*out = make([]v1.VolumeMount, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
@@ -631,7 +964,7 @@ func (in *TrialReadinessGate) DeepCopyInto(out *TrialReadinessGate) {
 	*out = *in
 	if in.Selector != nil {
 		in, out := &in.Selector, &out.Selector
-		*out = new(v1.LabelSelector)
+		*out = new(metav1.LabelSelector)
 		(*in).DeepCopyInto(*out)
 	}
 	if in.ConditionTypes != nil {
@@ -651,12 +984,63 @@ func (in *TrialReadinessGate) DeepCopy() *TrialReadinessGate {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TrialReplication) DeepCopyInto(out *TrialReplication) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrialReplication.
+func (in *TrialReplication) DeepCopy() *TrialReplication {
+	if in == nil {
+		return nil
+	}
+	out := new(TrialReplication)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TrialRetryPolicy) DeepCopyInto(out *TrialRetryPolicy) {
+	*out = *in
+	if in.RetryOn != nil {
+		in, out := &in.RetryOn, &out.RetryOn
+		*out = make([]TrialFailureClass, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrialRetryPolicy.
+func (in *TrialRetryPolicy) DeepCopy() *TrialRetryPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(TrialRetryPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TrialRunTemplate) DeepCopyInto(out *TrialRunTemplate) {
+	*out = *in
+	in.Resource.DeepCopyInto(&out.Resource)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrialRunTemplate.
+func (in *TrialRunTemplate) DeepCopy() *TrialRunTemplate {
+	if in == nil {
+		return nil
+	}
+	out := new(TrialRunTemplate)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *TrialSpec) DeepCopyInto(out *TrialSpec) {
 	*out = *in
 	if in.ExperimentRef != nil {
 		in, out := &in.ExperimentRef, &out.ExperimentRef
-		*out = new(corev1.ObjectReference)
+		*out = new(v1.ObjectReference)
 		**out = **in
 	}
 	if in.Assignments != nil {
@@ -664,9 +1048,14 @@ func (in *TrialSpec) DeepCopyInto(out *TrialSpec) {
 		*out = make([]Assignment, len(*in))
 		copy(*out, *in)
 	}
+	if in.AssignmentsDelivery != nil {
+		in, out := &in.AssignmentsDelivery, &out.AssignmentsDelivery
+		*out = new(AssignmentsDelivery)
+		**out = **in
+	}
 	if in.Selector != nil {
 		in, out := &in.Selector, &out.Selector
-		*out = new(v1.LabelSelector)
+		*out = new(metav1.LabelSelector)
 		(*in).DeepCopyInto(*out)
 	}
 	if in.JobTemplate != nil {
@@ -674,14 +1063,34 @@ func (in *TrialSpec) DeepCopyInto(out *TrialSpec) {
 		*out = new(batchv1beta1.JobTemplateSpec)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.RunTemplate != nil {
+		in, out := &in.RunTemplate, &out.RunTemplate
+		*out = new(TrialRunTemplate)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.StartTimeOffset != nil {
 		in, out := &in.StartTimeOffset, &out.StartTimeOffset
-		*out = new(v1.Duration)
+		*out = new(metav1.Duration)
 		**out = **in
 	}
 	if in.ApproximateRuntime != nil {
 		in, out := &in.ApproximateRuntime, &out.ApproximateRuntime
-		*out = new(v1.Duration)
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.RunTimeout != nil {
+		in, out := &in.RunTimeout, &out.RunTimeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.SetupTimeout != nil {
+		in, out := &in.SetupTimeout, &out.SetupTimeout
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+	if in.ReadinessTimeout != nil {
+		in, out := &in.ReadinessTimeout, &out.ReadinessTimeout
+		*out = new(metav1.Duration)
 		**out = **in
 	}
 	if in.TTLSecondsAfterFinished != nil {
@@ -694,6 +1103,11 @@ func (in *TrialSpec) DeepCopyInto(out *TrialSpec) {
 		*out = new(int32)
 		**out = **in
 	}
+	if in.RetryPolicy != nil {
+		in, out := &in.RetryPolicy, &out.RetryPolicy
+		*out = new(TrialRetryPolicy)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.ReadinessGates != nil {
 		in, out := &in.ReadinessGates, &out.ReadinessGates
 		*out = make([]TrialReadinessGate, len(*in))
@@ -715,7 +1129,7 @@ func (in *TrialSpec) DeepCopyInto(out *TrialSpec) {
 	}
 	if in.SetupVolumes != nil {
 		in, out := &in.SetupVolumes, &out.SetupVolumes
-		*out = make([]corev1.Volume, len(*in))
+		*out = make([]v1.Volume, len(*in))
 		for i := range *in {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
@@ -771,6 +1185,13 @@ func (in *TrialStatus) DeepCopyInto(out *TrialStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.MetricSamples != nil {
+		in, out := &in.MetricSamples, &out.MetricSamples
+		*out = make([]MetricSamples, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrialStatus.
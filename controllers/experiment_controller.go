@@ -18,6 +18,7 @@ package controllers
 
 import (
 	"context"
+	"time"
 
 	"github.com/go-logr/logr"
 	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
@@ -26,6 +27,7 @@ import (
 	"github.com/redskyops/redskyops-controller/internal/meta"
 	"github.com/redskyops/redskyops-controller/internal/trial"
 	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -37,11 +39,13 @@ import (
 // ExperimentReconciler reconciles an Experiment object
 type ExperimentReconciler struct {
 	client.Client
-	Log logr.Logger
+	Log       logr.Logger
+	apiReader client.Reader
 }
 
 // +kubebuilder:rbac:groups=redskyops.dev,resources=experiments;experiments/finalizers,verbs=get;list;watch;update
 // +kubebuilder:rbac:groups=redskyops.dev,resources=trials,verbs=list;watch;update;delete
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=list
 
 func (r *ExperimentReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
@@ -56,7 +60,12 @@ func (r *ExperimentReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error)
 		return ctrl.Result{}, err
 	}
 
-	if result, err := r.updateStatus(ctx, exp, trialList); result != nil {
+	namespaceList := &corev1.NamespaceList{}
+	if err := r.listCreatedNamespaces(ctx, exp, namespaceList); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if result, err := r.updateStatus(ctx, exp, trialList, namespaceList); result != nil {
 		return *result, err
 	}
 
@@ -68,10 +77,20 @@ func (r *ExperimentReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error)
 		return *result, err
 	}
 
-	return ctrl.Result{}, nil
+	if result, err := r.resizeNamespacePool(ctx, exp, trialList, namespaceList); result != nil {
+		return *result, err
+	}
+
+	if result, err := r.cleanupNamespaces(ctx, exp, trialList, namespaceList); result != nil {
+		return *result, err
+	}
+
+	// Permissions are not watched, poll until namespace creation can be retried
+	return ctrl.Result{RequeueAfter: experiment.NamespaceRetryAfter(exp, time.Now())}, nil
 }
 
 func (r *ExperimentReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.apiReader = mgr.GetAPIReader()
 	return ctrl.NewControllerManagedBy(mgr).
 		Named("experiment").
 		For(&redskyv1beta1.Experiment{}).
@@ -88,7 +107,7 @@ func trialToExperimentRequest(o handler.MapObject) []reconcile.Request {
 }
 
 // updateStatus will ensure the experiment and trial status matches the current state
-func (r *ExperimentReconciler) updateStatus(ctx context.Context, exp *redskyv1beta1.Experiment, trialList *redskyv1beta1.TrialList) (*ctrl.Result, error) {
+func (r *ExperimentReconciler) updateStatus(ctx context.Context, exp *redskyv1beta1.Experiment, trialList *redskyv1beta1.TrialList, namespaceList *corev1.NamespaceList) (*ctrl.Result, error) {
 	var dirty bool
 
 	// Update the HasTrialFinalizer
@@ -99,7 +118,10 @@ func (r *ExperimentReconciler) updateStatus(ctx context.Context, exp *redskyv1be
 	}
 
 	// Update the experiment status
+	dirty = experiment.UpdateNamespaceFailure(exp, namespaceList) || dirty
 	dirty = experiment.UpdateStatus(exp, trialList) || dirty
+	dirty = experiment.UpdateCreatedNamespaces(exp, namespaceList) || dirty
+	dirty = experiment.UpdatePoolStatus(exp, trialList, namespaceList) || dirty
 
 	// Only send an update if something actually changed
 	if dirty {
@@ -158,6 +180,95 @@ func (r *ExperimentReconciler) cleanupTrials(ctx context.Context, exp *redskyv1b
 	return nil, nil
 }
 
+// resizeNamespacePool creates or deletes namespaces so the experiment has the desired number of pooled namespaces
+func (r *ExperimentReconciler) resizeNamespacePool(ctx context.Context, exp *redskyv1beta1.Experiment, trialList *redskyv1beta1.TrialList, namespaceList *corev1.NamespaceList) (*ctrl.Result, error) {
+	create, excess := experiment.ResizePool(exp, trialList, namespaceList)
+	if create == 0 && len(excess) == 0 {
+		return nil, nil
+	}
+
+	for i := 0; i < create && experiment.NamespaceRetryAfter(exp, time.Now()) == 0; i++ {
+		name, err := experiment.CreatePoolNamespace(ctx, r, exp)
+		if experiment.IsNamespaceFailure(err) {
+			r.Log.Info("Unable to create pooled namespace", "experiment", exp.Name, "error", err.Error())
+			err := r.Update(ctx, exp)
+			return controller.RequeueConflict(err)
+		} else if err != nil {
+			return &ctrl.Result{}, err
+		}
+		if name == "" {
+			r.Log.Info("Unable to create pooled namespace", "experiment", exp.Name)
+			return nil, nil
+		}
+		r.Log.Info("Created pooled namespace", "namespace", name)
+	}
+
+	for _, n := range excess {
+		if err := r.Delete(ctx, n); apierrs.IsForbidden(err) {
+			r.Log.Info("Unable to delete excess pooled namespace", "namespace", n.Name, "error", err.Error())
+			return nil, nil
+		} else if controller.IgnoreNotFound(err) != nil {
+			return &ctrl.Result{}, err
+		}
+		r.Log.Info("Deleted excess pooled namespace", "namespace", n.Name)
+	}
+
+	// Update the status to reflect the new pool
+	return &ctrl.Result{Requeue: true}, nil
+}
+
+// cleanupNamespaces will delete any namespaces created from the namespace template whose TTL has expired or which
+// are no longer needed by a deleted experiment
+func (r *ExperimentReconciler) cleanupNamespaces(ctx context.Context, exp *redskyv1beta1.Experiment, trialList *redskyv1beta1.TrialList, namespaceList *corev1.NamespaceList) (*ctrl.Result, error) {
+	var requeueAfter time.Duration
+	now := time.Now()
+	for i := range namespaceList.Items {
+		n := &namespaceList.Items[i]
+		if !experiment.IsCreatedNamespace(exp, n) {
+			continue
+		}
+
+		cleanup, remaining := experiment.NamespaceNeedsCleanup(exp, n, trialList, now)
+		if cleanup {
+			// Namespace deletion requires the same additional permissions as namespace creation
+			if err := r.Delete(ctx, n); apierrs.IsForbidden(err) {
+				r.Log.Info("Unable to delete namespace created from template", "namespace", n.Name, "error", err.Error())
+
+				// Do not block the deletion of the experiment on namespaces we are not allowed to delete
+				if !exp.DeletionTimestamp.IsZero() && meta.RemoveFinalizer(exp, experiment.HasNamespaceFinalizer) {
+					r.Log.Info("Leaving namespaces created from template in place", "namespaces", exp.Status.CreatedNamespaces)
+					err := r.Update(ctx, exp)
+					return controller.RequeueConflict(err)
+				}
+				continue
+			} else if controller.IgnoreNotFound(err) != nil {
+				return &ctrl.Result{}, err
+			}
+			r.Log.Info("Deleted namespace created from template", "namespace", n.Name)
+			continue
+		}
+
+		// The namespace does not trigger a reconcile when its TTL expires
+		if remaining > 0 && (requeueAfter == 0 || remaining < requeueAfter) {
+			requeueAfter = remaining
+		}
+	}
+
+	if requeueAfter > 0 {
+		return &ctrl.Result{RequeueAfter: requeueAfter}, nil
+	}
+	return nil, nil
+}
+
+// listCreatedNamespaces retrieves the list of namespaces which may have been created from the namespace template
+func (r *ExperimentReconciler) listCreatedNamespaces(ctx context.Context, exp *redskyv1beta1.Experiment, namespaceList *corev1.NamespaceList) error {
+	if exp.Spec.NamespaceTemplate == nil && len(exp.Status.CreatedNamespaces) == 0 {
+		return nil
+	}
+	// Use the API reader to avoid creating extra namespaces for the pool because of a stale cache
+	return r.apiReader.List(ctx, namespaceList, experiment.CreatedNamespaceLabels(exp))
+}
+
 // listTrials retrieves the list of trial objects matching the specified selector
 func (r *ExperimentReconciler) listTrials(ctx context.Context, trialList *redskyv1beta1.TrialList, selector *metav1.LabelSelector) error {
 	matchingSelector, err := meta.MatchingSelector(selector)
@@ -20,6 +20,8 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
 	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
@@ -30,6 +32,8 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
@@ -39,22 +43,37 @@ type MetricReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+
+	// Keep the raw API reader for fetching secrets and config maps referenced by metrics, the endpoints of headless
+	// services and nodes; we only have get permissions on those objects and the standard caching reader would require
+	// list/watch. The pod logs are used to read the results file reported by the trial run job.
+	apiReader metric.PodLogReader
 }
 
 // +kubebuilder:rbac:groups=redskyops.dev,resources=experiments,verbs=get;list;watch
 // +kubebuilder:rbac:groups=redskyops.dev,resources=trials,verbs=get;list;watch;update
 // +kubebuilder:rbac:groups="",resources=pods,verbs=list
+// +kubebuilder:rbac:groups="",resources=pods/log,verbs=get
 // +kubebuilder:rbac:groups="",resources=services,verbs=list
+// +kubebuilder:rbac:groups="",resources=secrets,verbs=get
+// +kubebuilder:rbac:groups="",resources=endpoints,verbs=get
+// +kubebuilder:rbac:groups="",resources=configmaps,verbs=get
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get
+// +kubebuilder:rbac:groups=metrics.k8s.io,resources=pods;nodes,verbs=list
 
 func (r *MetricReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
 	now := metav1.Now()
 
 	t := &redskyv1beta1.Trial{}
-	if err := r.Get(ctx, req.NamespacedName, t); err != nil || r.ignoreTrial(t) {
+	if err := r.Get(ctx, req.NamespacedName, t); err != nil || r.ignoreTrial(ctx, t) {
 		return ctrl.Result{}, controller.IgnoreNotFound(err)
 	}
 
+	if result, err := r.sampleMetrics(ctx, t, &now); result != nil {
+		return *result, err
+	}
+
 	if result, err := r.evaluateMetrics(ctx, t, &now); result != nil {
 		return *result, err
 	}
@@ -67,13 +86,18 @@ func (r *MetricReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 }
 
 func (r *MetricReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	cs, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+	r.apiReader = metric.WithPodLogs(mgr.GetAPIReader(), cs.CoreV1())
 	return ctrl.NewControllerManagedBy(mgr).
 		Named("metric").
 		For(&redskyv1beta1.Trial{}).
 		Complete(r)
 }
 
-func (r *MetricReconciler) ignoreTrial(t *redskyv1beta1.Trial) bool {
+func (r *MetricReconciler) ignoreTrial(ctx context.Context, t *redskyv1beta1.Trial) bool {
 	// Ignore deleted trials
 	if !t.DeletionTimestamp.IsZero() {
 		return true
@@ -84,6 +108,15 @@ func (r *MetricReconciler) ignoreTrial(t *redskyv1beta1.Trial) bool {
 		return true
 	}
 
+	// Only consider running trials if they have metrics that need to be sampled
+	if t.Status.StartTime != nil && t.Status.CompletionTime == nil {
+		exp := &redskyv1beta1.Experiment{}
+		if err := r.Get(ctx, t.ExperimentNamespacedName(), exp); err != nil {
+			return controller.IgnoreNotFound(err) == nil
+		}
+		return !metric.HasSampledMetrics(exp.Spec.Metrics)
+	}
+
 	// Ignore trials to do not have defined start/completion times
 	// NOTE: This checks the status to prevent needing to reproduce job start/completion lookup logic
 	if t.Status.StartTime == nil || t.Status.CompletionTime == nil {
@@ -106,6 +139,63 @@ func (r *MetricReconciler) ignoreTrial(t *redskyv1beta1.Trial) bool {
 	return true
 }
 
+func (r *MetricReconciler) sampleMetrics(ctx context.Context, t *redskyv1beta1.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
+	// Only sample metrics while the trial run job is executing
+	if t.Status.StartTime == nil || t.Status.CompletionTime != nil {
+		return nil, nil
+	}
+
+	// The start time may be offset, do not start sampling until it has passed
+	if probeTime.Before(t.Status.StartTime) {
+		return &ctrl.Result{RequeueAfter: t.Status.StartTime.Sub(probeTime.Time)}, nil
+	}
+
+	exp := &redskyv1beta1.Experiment{}
+	if err := r.Get(ctx, t.ExperimentNamespacedName(), exp); err != nil {
+		return &ctrl.Result{}, err
+	}
+
+	log := r.Log.WithValues("trial", fmt.Sprintf("%s/%s", t.Namespace, t.Name))
+	var requeueAfter time.Duration
+	var sampled bool
+	for i := range exp.Spec.Metrics {
+		m := &exp.Spec.Metrics[i]
+		if !metric.IsSampled(m) {
+			continue
+		}
+
+		s := findMetricSamples(&t.Status, m.Name)
+		if s.LastSampleTime != nil {
+			if wait := metric.NextSampleTime(t.Status.StartTime.Time, s.LastSampleTime.Time).Sub(probeTime.Time); wait > 0 {
+				if requeueAfter == 0 || wait < requeueAfter {
+					requeueAfter = wait
+				}
+				continue
+			}
+		}
+
+		// Individual sample failures are not fatal, the metric only fails if there are no samples to aggregate
+		s.LastSampleTime = probeTime.DeepCopy()
+		sampled = true
+		if target, err := r.sampleTarget(ctx, t, m); err != nil {
+			log.Error(err, "Metric sampling failed", "metric", m.Name)
+		} else if value, err := metric.SampleMetric(ctx, r.apiReader, m, t, target); err != nil {
+			log.Error(err, "Metric sampling failed", "metric", m.Name)
+		} else {
+			s.Values = metric.AppendSample(s.Values, value)
+		}
+	}
+
+	// Record the new samples, the update will trigger another reconcile to schedule the next sample
+	if sampled {
+		err := r.Update(ctx, t)
+		return controller.RequeueConflict(err)
+	}
+
+	// Metrics cannot be collected until the trial run job completes
+	return &ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
 func (r *MetricReconciler) evaluateMetrics(ctx context.Context, t *redskyv1beta1.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
 	// TODO This check precludes manual additions of Values
 	if len(t.Spec.Values) > 0 {
@@ -147,57 +237,185 @@ func (r *MetricReconciler) collectMetrics(ctx context.Context, t *redskyv1beta1.
 		metrics[exp.Spec.Metrics[i].Name] = &exp.Spec.Metrics[i]
 	}
 
-	// Iterate over the metric values, looking for remaining attempts
+	// Capture every metric value with remaining attempts, values that depend on other metrics are captured once their
+	// inputs are available so this may take several rounds
 	log := r.Log.WithValues("trial", fmt.Sprintf("%s/%s", t.Namespace, t.Name))
-	for i := range t.Spec.Values {
-		v := &t.Spec.Values[i]
-		if v.AttemptsRemaining == 0 {
-			continue
-		}
-
-		// Capture the metric
-		var captureError error
-		if target, err := r.target(ctx, t.Namespace, metrics[v.Name]); err != nil {
-			captureError = err
-		} else if value, stddev, err := metric.CaptureMetric(metrics[v.Name], t, target); err != nil {
-			if merr, ok := err.(*metric.CaptureError); ok && merr.RetryAfter > 0 {
+	attempted := make(map[string]bool, len(t.Spec.Values))
+	var retryAfter time.Duration
+	var collected bool
+	for values := pendingValues(t, metrics, attempted); len(values) > 0; values = pendingValues(t, metrics, attempted) {
+		results := r.captureValues(ctx, t, metrics, values)
+
+		var captured bool
+		for i, v := range values {
+			attempted[v.Name] = true
+			res := &results[i]
+			if merr, ok := res.err.(*metric.CaptureError); ok && merr.RetryAfter > 0 {
 				// Do not count retries against the remaining attempts
-				return &ctrl.Result{RequeueAfter: merr.RetryAfter}, nil
+				if retryAfter == 0 || merr.RetryAfter < retryAfter {
+					retryAfter = merr.RetryAfter
+				}
+				continue
 			}
-			captureError = err
-		} else {
-			v.AttemptsRemaining = 0
-			v.Value = strconv.FormatFloat(value, 'f', -1, 64)
-			if stddev != 0 {
-				v.Error = strconv.FormatFloat(stddev, 'f', -1, 64)
+
+			collected = true
+			if res.err == nil {
+				captured = true
+				v.AttemptsRemaining = 0
+				v.Value = strconv.FormatFloat(res.value, 'f', -1, 64)
+				if res.stddev != 0 {
+					v.Error = strconv.FormatFloat(res.stddev, 'f', -1, 64)
+				}
+				continue
 			}
-		}
 
-		// Handle any errors the occurred while collecting the value
-		if captureError != nil && v.AttemptsRemaining > 0 {
-			v.AttemptsRemaining = v.AttemptsRemaining - 1
-			if v.AttemptsRemaining == 0 {
-				trial.ApplyCondition(&t.Status, redskyv1beta1.TrialFailed, corev1.ConditionTrue, "MetricFailed", captureError.Error(), probeTime)
-				if merr, ok := captureError.(*metric.CaptureError); ok {
-					// Metric errors contain additional information which should be logged for debugging
-					log.Error(merr, "Metric collection failed", "address", merr.Address, "query", merr.Query, "completionTime", merr.CompletionTime)
+			// Handle any errors the occurred while collecting the value
+			if v.AttemptsRemaining > 0 {
+				v.AttemptsRemaining = v.AttemptsRemaining - 1
+				if v.AttemptsRemaining == 0 {
+					trial.ApplyCondition(&t.Status, redskyv1beta1.TrialFailed, corev1.ConditionTrue, trial.ReasonMetricFailed, res.err.Error(), probeTime)
+					if merr, ok := res.err.(*metric.CaptureError); ok {
+						// Metric errors contain additional information which should be logged for debugging
+						log.Error(merr, "Metric collection failed", "address", merr.Address, "query", merr.Query, "completionTime", merr.CompletionTime)
+					}
 				}
 			}
 		}
 
-		// We have started collecting metrics (success or fail), transition into a false status
+		// Only start another round if new values may have satisfied the dependencies of the remaining metrics
+		if !captured || trial.CheckCondition(&t.Status, redskyv1beta1.TrialFailed, corev1.ConditionTrue) {
+			break
+		}
+	}
+
+	// Nothing changed, wait for the metrics to become available
+	if !collected && retryAfter > 0 {
+		return &ctrl.Result{RequeueAfter: retryAfter}, nil
+	}
+
+	// Record all of the changes with a single update, the trial is observed once every value is done
+	if hasRemainingValues(t) {
 		trial.ApplyCondition(&t.Status, redskyv1beta1.TrialObserved, corev1.ConditionFalse, "", "", probeTime)
-		err := r.Update(ctx, t)
+	} else {
+		trial.ApplyCondition(&t.Status, redskyv1beta1.TrialObserved, corev1.ConditionTrue, "", "", probeTime)
+	}
+	if err := r.Update(ctx, t); err != nil {
 		return controller.RequeueConflict(err)
 	}
+	if retryAfter > 0 {
+		return &ctrl.Result{RequeueAfter: retryAfter}, nil
+	}
+	return &ctrl.Result{}, nil
+}
+
+// captureResult is the outcome of capturing a single metric value
+type captureResult struct {
+	value  float64
+	stddev float64
+	err    error
+}
+
+// captureValues concurrently captures the supplied values, each capture is limited by the timeout of the metric
+func (r *MetricReconciler) captureValues(ctx context.Context, t *redskyv1beta1.Trial, metrics map[string]*redskyv1beta1.Metric, values []*redskyv1beta1.Value) []captureResult {
+	results := make([]captureResult, len(values))
+	var wg sync.WaitGroup
+	for i := range values {
+		m, ok := metrics[values[i].Name]
+		if !ok {
+			results[i].err = fmt.Errorf("unknown metric: %s", values[i].Name)
+			continue
+		}
+
+		wg.Add(1)
+		go func(res *captureResult, m *redskyv1beta1.Metric) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, metric.Timeout(m))
+			defer cancel()
+
+			var target runtime.Object
+			if target, res.err = r.target(ctx, t, m); res.err == nil {
+				res.value, res.stddev, res.err = metric.CaptureMetric(ctx, r.apiReader, m, t, target)
+			}
+		}(&results[i], m)
+	}
+	wg.Wait()
+	return results
+}
+
+// pendingValues returns the values that need to be collected and have not been attempted yet, only values whose
+// dependencies have been captured are included; if no value is ready, the first remaining value is returned so the
+// unsatisfied dependency is reported
+func pendingValues(t *redskyv1beta1.Trial, metrics map[string]*redskyv1beta1.Metric, attempted map[string]bool) []*redskyv1beta1.Value {
+	var pending []*redskyv1beta1.Value
+	var next *redskyv1beta1.Value
+	for i := range t.Spec.Values {
+		v := &t.Spec.Values[i]
+		if v.AttemptsRemaining == 0 || attempted[v.Name] {
+			continue
+		}
+
+		if metric.DependenciesCaptured(metrics[v.Name], t) {
+			pending = append(pending, v)
+		} else if next == nil {
+			next = v
+		}
+	}
 
-	// We made it through all of the metrics without needing additional changes
-	trial.ApplyCondition(&t.Status, redskyv1beta1.TrialObserved, corev1.ConditionTrue, "", "", probeTime)
-	err := r.Update(ctx, t)
-	return controller.RequeueConflict(err)
+	if len(pending) == 0 && next != nil && len(attempted) == 0 {
+		return []*redskyv1beta1.Value{next}
+	}
+	return pending
 }
 
-func (r *MetricReconciler) target(ctx context.Context, namespace string, m *redskyv1beta1.Metric) (runtime.Object, error) {
+// hasRemainingValues checks to see if any values still need to be collected
+func hasRemainingValues(t *redskyv1beta1.Trial) bool {
+	for i := range t.Spec.Values {
+		if t.Spec.Values[i].AttemptsRemaining > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *MetricReconciler) sampleTarget(ctx context.Context, t *redskyv1beta1.Trial, m *redskyv1beta1.Metric) (runtime.Object, error) {
+	switch m.Type {
+	case redskyv1beta1.MetricKubernetes:
+		// Use the selector to get a list of resource usages from the metrics API
+		target, namespaced, err := metric.KubernetesTarget(m)
+		if err != nil {
+			return nil, err
+		}
+		opts := []client.ListOption{}
+		if namespaced {
+			opts = append(opts, client.InNamespace(t.Namespace))
+		}
+		if sel, err := meta.MatchingSelector(m.Selector); err != nil {
+			return nil, err
+		} else if err := r.List(ctx, target, append(opts, sel)...); err != nil {
+			return nil, err
+		}
+		return target, nil
+	case redskyv1beta1.MetricCost:
+		// Cost sampling requires several objects which are fetched using the API reader
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("metric type cannot be sampled: %s", m.Type)
+	}
+}
+
+// findMetricSamples returns the samples for the named metric, adding an empty entry if necessary
+func findMetricSamples(status *redskyv1beta1.TrialStatus, name string) *redskyv1beta1.MetricSamples {
+	for i := range status.MetricSamples {
+		if status.MetricSamples[i].Name == name {
+			return &status.MetricSamples[i]
+		}
+	}
+	status.MetricSamples = append(status.MetricSamples, redskyv1beta1.MetricSamples{Name: name})
+	return &status.MetricSamples[len(status.MetricSamples)-1]
+}
+
+func (r *MetricReconciler) target(ctx context.Context, t *redskyv1beta1.Trial, m *redskyv1beta1.Metric) (runtime.Object, error) {
+	namespace := t.Namespace
 	switch m.Type {
 	case redskyv1beta1.MetricPods:
 		// Use the selector to get a list of pods
@@ -208,19 +426,75 @@ func (r *MetricReconciler) target(ctx context.Context, namespace string, m *reds
 			return nil, err
 		}
 		return target, nil
+	case redskyv1beta1.MetricJob:
+		// Use the trial labels to get a list of the trial run job pods
+		target := &corev1.PodList{}
+		podLabels := map[string]string{redskyv1beta1.LabelTrial: t.Name, redskyv1beta1.LabelTrialRole: "trialRun"}
+		if err := r.List(ctx, target, client.InNamespace(namespace), client.MatchingLabels(podLabels)); err != nil {
+			return nil, err
+		}
+		return target, nil
 	case redskyv1beta1.MetricPrometheus, redskyv1beta1.MetricJSONPath:
-		// Both Prometheus and JSONPath target a service
-		target := &corev1.ServiceList{}
-
+		// Both Prometheus and JSONPath target a service (or individual pods)
 		if m.URL != "" {
+			return &corev1.ServiceList{}, nil
+		}
+
+		if m.PodSelector != nil {
+			target := &corev1.PodList{}
+			if sel, err := meta.MatchingSelector(m.PodSelector); err != nil {
+				return nil, err
+			} else if err := r.List(ctx, target, client.InNamespace(namespace), sel); err != nil {
+				return nil, err
+			}
 			return target, nil
 		}
 
+		target := &corev1.ServiceList{}
 		if sel, err := meta.MatchingSelector(m.Selector); err != nil {
 			return nil, err
 		} else if err := r.List(ctx, target, client.InNamespace(namespace), sel); err != nil {
 			return nil, err
 		}
+
+		// Headless services do not have a cluster IP, use the endpoints to address the individual pods instead; values
+		// from services are used as alternatives while values from pods are aggregated so we cannot mix them
+		var headless int
+		for i := range target.Items {
+			if target.Items[i].Spec.ClusterIP == corev1.ClusterIPNone {
+				headless++
+			}
+		}
+		if headless == 0 {
+			return target, nil
+		} else if headless < len(target.Items) {
+			return nil, fmt.Errorf("metric '%s' selector matches both headless and cluster IP services", m.Name)
+		}
+
+		// NOTE: We use the Endpoints API instead of EndpointSlices (discovery.k8s.io), the slices are still beta and
+		// not available on every cluster we support; the services used for metrics are far below the size where the
+		// Endpoints API is truncated
+		endpoints := &corev1.EndpointsList{}
+		for i := range target.Items {
+			ep := &corev1.Endpoints{}
+			if err := r.apiReader.Get(ctx, types.NamespacedName{Namespace: target.Items[i].Namespace, Name: target.Items[i].Name}, ep); err != nil {
+				if controller.IgnoreNotFound(err) != nil {
+					return nil, err
+				}
+				continue
+			}
+			endpoints.Items = append(endpoints.Items, *ep)
+		}
+		if len(endpoints.Items) > 0 {
+			return endpoints, nil
+		}
+		return target, nil
+	case redskyv1beta1.MetricSynthetic:
+		// The experiment parameters are needed to normalize the assignments
+		target := &redskyv1beta1.Experiment{}
+		if err := r.Get(ctx, t.ExperimentNamespacedName(), target); err != nil {
+			return nil, err
+		}
 		return target, nil
 	default:
 		// Assume no target is necessary
@@ -21,6 +21,8 @@ import (
 
 	"github.com/go-logr/logr"
 	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/redskyops/redskyops-controller/internal/capacity"
+	"github.com/redskyops/redskyops-controller/internal/clone"
 	"github.com/redskyops/redskyops-controller/internal/controller"
 	"github.com/redskyops/redskyops-controller/internal/patch"
 	"github.com/redskyops/redskyops-controller/internal/ready"
@@ -28,8 +30,10 @@ import (
 	"github.com/redskyops/redskyops-controller/internal/trial"
 	"github.com/redskyops/redskyops-controller/internal/validation"
 	corev1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -38,12 +42,14 @@ import (
 // PatchReconciler reconciles the patches on a Trial object
 type PatchReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log       logr.Logger
+	Scheme    *runtime.Scheme
+	apiReader client.Reader
 }
 
 // +kubebuilder:rbac:groups=redskyops.dev,resources=experiments,verbs=get;list;watch
 // +kubebuilder:rbac:groups=redskyops.dev,resources=trials,verbs=get;list;watch;update
+// +kubebuilder:rbac:groups="",resources=nodes;pods,verbs=list
 
 // Reconcile inspects a trial to see if patches need to be applied. The "trial patched" status condition
 // is used to control what actions need to be taken. If the status is "unknown" then the experiment is fetched
@@ -63,6 +69,10 @@ func (r *PatchReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return *result, err
 	}
 
+	if result, err := r.checkCapacity(ctx, t, &now); result != nil {
+		return *result, err
+	}
+
 	if result, err := r.applyPatches(ctx, t, &now); result != nil {
 		return *result, err
 	}
@@ -72,6 +82,7 @@ func (r *PatchReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 
 // SetupWithManager registers a new patch reconciler with the supplied manager
 func (r *PatchReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.apiReader = mgr.GetAPIReader()
 	return ctrl.NewControllerManagedBy(mgr).
 		Named("patch").
 		For(&redskyv1beta1.Trial{}).
@@ -161,12 +172,184 @@ func (r *PatchReconciler) evaluatePatchOperations(ctx context.Context, t *redsky
 	// Add back any pre-existing readiness checks
 	t.Status.ReadinessChecks = append(t.Status.ReadinessChecks, readinessChecks...)
 
+	// Copy the application into the trial namespace before it is patched
+	if result, err := r.cloneApplication(ctx, exp, t, probeTime); result != nil {
+		return result, err
+	}
+
 	// Update the status to indicate that patches are evaluated
 	trial.ApplyCondition(&t.Status, redskyv1beta1.TrialPatched, corev1.ConditionFalse, "", "", probeTime)
 	err := r.Update(ctx, t)
 	return controller.RequeueConflict(err)
 }
 
+// cloneApplication creates copies of the patch targets and supporting objects from the source namespace in the trial
+// namespace; objects which already exist in the trial namespace (e.g. from a previous trial) are updated to match the
+// source namespace so every trial starts from the current source
+func (r *PatchReconciler) cloneApplication(ctx context.Context, exp *redskyv1beta1.Experiment, t *redskyv1beta1.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
+	source := clone.SourceNamespace(exp)
+	if exp.Spec.Clone == nil || source == t.Namespace {
+		return nil, nil
+	}
+
+	// Supporting objects come first so they exist before the patch targets that depend on them
+	// RBAC: We assume that we have "get", "list", "create" and "patch" permission from a customer defined role (e.g. the
+	// one generated using `redskyctl grant-permissions --clone-application`)
+	var objs []unstructured.Unstructured
+	if exp.Spec.Clone.Selector != nil {
+		s, err := metav1.LabelSelectorAsSelector(exp.Spec.Clone.Selector)
+		if err != nil {
+			return &ctrl.Result{}, err
+		}
+		for _, gvk := range clone.Kinds(exp.Spec.Clone) {
+			ul := &unstructured.UnstructuredList{}
+			ul.SetGroupVersionKind(gvk)
+			if err := r.apiReader.List(ctx, ul, client.InNamespace(source), client.MatchingLabelsSelector{Selector: s}); err != nil {
+				return &ctrl.Result{}, err
+			}
+			objs = append(objs, ul.Items...)
+		}
+	}
+
+	// Patch targets which do not exist in the source namespace may be created by the setup tasks instead
+	for _, ref := range clone.Targets(t) {
+		u := unstructured.Unstructured{}
+		u.SetGroupVersionKind(ref.GroupVersionKind())
+		if err := r.apiReader.Get(ctx, client.ObjectKey{Namespace: source, Name: ref.Name}, &u); controller.IgnoreNotFound(err) != nil {
+			return &ctrl.Result{}, err
+		} else if err == nil {
+			objs = append(objs, u)
+		}
+	}
+
+	for i := range objs {
+		u := clone.NewObject(&objs[i], t.Namespace)
+		if u == nil {
+			continue
+		}
+		err := r.Create(ctx, u)
+		if apierrs.IsAlreadyExists(err) {
+			// A merge patch of the copy leaves the fields allocated by the cluster (e.g. the cluster IP) in place
+			err = r.Patch(ctx, u, client.Merge)
+		}
+		if err != nil {
+			trial.ApplyCondition(&t.Status, redskyv1beta1.TrialFailed, corev1.ConditionTrue, trial.ReasonCloneFailed, err.Error(), probeTime)
+			err := r.Update(ctx, t)
+			return controller.RequeueConflict(err)
+		}
+	}
+
+	return nil, nil
+}
+
+// checkCapacity estimates the scheduling footprint of the patched objects before any patches are applied; trials
+// wait while there is insufficient free capacity and fail if the patched resource requests can never fit on the nodes
+func (r *PatchReconciler) checkCapacity(ctx context.Context, t *redskyv1beta1.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
+	// Only check capacity if the "patched" status is "false" and nothing has been patched yet
+	if t.Spec.SkipCapacityCheck || !trial.CheckCondition(&t.Status, redskyv1beta1.TrialPatched, corev1.ConditionFalse) {
+		return nil, nil
+	}
+	if patchedReason(t) == trial.ReasonCapacityUnknown {
+		return nil, nil
+	}
+	for i := range t.Status.PatchOperations {
+		if t.Status.PatchOperations[i].AttemptsRemaining == 0 {
+			return nil, nil
+		}
+	}
+
+	// Compute the footprint of each patch target before and after all of its patches are applied
+	var demand, released []capacity.Footprint
+	for _, target := range capacity.Targets(t.Status.PatchOperations) {
+		if trial.IsTrialJobReference(t, &target.Ref) {
+			continue
+		}
+
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(target.Ref.GroupVersionKind())
+		if err := r.apiReader.Get(ctx, client.ObjectKey{Namespace: target.Ref.Namespace, Name: target.Ref.Name}, u); err != nil {
+			// Missing targets are reported when the patch is applied
+			if controller.IgnoreNotFound(err) == nil {
+				continue
+			}
+			return &ctrl.Result{}, err
+		}
+
+		// Patches which cannot be evaluated locally are reported when the patch is applied
+		f, err := capacity.PatchedFootprint(u, target.Patches...)
+		if err != nil || f == nil {
+			continue
+		}
+		demand = append(demand, *f)
+		if f, err := capacity.NewFootprint(u); err == nil {
+			released = append(released, *f)
+		}
+	}
+	if len(demand) == 0 {
+		return nil, nil
+	}
+
+	// Compare the footprint to the current state of the cluster
+	// NOTE: We do not use the cache, it requires a cluster wide watch (which is not granted when the controller is
+	// bound to individual namespaces) and blocks until it syncs; instead only active pods are listed and waiting
+	// trials check less frequently over time (see `trial.CapacityCheckInterval`)
+	nodes := &corev1.NodeList{}
+	pods := &corev1.PodList{}
+	activePods := client.MatchingFieldsSelector{Selector: fields.AndSelectors(
+		fields.OneTermNotEqualSelector("status.phase", string(corev1.PodSucceeded)),
+		fields.OneTermNotEqualSelector("status.phase", string(corev1.PodFailed)),
+	)}
+	err := r.apiReader.List(ctx, nodes)
+	if err == nil {
+		err = r.apiReader.List(ctx, pods, activePods)
+	}
+	if apierrs.IsForbidden(err) {
+		// Without permission to inspect the cluster we cannot make an estimate, record that the check is disabled
+		r.Log.Info("Unable to check cluster capacity", "trial", t.Namespace+"/"+t.Name, "error", err.Error())
+		trial.ApplyCondition(&t.Status, redskyv1beta1.TrialPatched, corev1.ConditionFalse, trial.ReasonCapacityUnknown, "capacity check is disabled: "+err.Error(), probeTime)
+		err := r.Update(ctx, t)
+		return controller.RequeueConflict(err)
+	} else if err != nil {
+		return &ctrl.Result{}, err
+	}
+
+	message, infeasible := capacity.NewCapacity(nodes.Items, pods.Items).Check(demand, released)
+	if message == "" {
+		return nil, nil
+	}
+
+	// Fail the trial if it can never fit, there is no point in waiting
+	if infeasible {
+		trial.ApplyCondition(&t.Status, redskyv1beta1.TrialFailed, corev1.ConditionTrue, trial.ReasonInfeasible, message, probeTime)
+		err := r.Update(ctx, t)
+		return controller.RequeueConflict(err)
+	}
+
+	// Do not wait forever, the capacity may never become available
+	deadline := trial.CapacityDeadline(t)
+	if deadline != nil && !probeTime.Before(deadline) {
+		trial.ApplyCondition(&t.Status, redskyv1beta1.TrialFailed, corev1.ConditionTrue, trial.ReasonCapacityTimeout, message, probeTime)
+		err := r.Update(ctx, t)
+		return controller.RequeueConflict(err)
+	}
+
+	// Record the reason we are waiting the first time we see it
+	if !waitingForCapacity(t) {
+		trial.ApplyCondition(&t.Status, redskyv1beta1.TrialPatched, corev1.ConditionFalse, trial.ReasonInsufficientCapacity, message, probeTime)
+		if err := r.Update(ctx, t); err != nil {
+			return controller.RequeueConflict(err)
+		}
+	}
+
+	result := &ctrl.Result{RequeueAfter: trial.CapacityCheckInterval(t, probeTime.Time)}
+	if deadline != nil {
+		if d := deadline.Sub(probeTime.Time); d < result.RequeueAfter {
+			result.RequeueAfter = d
+		}
+	}
+	return result, nil
+}
+
 // applyPatches will actually patch the objects from the patch operations
 func (r *PatchReconciler) applyPatches(ctx context.Context, t *redskyv1beta1.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
 	// Only apply patches if the "patched" status is "false"
@@ -191,7 +374,7 @@ func (r *PatchReconciler) applyPatches(ctx context.Context, t *redskyv1beta1.Tri
 			p.AttemptsRemaining = p.AttemptsRemaining - 1
 			if p.AttemptsRemaining == 0 {
 				// There are no remaining patch attempts remaining, fail the trial
-				trial.ApplyCondition(&t.Status, redskyv1beta1.TrialFailed, corev1.ConditionTrue, "PatchFailed", err.Error(), probeTime)
+				trial.ApplyCondition(&t.Status, redskyv1beta1.TrialFailed, corev1.ConditionTrue, trial.ReasonPatchFailed, err.Error(), probeTime)
 			}
 		} else {
 			p.AttemptsRemaining = 0
@@ -242,3 +425,18 @@ func (r *PatchReconciler) createReadinessCheck(t *redskyv1beta1.Trial, p *redsky
 	}
 	return rc, nil
 }
+
+// waitingForCapacity checks to see if the trial is already waiting for capacity to apply its patches
+func waitingForCapacity(t *redskyv1beta1.Trial) bool {
+	return patchedReason(t) == trial.ReasonInsufficientCapacity
+}
+
+// patchedReason returns the reason of the "patched" condition
+func patchedReason(t *redskyv1beta1.Trial) string {
+	for _, c := range t.Status.Conditions {
+		if c.Type == redskyv1beta1.TrialPatched {
+			return c.Reason
+		}
+	}
+	return ""
+}
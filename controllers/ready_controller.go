@@ -18,6 +18,7 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -160,6 +161,17 @@ func (r *ReadyReconciler) checkReadiness(ctx context.Context, t *redskyv1beta1.T
 		return nil, nil
 	}
 
+	// Fail the trial if it takes too long to become ready
+	if deadline := trial.ReadinessDeadline(t); deadline != nil && !probeTime.Before(deadline) {
+		message := fmt.Sprintf("trial did not become ready within the timeout of %s", t.Spec.ReadinessTimeout.Duration)
+		if c := readyCondition(t); c != nil && c.Message != "" {
+			message += ": " + c.Message
+		}
+		trial.ApplyCondition(&t.Status, redskyv1beta1.TrialFailed, corev1.ConditionTrue, trial.ReasonReadinessTimeout, message, probeTime)
+		err := r.Update(ctx, t)
+		return controller.RequeueConflict(err)
+	}
+
 	// Create a new "checker" to maintain state while looping over the readiness checks
 	checker := newReadinessChecker(r.Client, t)
 	for i := range t.Status.ReadinessChecks {
@@ -189,6 +201,9 @@ func (r *ReadyReconciler) checkReadiness(ctx context.Context, t *redskyv1beta1.T
 
 	// We may need to requeue and try again (e.g. all of the checks are in the initial delay)
 	if checker.requeue && checker.after > 0 {
+		if deadline := trial.ReadinessDeadline(t); deadline != nil && deadline.Sub(probeTime.Time) < checker.after {
+			return &ctrl.Result{RequeueAfter: deadline.Sub(probeTime.Time)}, nil
+		}
 		return &ctrl.Result{RequeueAfter: checker.after}, nil
 	}
 
@@ -357,3 +372,13 @@ func (rc *readinessChecker) nextCheckTime(c *redskyv1beta1.ReadinessCheck) *meta
 
 	return &metav1.Time{Time: rc.epoch.Add(time.Duration(c.InitialDelaySeconds) * time.Second)}
 }
+
+// readyCondition returns the ready condition of the trial
+func readyCondition(t *redskyv1beta1.Trial) *redskyv1beta1.TrialCondition {
+	for i := range t.Status.Conditions {
+		if t.Status.Conditions[i].Type == redskyv1beta1.TrialReady {
+			return &t.Status.Conditions[i]
+		}
+	}
+	return nil
+}
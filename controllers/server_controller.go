@@ -19,7 +19,10 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
@@ -83,7 +86,9 @@ func (r *ServerReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 
 	// Look for active, finished or abandoned trials
 	var activeTrials int32
-	var trialHasFinalizer bool
+	var trialHasFinalizer, retryPending bool
+	var groupNames []string
+	groups := make(map[string][]*redskyv1beta1.Trial)
 	for i := range trialList.Items {
 		t := &trialList.Items[i]
 		tlog := log.WithValues("trial", t.Namespace+"/"+t.Name)
@@ -93,10 +98,32 @@ func (r *ServerReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			activeTrials++
 		}
 
+		// Replica trials are reported together once the entire group is finished
+		if group := t.Labels[redskyv1beta1.LabelTrialGroup]; group != "" {
+			if _, ok := groups[group]; !ok {
+				groupNames = append(groupNames, group)
+			}
+			groups[group] = append(groups[group], t)
+			trialHasFinalizer = trialHasFinalizer || meta.HasFinalizer(t, server.Finalizer)
+			continue
+		}
+
 		// Trials that have the server finalizer may need to be reported
 		if meta.HasFinalizer(t, server.Finalizer) {
 			// TODO Combine report and abandon into one function
-			if trial.IsFinished(t) {
+			if trial.IsFinished(t) && exp.DeletionTimestamp.IsZero() && exp.Replicas() > 0 && trial.ShouldRetry(t) {
+				// Wait for the setup tasks of the failed trial to be removed so they do not interfere with the retry
+				if trial.CheckCondition(&t.Status, redskyv1beta1.TrialSetupDeleted, corev1.ConditionFalse) ||
+					trial.CheckCondition(&t.Status, redskyv1beta1.TrialSetupDeleted, corev1.ConditionUnknown) {
+					trialHasFinalizer, retryPending = true, true
+					continue
+				}
+
+				if result, err := r.retryTrial(ctx, tlog, t); result != nil {
+					return *result, err
+				}
+				activeTrials++
+			} else if trial.IsFinished(t) {
 				if result, err := r.reportTrial(ctx, tlog, t); result != nil {
 					return *result, err
 				}
@@ -110,6 +137,15 @@ func (r *ServerReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		}
 	}
 
+	// Report, abandon or replicate groups of replica trials
+	sort.Strings(groupNames)
+	for _, group := range groupNames {
+		glog := log.WithValues("trialGroup", group)
+		if result, err := r.reconcileTrialGroup(ctx, glog, exp, trialList, groups[group], activeTrials); result != nil {
+			return *result, err
+		}
+	}
+
 	// Create a new trial if necessary
 	if exp.GetAnnotations()[redskyv1beta1.AnnotationNextTrialURL] != "" && activeTrials < exp.Replicas() {
 		if result, err := r.nextTrial(ctx, log, exp, trialList); result != nil {
@@ -124,6 +160,16 @@ func (r *ServerReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		}
 	}
 
+	// Failed trials waiting to be retried are not watched, poll until they can be replaced
+	if retryPending {
+		return ctrl.Result{RequeueAfter: trial.CheckInterval}, nil
+	}
+
+	// Permissions are not watched, poll until namespace creation can be retried
+	if retryAfter := experiment.NamespaceRetryAfter(exp, time.Now()); retryAfter > 0 {
+		return ctrl.Result{RequeueAfter: retryAfter}, nil
+	}
+
 	// Nothing to do
 	return ctrl.Result{}, nil
 }
@@ -245,16 +291,17 @@ func (r *ServerReconciler) unlinkExperiment(ctx context.Context, log logr.Logger
 // a trial; if the cluster can not accommodate additional trials at the time of invocation, not action will be taken
 func (r *ServerReconciler) nextTrial(ctx context.Context, log logr.Logger, exp *redskyv1beta1.Experiment, trialList *redskyv1beta1.TrialList) (*ctrl.Result, error) {
 	// Enforce a rate limit on trial creation
-	if res := r.trialCreation.Reserve(); res.OK() {
-		if d := res.Delay(); d > 0 {
-			res.Cancel()
-			return &ctrl.Result{RequeueAfter: d}, nil
-		}
+	if result := r.limitTrialCreation(); result != nil {
+		return result, nil
 	}
 
 	// Determine the namespace (if any) to use for the trial
 	namespace, err := experiment.NextTrialNamespace(ctx, r, exp, trialList)
-	if err != nil {
+	if experiment.IsNamespaceFailure(err) {
+		log.Info("Unable to create trial namespace", "error", err.Error())
+		err := r.Update(ctx, exp)
+		return controller.RequeueConflict(err)
+	} else if err != nil {
 		return &ctrl.Result{}, err
 	}
 	if namespace == "" {
@@ -276,6 +323,9 @@ func (r *ServerReconciler) nextTrial(ctx context.Context, log logr.Logger, exp *
 	experiment.PopulateTrialFromTemplate(exp, t)
 	t.Namespace = namespace
 	server.ToClusterTrial(t, &suggestion)
+	if exp.ReplicationCount() > 1 {
+		server.StartTrialGroup(t)
+	}
 
 	// Create the trial
 	if err := r.Create(ctx, t); err != nil {
@@ -323,6 +373,31 @@ func (r *ServerReconciler) reportTrial(ctx context.Context, log logr.Logger, t *
 	return nil, nil
 }
 
+// retryTrial will replace a failed trial with a new trial for the same assignments instead of reporting the failure
+func (r *ServerReconciler) retryTrial(ctx context.Context, log logr.Logger, t *redskyv1beta1.Trial) (*ctrl.Result, error) {
+	// Enforce a rate limit on trial creation
+	if result := r.limitTrialCreation(); result != nil {
+		return result, nil
+	}
+
+	// Create the replacement trial, it may already exist if we failed to update the failed trial
+	retry := trial.NewRetry(t)
+	meta.AddFinalizer(retry, server.Finalizer)
+	if err := r.Create(ctx, retry); controller.IgnoreAlreadyExists(err) != nil {
+		return &ctrl.Result{}, err
+	}
+
+	// The failed trial is no longer reported
+	trial.RecordRetry(t, retry)
+	meta.RemoveFinalizer(t, server.Finalizer)
+	if err := r.Update(ctx, t); err != nil {
+		return controller.RequeueConflict(err)
+	}
+
+	log.Info("Retrying failed trial", "retry", retry.Name, "failureClass", trial.FailureClass(t), "retries", trial.Retries(retry))
+	return nil, nil
+}
+
 // abandonTrial will remove the finalizer and try to notify the server that the trial will not be reported
 func (r *ServerReconciler) abandonTrial(ctx context.Context, log logr.Logger, t *redskyv1beta1.Trial) (*ctrl.Result, error) {
 	if !meta.RemoveFinalizer(t, server.Finalizer) {
@@ -347,3 +422,157 @@ func (r *ServerReconciler) abandonTrial(ctx context.Context, log logr.Logger, t
 	log.Info("Abandoned trial")
 	return nil, nil
 }
+
+// limitTrialCreation enforces the rate limit on trial creation, returning a non-nil result if creation must be delayed
+func (r *ServerReconciler) limitTrialCreation() *ctrl.Result {
+	if res := r.trialCreation.Reserve(); res.OK() {
+		if d := res.Delay(); d > 0 {
+			res.Cancel()
+			return &ctrl.Result{RequeueAfter: d}
+		}
+	}
+	return nil
+}
+
+// reconcileTrialGroup will report or abandon a group of replica trials once all of the replicas are finished, or
+// create the next replica trial if the group is not complete
+func (r *ServerReconciler) reconcileTrialGroup(ctx context.Context, log logr.Logger, exp *redskyv1beta1.Experiment, trialList *redskyv1beta1.TrialList, trials []*redskyv1beta1.Trial, activeTrials int32) (*ctrl.Result, error) {
+	// Order the replicas by index so the first trial of the group is always first
+	sort.Slice(trials, func(i, j int) bool { return replicaIndex(trials[i]) < replicaIndex(trials[j]) })
+
+	count := int(exp.ReplicationCount())
+	var pending, finished, active int
+	var failed, abandoned bool
+	for _, t := range trials {
+		if meta.HasFinalizer(t, server.Finalizer) {
+			pending++
+		}
+		if trial.IsFinished(t) {
+			finished++
+			failed = failed || trial.CheckCondition(&t.Status, redskyv1beta1.TrialFailed, corev1.ConditionTrue)
+		} else {
+			active++
+		}
+
+		// A deleted replica means the group can never be completed
+		if trial.IsAbandoned(t) || (!t.DeletionTimestamp.IsZero() && len(trials) < count) {
+			abandoned = true
+		}
+	}
+
+	switch {
+	case pending == 0:
+		// The group was already reported or abandoned
+		return nil, nil
+	case abandoned:
+		return r.abandonTrialGroup(ctx, log, trials)
+	case finished >= count || (failed && active == 0):
+		// A failed replica fails the entire group, there is no need to wait for the remaining replicas
+		return r.reportTrialGroup(ctx, log, trials)
+	case len(trials) < count && activeTrials < exp.Replicas():
+		// Sequential replicas do not start until the previous replicas finish
+		if exp.Spec.Replication != nil && !exp.Spec.Replication.Parallel && active > 0 {
+			return nil, nil
+		}
+		return r.nextReplicaTrial(ctx, log, exp, trialList, trials[0], len(trials))
+	default:
+		return nil, nil
+	}
+}
+
+// nextReplicaTrial creates a new replica trial using the assignments of the first trial in a group
+func (r *ServerReconciler) nextReplicaTrial(ctx context.Context, log logr.Logger, exp *redskyv1beta1.Experiment, trialList *redskyv1beta1.TrialList, first *redskyv1beta1.Trial, replica int) (*ctrl.Result, error) {
+	// Enforce a rate limit on trial creation
+	if result := r.limitTrialCreation(); result != nil {
+		return result, nil
+	}
+
+	// Determine the namespace (if any) to use for the trial
+	namespace, err := experiment.NextTrialNamespace(ctx, r, exp, trialList)
+	if experiment.IsNamespaceFailure(err) {
+		log.Info("Unable to create trial namespace", "error", err.Error())
+		err := r.Update(ctx, exp)
+		return controller.RequeueConflict(err)
+	} else if err != nil {
+		return &ctrl.Result{}, err
+	}
+	if namespace == "" {
+		return nil, nil
+	}
+
+	// Generate a new trial from the template on the experiment and copy the suggestion from the first trial
+	t := &redskyv1beta1.Trial{}
+	experiment.PopulateTrialFromTemplate(exp, t)
+	t.Namespace = namespace
+	server.ToClusterReplicaTrial(t, first, replica)
+
+	// Create the trial
+	if err := r.Create(ctx, t); err != nil {
+		return &ctrl.Result{}, err
+	}
+
+	// Stop processing, the trial list is no longer accurate
+	log.Info("Created replica trial", "trial", t.Namespace+"/"+t.Name, "replica", replica)
+	return &ctrl.Result{}, nil
+}
+
+// reportTrialGroup will report the aggregated values of a group of finished replica trials back to the server
+func (r *ServerReconciler) reportTrialGroup(ctx context.Context, log logr.Logger, trials []*redskyv1beta1.Trial) (*ctrl.Result, error) {
+	if reportTrialURL := trials[0].GetAnnotations()[redskyv1beta1.AnnotationReportTrialURL]; reportTrialURL != "" {
+		trialValues := server.FromClusterTrialGroup(trials)
+		err := r.ExperimentsAPI.ReportTrial(ctx, reportTrialURL, *trialValues)
+		if controller.IgnoreReportError(err) != nil {
+			return &ctrl.Result{}, err
+		}
+
+		// Shadow the logger reference with one that will produce more contextual details
+		log = log.WithValues("reportTrialURL", reportTrialURL, "values", trialValues)
+	}
+
+	// Remove the finalizer from every replica, reporting again is harmless if one of the updates fails
+	if result, err := r.removeTrialFinalizers(ctx, trials); result != nil {
+		return result, err
+	}
+
+	log.Info("Reported trial group", "replicas", len(trials))
+	return nil, nil
+}
+
+// abandonTrialGroup will notify the server that a group of replica trials will not be reported
+func (r *ServerReconciler) abandonTrialGroup(ctx context.Context, log logr.Logger, trials []*redskyv1beta1.Trial) (*ctrl.Result, error) {
+	if reportTrialURL := trials[0].GetAnnotations()[redskyv1beta1.AnnotationReportTrialURL]; reportTrialURL != "" {
+		err := r.ExperimentsAPI.AbandonRunningTrial(ctx, reportTrialURL)
+		if controller.IgnoreNotFound(err) != nil {
+			return &ctrl.Result{}, err
+		}
+
+		// Shadow the logger reference with one that will produce more contextual details
+		log = log.WithValues("reportTrialURL", reportTrialURL)
+	}
+
+	if result, err := r.removeTrialFinalizers(ctx, trials); result != nil {
+		return result, err
+	}
+
+	log.Info("Abandoned trial group", "replicas", len(trials))
+	return nil, nil
+}
+
+// removeTrialFinalizers removes the server finalizer from each of the supplied trials
+func (r *ServerReconciler) removeTrialFinalizers(ctx context.Context, trials []*redskyv1beta1.Trial) (*ctrl.Result, error) {
+	for _, t := range trials {
+		if !meta.RemoveFinalizer(t, server.Finalizer) {
+			continue
+		}
+		if err := r.Update(ctx, t); err != nil {
+			return controller.RequeueConflict(err)
+		}
+	}
+	return nil, nil
+}
+
+// replicaIndex returns the index of a replica trial within its group
+func replicaIndex(t *redskyv1beta1.Trial) int {
+	i, _ := strconv.Atoi(t.Labels[redskyv1beta1.LabelTrialReplica])
+	return i
+}
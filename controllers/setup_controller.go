@@ -18,6 +18,7 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/go-logr/logr"
@@ -45,7 +46,8 @@ type SetupReconciler struct {
 
 // +kubebuilder:rbac:groups=redskyops.dev,resources=trials;trials/finalizers,verbs=get;list;watch;update
 // +kubebuilder:rbac:groups="",resources=pods,verbs=list
-// +kubebuilder:rbac:groups=batch;extensions,resources=jobs,verbs=list;watch;create
+// +kubebuilder:rbac:groups=batch;extensions,resources=jobs,verbs=list;watch;create;delete
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
 
 func (r *SetupReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
@@ -66,6 +68,11 @@ func (r *SetupReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return *result, err
 	}
 
+	// Reuse or retain the setup of pooled namespaces
+	if result, err := r.poolSetup(ctx, t, &now); result != nil {
+		return *result, err
+	}
+
 	// If necessary, create the setup (create or delete) job
 	if result, err := r.createSetupJob(ctx, t, &now); result != nil {
 		return *result, err
@@ -99,8 +106,10 @@ func (r *SetupReconciler) inspectSetupJobs(ctx context.Context, t *redskyv1beta1
 
 	// This is purely for recovery
 	if len(list.Items) == 0 {
-		if t.DeletionTimestamp.IsZero() {
+		if t.DeletionTimestamp.IsZero() && !trial.IsFinished(t) && !setup.IsReused(t) {
 			// Normally if the trial hasn't been deleted and there are no jobs, the status will already be unknown
+			// (a finished trial may have had its create job deleted because it did not complete in time and a trial
+			// reusing the setup of a pooled namespace never had a create job)
 			trial.ApplyCondition(&t.Status, redskyv1beta1.TrialSetupCreated, corev1.ConditionUnknown, "", "", probeTime)
 			trial.ApplyCondition(&t.Status, redskyv1beta1.TrialSetupDeleted, corev1.ConditionUnknown, "", "", probeTime)
 		} else if trial.CheckCondition(&t.Status, redskyv1beta1.TrialSetupDeleted, corev1.ConditionFalse) {
@@ -113,6 +122,7 @@ func (r *SetupReconciler) inspectSetupJobs(ctx context.Context, t *redskyv1beta1
 	}
 
 	// Update the conditions based on existing jobs
+	var timedOut []*batchv1.Job
 	for i := range list.Items {
 		job := &list.Items[i]
 
@@ -123,17 +133,38 @@ func (r *SetupReconciler) inspectSetupJobs(ctx context.Context, t *redskyv1beta1
 		}
 
 		// Determine if the job is finished (i.e. completed or failed)
+		reason := trial.ReasonSetupJobFailed
 		conditionStatus, failureMessage := setup.GetConditionStatus(job)
 		if conditionStatus == corev1.ConditionFalse {
 			conditionStatus, failureMessage = r.inspectSetupJobPods(ctx, job)
 		}
+
+		// A job which is still running past the deadline is treated as a failure
+		if deadline := trial.SetupDeadline(t, job.CreationTimestamp); conditionStatus == corev1.ConditionFalse && deadline != nil && !probeTime.Before(deadline) {
+			reason = trial.ReasonSetupTimeout
+			conditionStatus, failureMessage = corev1.ConditionTrue, fmt.Sprintf("Setup job did not complete within the timeout of %s", t.Spec.SetupTimeout.Duration)
+			timedOut = append(timedOut, job)
+		}
 		trial.ApplyCondition(&t.Status, conditionType, conditionStatus, "", "", probeTime)
 
 		// Only fail the trial itself if it isn't already finished; both to prevent overwriting an existing success
 		// or failure status and to avoid updating the probe time (which would get us stuck in a busy loop)
 		if failureMessage != "" && !trial.IsFinished(t) {
-			trial.ApplyCondition(&t.Status, redskyv1beta1.TrialFailed, corev1.ConditionTrue, "SetupJobFailed", failureMessage, probeTime)
+			trial.ApplyCondition(&t.Status, redskyv1beta1.TrialFailed, corev1.ConditionTrue, reason, failureMessage, probeTime)
+		}
+	}
+
+	// Record the failure before deleting the jobs which timed out so they are not re-created
+	if len(timedOut) > 0 {
+		if err := r.Update(ctx, t); err != nil {
+			return controller.RequeueConflict(err)
+		}
+		for _, job := range timedOut {
+			if err := r.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)); controller.IgnoreNotFound(err) != nil {
+				return &ctrl.Result{}, err
+			}
 		}
+		return &ctrl.Result{}, nil
 	}
 
 	// Check to see if we need to update the trial to record a condition change
@@ -166,6 +197,123 @@ func (r *SetupReconciler) inspectSetupJobPods(ctx context.Context, j *batchv1.Jo
 	return corev1.ConditionFalse, ""
 }
 
+// poolSetup skips the setup jobs for trials running in a pooled namespace that do not need them: the create job is
+// skipped if the namespace already has the same setup applied and the delete job is always skipped so the next trial
+// can reuse the setup
+func (r *SetupReconciler) poolSetup(ctx context.Context, t *redskyv1beta1.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, client.ObjectKey{Name: t.Namespace}, ns); apierrs.IsNotFound(err) || apierrs.IsForbidden(err) {
+		return nil, nil
+	} else if err != nil {
+		return &ctrl.Result{}, err
+	}
+	if ns.Labels[redskyv1beta1.LabelNamespacePool] == "" {
+		return nil, nil
+	}
+
+	// Errors computing the hash are ignored here, they will be reported when creating the setup job
+	hash, hashErr := setup.Hash(t)
+
+	// A pooled namespace with the same setup does not need a create job
+	if trial.CheckCondition(&t.Status, redskyv1beta1.TrialSetupCreated, corev1.ConditionUnknown) && t.DeletionTimestamp.IsZero() {
+		applied := ns.Annotations[redskyv1beta1.AnnotationSetupHash]
+		if hashErr == nil && hash == applied {
+			trial.ApplyCondition(&t.Status, redskyv1beta1.TrialSetupCreated, corev1.ConditionTrue, setup.ReasonSetupReused, "", probeTime)
+			err := r.Update(ctx, t)
+			return controller.RequeueConflict(err)
+		}
+
+		// A different setup must be removed before the create job runs
+		if applied != "" && !trial.IsFinished(t) {
+			return r.recycleSetup(ctx, t, ns, probeTime)
+		}
+	}
+
+	// Keep track of the setup applied to the namespace (only the active trial is allowed to change it)
+	if !trial.IsFinished(t) && t.DeletionTimestamp.IsZero() {
+		applied := ns.Annotations[redskyv1beta1.AnnotationSetupHash]
+		switch {
+		case trial.CheckCondition(&t.Status, redskyv1beta1.TrialSetupCreated, corev1.ConditionFalse):
+			applied = ""
+		case trial.CheckCondition(&t.Status, redskyv1beta1.TrialSetupCreated, corev1.ConditionTrue) && hashErr == nil:
+			applied = hash
+		}
+		if applied != ns.Annotations[redskyv1beta1.AnnotationSetupHash] {
+			if ns.Annotations == nil {
+				ns.Annotations = make(map[string]string)
+			}
+			ns.Annotations[redskyv1beta1.AnnotationSetupHash] = applied
+			// RBAC: Namespace updates are granted along with namespace creation, which is required to create the pool
+			err := r.Update(ctx, ns)
+			return controller.RequeueConflict(err)
+		}
+	}
+
+	// Never delete the setup from a pooled namespace, the namespace itself is deleted with the pool
+	if trial.CheckCondition(&t.Status, redskyv1beta1.TrialSetupDeleted, corev1.ConditionUnknown) {
+		if trial.IsFinished(t) || !t.DeletionTimestamp.IsZero() {
+			trial.ApplyCondition(&t.Status, redskyv1beta1.TrialSetupDeleted, corev1.ConditionTrue, setup.ReasonSetupRetained, "", probeTime)
+			err := r.Update(ctx, t)
+			return controller.RequeueConflict(err)
+		}
+	}
+
+	return nil, nil
+}
+
+// recycleSetup runs a setup delete job to remove the setup previously applied to a pooled namespace; once the job
+// completes the setup hash of the namespace is cleared so the create job can run
+func (r *SetupReconciler) recycleSetup(ctx context.Context, t *redskyv1beta1.Trial, ns *corev1.Namespace, probeTime *metav1.Time) (*ctrl.Result, error) {
+	job := &batchv1.Job{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: t.Namespace, Name: setup.RecycleJobName(t)}, job); apierrs.IsNotFound(err) {
+		job, err := setup.NewRecycleJob(t)
+		if err != nil {
+			return &ctrl.Result{}, err
+		}
+
+		// There is nothing to delete if the job is not necessary
+		if job == nil {
+			delete(ns.Annotations, redskyv1beta1.AnnotationSetupHash)
+			err := r.Update(ctx, ns)
+			return controller.RequeueConflict(err)
+		}
+
+		if err := controllerutil.SetControllerReference(t, job, r.Scheme); err != nil {
+			return &ctrl.Result{}, err
+		}
+		err = r.Create(ctx, job)
+		return &ctrl.Result{}, controller.IgnoreAlreadyExists(err)
+	} else if err != nil {
+		return &ctrl.Result{}, err
+	}
+
+	conditionStatus, failureMessage := setup.GetConditionStatus(job)
+	reason := trial.ReasonSetupJobFailed
+	if deadline := trial.SetupDeadline(t, job.CreationTimestamp); conditionStatus == corev1.ConditionFalse && deadline != nil && !probeTime.Before(deadline) {
+		reason = trial.ReasonSetupTimeout
+		failureMessage = fmt.Sprintf("Setup job did not complete within the timeout of %s", t.Spec.SetupTimeout.Duration)
+	}
+
+	switch {
+	case failureMessage != "":
+		// The create job never runs so it does not get created over the remains of the previous setup
+		trial.ApplyCondition(&t.Status, redskyv1beta1.TrialSetupCreated, corev1.ConditionFalse, reason, failureMessage, probeTime)
+		trial.ApplyCondition(&t.Status, redskyv1beta1.TrialFailed, corev1.ConditionTrue, reason, failureMessage, probeTime)
+		err := r.Update(ctx, t)
+		return controller.RequeueConflict(err)
+	case conditionStatus == corev1.ConditionTrue:
+		delete(ns.Annotations, redskyv1beta1.AnnotationSetupHash)
+		err := r.Update(ctx, ns)
+		return controller.RequeueConflict(err)
+	}
+
+	// Wait for the job to finish, the trial is reconciled when the job changes
+	if deadline := trial.SetupDeadline(t, job.CreationTimestamp); deadline != nil {
+		return &ctrl.Result{RequeueAfter: deadline.Sub(probeTime.Time)}, nil
+	}
+	return &ctrl.Result{}, nil
+}
+
 // createSetupJob determines if a setup job is necessary and creates it
 func (r *SetupReconciler) createSetupJob(ctx context.Context, t *redskyv1beta1.Trial, probeTime *metav1.Time) (*ctrl.Result, error) {
 	mode := ""
@@ -253,5 +401,10 @@ func (r *SetupReconciler) finish(ctx context.Context, t *redskyv1beta1.Trial) (*
 		}
 	}
 
+	// We are watching jobs, not pods; poll the delete job so it can be stopped if it does not complete in time
+	if t.Spec.SetupTimeout != nil && trial.CheckCondition(&t.Status, redskyv1beta1.TrialSetupDeleted, corev1.ConditionFalse) {
+		return &ctrl.Result{RequeueAfter: trial.CheckInterval}, nil
+	}
+
 	return nil, nil
 }
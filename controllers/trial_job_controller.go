@@ -18,17 +18,22 @@ package controllers
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/go-logr/logr"
 	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
 	"github.com/redskyops/redskyops-controller/internal/controller"
 	"github.com/redskyops/redskyops-controller/internal/meta"
+	"github.com/redskyops/redskyops-controller/internal/metric"
+	"github.com/redskyops/redskyops-controller/internal/ready"
 	"github.com/redskyops/redskyops-controller/internal/trial"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
@@ -39,11 +44,16 @@ type TrialJobReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+
+	// Keep the raw API reader for fetching the patched targets, we may only have patch/get permissions on them
+	apiReader client.Reader
 }
 
+// +kubebuilder:rbac:groups=redskyops.dev,resources=experiments,verbs=get;list;watch
 // +kubebuilder:rbac:groups=redskyops.dev,resources=trials,verbs=get;list;watch;update
-// +kubebuilder:rbac:groups=batch;extensions,resources=jobs,verbs=get;list;watch;create
+// +kubebuilder:rbac:groups=batch;extensions,resources=jobs,verbs=get;list;watch;create;delete
 // +kubebuilder:rbac:groups="",resources=pods,verbs=list
+// +kubebuilder:rbac:groups="",resources=services,verbs=list
 
 func (r *TrialJobReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
@@ -54,20 +64,65 @@ func (r *TrialJobReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 		return ctrl.Result{}, controller.IgnoreNotFound(err)
 	}
 
-	// List the trial jobs (there should only ever be 0 or 1 matching jobs)
-	jobList := &batchv1.JobList{}
-	if err := r.listJobs(ctx, jobList, t.Namespace, t.GetJobSelector()); err != nil {
-		return ctrl.Result{}, err
+	// List the trial run resources (there should only ever be 0 or 1 matching jobs or resources)
+	var runs []runtime.Object
+	if t.Spec.RunTemplate != nil {
+		runList, err := r.listRuns(ctx, t)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+
+		// Update trial status based on existing run resource state
+		if result, err := r.updateRunStatus(ctx, t, runList, &now); result != nil {
+			return *result, err
+		}
+
+		for i := range runList.Items {
+			runs = append(runs, &runList.Items[i])
+		}
+	} else {
+		jobList := &batchv1.JobList{}
+		if err := r.listJobs(ctx, jobList, t.Namespace, t.GetJobSelector()); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		// Update trial status based on existing job state
+		if result, err := r.updateStatus(ctx, t, jobList, &now); result != nil {
+			return *result, err
+		}
+
+		for i := range jobList.Items {
+			runs = append(runs, &jobList.Items[i])
+		}
 	}
 
-	// Update trial status based on existing job state
-	if result, err := r.updateStatus(ctx, t, jobList, &now); result != nil {
+	// Stop the trial run if it does not complete in time
+	if deadline := trial.RunDeadline(t); deadline != nil && t.Status.CompletionTime == nil && !now.Before(deadline) {
+		message := fmt.Sprintf("trial run did not complete within the timeout of %s", t.Spec.RunTimeout.Duration)
+		r.Log.Info("Trial run timed out", "trial", t.Namespace+"/"+t.Name, "runTimeout", t.Spec.RunTimeout.Duration.String())
+		result, err := r.failTrial(ctx, t, runs, trial.ReasonRunTimeout, message, &now)
 		return *result, err
 	}
 
-	// Create a new job if necessary
-	if len(jobList.Items) == 0 {
-		// Insert a "sleep" between "ready" and the trial job
+	// While the trial run is executing, observe the patched targets and stop early if an abort condition is met
+	if len(runs) > 0 && t.Status.StartTime != nil && t.Status.CompletionTime == nil && !now.Before(t.Status.StartTime) {
+		if result, err := r.observeTargets(ctx, t, runs, &now); result != nil {
+			return *result, err
+		}
+
+		if result, err := r.checkAbortConditions(ctx, t, runs, &now); result != nil {
+			return *result, err
+		}
+	}
+
+	// We are watching jobs, not pods, metrics or arbitrary run resources; poll until the trial run completes
+	if len(runs) > 0 && t.Status.CompletionTime == nil && (t.Status.StartTime != nil || t.Spec.RunTemplate != nil || t.Spec.RunTimeout != nil) {
+		return ctrl.Result{RequeueAfter: trial.CheckInterval}, nil
+	}
+
+	// Create a new trial run if necessary
+	if len(runs) == 0 {
+		// Insert a "sleep" between "ready" and the trial run
 		if ids := time.Duration(t.Spec.InitialDelaySeconds) * time.Second; ids > 0 {
 			for _, c := range t.Status.Conditions {
 				if c.Type == redskyv1beta1.TrialReady {
@@ -79,8 +134,12 @@ func (r *TrialJobReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			}
 		}
 
-		// Create the trial run job
-		if result, err := r.createJob(ctx, t); result != nil {
+		// Create the trial run job or resource
+		if t.Spec.RunTemplate != nil {
+			if result, err := r.createRun(ctx, t); result != nil {
+				return *result, err
+			}
+		} else if result, err := r.createJob(ctx, t); result != nil {
 			return *result, err
 		}
 	}
@@ -89,6 +148,7 @@ func (r *TrialJobReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 }
 
 func (r *TrialJobReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	r.apiReader = mgr.GetAPIReader()
 	return ctrl.NewControllerManagedBy(mgr).
 		Named("trial-job").
 		For(&redskyv1beta1.Trial{}).
@@ -136,17 +196,263 @@ func (r *TrialJobReconciler) updateStatus(ctx context.Context, t *redskyv1beta1.
 	return nil, nil
 }
 
+// checkAbortConditions evaluates the experiment abort conditions while the trial run job is executing, the trial is
+// failed and the trial run job is deleted when a condition is met
+func (r *TrialJobReconciler) checkAbortConditions(ctx context.Context, t *redskyv1beta1.Trial, runs []runtime.Object, probeTime *metav1.Time) (*ctrl.Result, error) {
+	exp := &redskyv1beta1.Experiment{}
+	if err := r.Get(ctx, t.ExperimentNamespacedName(), exp); err != nil {
+		return &ctrl.Result{}, controller.IgnoreNotFound(err)
+	}
+	if len(exp.Spec.AbortConditions) == 0 {
+		return nil, nil
+	}
+
+	log := r.Log.WithValues("trial", t.Namespace+"/"+t.Name)
+	for i := range exp.Spec.AbortConditions {
+		c := &exp.Spec.AbortConditions[i]
+
+		// Failures to evaluate a condition are not fatal, the trial is only aborted for a positive match
+		message, abort, err := r.evaluateAbortCondition(ctx, t, c, probeTime)
+		if err != nil {
+			log.Error(err, "Abort condition check failed", "abortCondition", c.Name)
+			continue
+		}
+		if !abort {
+			continue
+		}
+
+		log.Info("Aborted trial", "abortCondition", c.Name, "message", message)
+		return r.failTrial(ctx, t, runs, trial.ReasonAborted, message, probeTime)
+	}
+
+	return nil, nil
+}
+
+// observeTargets records the container restarts and OOMKilled terminations in the pods of the patched targets,
+// optionally failing the trial
+func (r *TrialJobReconciler) observeTargets(ctx context.Context, t *redskyv1beta1.Trial, runs []runtime.Object, probeTime *metav1.Time) (*ctrl.Result, error) {
+	pods, err := r.targetPods(ctx, t)
+	if err != nil {
+		// Failures to observe the targets are not fatal
+		r.Log.Error(err, "Unable to observe patched targets", "trial", t.Namespace+"/"+t.Name)
+		return nil, nil
+	}
+
+	// Pods may disappear during the trial run, never decrease the recorded counts
+	var dirty bool
+	restarts, oomKills := trial.ContainerTerminations(t, pods)
+	if restarts > t.Status.TargetRestarts {
+		t.Status.TargetRestarts = restarts
+		dirty = true
+	}
+	if oomKills > t.Status.TargetOOMKills {
+		t.Status.TargetOOMKills = oomKills
+		dirty = true
+	}
+
+	switch {
+	case t.Spec.FailOnOOMKill && t.Status.TargetOOMKills > 0:
+		return r.failTrial(ctx, t, runs, trial.ReasonOOMKilled, fmt.Sprintf("%d containers of the patched targets were OOMKilled", t.Status.TargetOOMKills), probeTime)
+	case t.Spec.FailOnRestart && t.Status.TargetRestarts > 0:
+		return r.failTrial(ctx, t, runs, trial.ReasonContainerRestarted, fmt.Sprintf("%d containers of the patched targets restarted", t.Status.TargetRestarts), probeTime)
+	case dirty:
+		err := r.Update(ctx, t)
+		return controller.RequeueConflict(err)
+	}
+
+	return nil, nil
+}
+
+// targetPods returns the pods of the objects patched for the trial
+func (r *TrialJobReconciler) targetPods(ctx context.Context, t *redskyv1beta1.Trial) (*corev1.PodList, error) {
+	pods := &corev1.PodList{}
+	seen := make(map[corev1.ObjectReference]bool, len(t.Status.PatchOperations))
+	for i := range t.Status.PatchOperations {
+		ref := t.Status.PatchOperations[i].TargetRef
+		if ref.Namespace == "" {
+			ref.Namespace = t.Namespace
+		}
+		if ref.Kind == "" || seen[ref] {
+			continue
+		}
+		seen[ref] = true
+
+		u := &unstructured.Unstructured{}
+		u.SetGroupVersionKind(ref.GroupVersionKind())
+		if err := r.apiReader.Get(ctx, types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}, u); err != nil {
+			if controller.IgnoreNotFound(err) != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// Patched pods are observed directly
+		if ref.Kind == "Pod" && (ref.APIVersion == "" || ref.APIVersion == "v1") {
+			pod := corev1.Pod{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.UnstructuredContent(), &pod); err != nil {
+				return nil, err
+			}
+			pods.Items = append(pods.Items, pod)
+			continue
+		}
+
+		sel, err := ready.PodSelector(u)
+		if err != nil || sel == nil {
+			continue
+		}
+		list := &corev1.PodList{}
+		if err := r.List(ctx, list, client.InNamespace(ref.Namespace), client.MatchingLabelsSelector{Selector: sel}); err != nil {
+			return nil, err
+		}
+		pods.Items = append(pods.Items, list.Items...)
+	}
+	return pods, nil
+}
+
+// failTrial marks the trial as failed and deletes the trial run job or resource
+func (r *TrialJobReconciler) failTrial(ctx context.Context, t *redskyv1beta1.Trial, runs []runtime.Object, reason, message string, probeTime *metav1.Time) (*ctrl.Result, error) {
+	// Fail the trial first so the trial run job is not re-created once it is deleted
+	trial.ApplyCondition(&t.Status, redskyv1beta1.TrialFailed, corev1.ConditionTrue, reason, message, probeTime)
+	if err := r.Update(ctx, t); err != nil {
+		return controller.RequeueConflict(err)
+	}
+
+	for i := range runs {
+		if err := r.Delete(ctx, runs[i], client.PropagationPolicy(metav1.DeletePropagationBackground)); controller.IgnoreNotFound(err) != nil {
+			return &ctrl.Result{}, err
+		}
+	}
+
+	return &ctrl.Result{}, nil
+}
+
+// evaluateAbortCondition checks a single abort condition, returning a message if the trial should be aborted
+func (r *TrialJobReconciler) evaluateAbortCondition(ctx context.Context, t *redskyv1beta1.Trial, c *redskyv1beta1.AbortCondition, probeTime *metav1.Time) (string, bool, error) {
+	switch c.Type {
+	case redskyv1beta1.AbortPrometheus:
+		m := &redskyv1beta1.Metric{
+			Name:     c.Name,
+			Type:     redskyv1beta1.MetricPrometheus,
+			Query:    c.Query,
+			Selector: c.Selector,
+			Scheme:   c.Scheme,
+			Port:     c.Port,
+			Path:     c.Path,
+		}
+
+		target := &corev1.ServiceList{}
+		if sel, err := meta.MatchingSelector(m.Selector); err != nil {
+			return "", false, err
+		} else if err := r.List(ctx, target, client.InNamespace(t.Namespace), sel); err != nil {
+			return "", false, err
+		}
+
+		value, err := metric.EvaluatePrometheusQuery(ctx, r, m, t, target, probeTime.Time)
+		if err != nil {
+			return "", false, err
+		}
+		if threshold := trial.AbortThreshold(c); value > threshold {
+			return fmt.Sprintf("abort condition '%s': query value %g exceeds the threshold of %g", c.Name, value, threshold), true, nil
+		}
+		return "", false, nil
+
+	case redskyv1beta1.AbortRestarts, redskyv1beta1.AbortOOMKilled:
+		pods := &corev1.PodList{}
+		if sel, err := meta.MatchingSelector(trial.AbortPodSelector(c)); err != nil {
+			return "", false, err
+		} else if err := r.List(ctx, pods, client.InNamespace(t.Namespace), sel); err != nil {
+			return "", false, err
+		}
+
+		message, abort := trial.CheckPodAbortCondition(c, t, pods)
+		return message, abort, nil
+
+	default:
+		return "", false, fmt.Errorf("unknown abort condition type: %s", c.Type)
+	}
+}
+
 // createJob will create a new trial run job
 func (r *TrialJobReconciler) createJob(ctx context.Context, t *redskyv1beta1.Trial) (*ctrl.Result, error) {
-	job := trial.NewJob(t)
+	job, err := trial.NewJob(t)
+	if err != nil {
+		return &ctrl.Result{}, err
+	}
 	if err := controllerutil.SetControllerReference(t, job, r.Scheme); err != nil {
 		return &ctrl.Result{}, err
 	}
 
-	err := r.Create(ctx, job)
+	err = r.Create(ctx, job)
+	return &ctrl.Result{}, err
+}
+
+// createRun will create a new trial run resource
+func (r *TrialJobReconciler) createRun(ctx context.Context, t *redskyv1beta1.Trial) (*ctrl.Result, error) {
+	run, err := trial.NewRun(t)
+	if err != nil {
+		return &ctrl.Result{}, err
+	}
+	if err := controllerutil.SetControllerReference(t, run, r.Scheme); err != nil {
+		return &ctrl.Result{}, err
+	}
+
+	err = r.Create(ctx, run)
 	return &ctrl.Result{}, err
 }
 
+// listRuns will return all of the run resources for the trial
+func (r *TrialJobReconciler) listRuns(ctx context.Context, t *redskyv1beta1.Trial) (*unstructured.UnstructuredList, error) {
+	run, err := trial.NewRun(t)
+	if err != nil {
+		return nil, err
+	}
+
+	// Arbitrary resource types are not cached, use the API reader
+	// NOTE: The "List" suffix does not need to match the list kind of the resource, the unstructured client strips it
+	// to find the resource using the REST mapper
+	runList := &unstructured.UnstructuredList{}
+	runList.SetGroupVersionKind(run.GroupVersionKind().GroupVersion().WithKind(run.GetKind() + "List"))
+	if err := r.apiReader.List(ctx, runList, client.InNamespace(t.Namespace), client.MatchingLabelsSelector{Selector: trial.RunSelector(t)}); err != nil {
+		return nil, err
+	}
+	return runList, nil
+}
+
+// updateRunStatus will update the trial status based on the supplied list of trial run resources
+func (r *TrialJobReconciler) updateRunStatus(ctx context.Context, t *redskyv1beta1.Trial, runList *unstructured.UnstructuredList, probeTime *metav1.Time) (*ctrl.Result, error) {
+	var dirty bool
+	for i := range runList.Items {
+		startedAt, finishedAt, failure, err := trial.RunStatus(t.Spec.RunTemplate, &runList.Items[i], probeTime)
+		if err != nil {
+			return &ctrl.Result{}, err
+		}
+
+		// Adjust the trial start time
+		if startTime, updated := latestTime(t.Status.StartTime, startedAt, t.Spec.StartTimeOffset); updated {
+			t.Status.StartTime = startTime
+			dirty = true
+		}
+
+		// Adjust the trial completion time
+		if completionTime, updated := earliestTime(t.Status.CompletionTime, finishedAt); updated {
+			t.Status.CompletionTime = completionTime
+			dirty = true
+		}
+
+		// Mark the trial as failed if the resource met the failure condition
+		if failure != "" {
+			trial.ApplyCondition(&t.Status, redskyv1beta1.TrialFailed, corev1.ConditionTrue, trial.ReasonRunFailed, failure, probeTime)
+			dirty = true
+		}
+	}
+
+	if dirty {
+		err := r.Update(ctx, t)
+		return controller.RequeueConflict(err)
+	}
+	return nil, nil
+}
+
 // listJobs will return all of the jobs for the trial
 func (r *TrialJobReconciler) listJobs(ctx context.Context, jobList *batchv1.JobList, namespace string, selector *metav1.LabelSelector) error {
 	matchingSelector, err := meta.MatchingSelector(selector)
@@ -186,6 +492,14 @@ func (r *TrialJobReconciler) applyJobStatus(ctx context.Context, t *redskyv1beta
 					trial.ApplyCondition(&t.Status, redskyv1beta1.TrialFailed, corev1.ConditionTrue, s.Reason, "", time)
 					dirty = true
 				}
+				// The results container keeps the pod running when another container fails without writing the results file
+				for _, cs := range s.ContainerStatuses {
+					if t.Spec.ResultsFile && !trial.IsResultsContainer(cs.Name) && cs.State.Terminated != nil && cs.State.Terminated.ExitCode != 0 {
+						message := fmt.Sprintf("container %s exited with code %d", cs.Name, cs.State.Terminated.ExitCode)
+						trial.ApplyCondition(&t.Status, redskyv1beta1.TrialFailed, corev1.ConditionTrue, cs.State.Terminated.Reason, message, time)
+						dirty = true
+					}
+				}
 				// TODO We should consolidate this with `internal/ready/podFailed`
 				for _, c := range s.Conditions {
 					if c.Type == corev1.PodScheduled && c.Status == corev1.ConditionFalse && c.Reason == corev1.PodReasonUnschedulable {
@@ -229,6 +543,10 @@ func (r *TrialJobReconciler) applyJobStatus(ctx context.Context, t *redskyv1beta
 func containerTime(pods *corev1.PodList) (startedAt *metav1.Time, finishedAt *metav1.Time) {
 	for i := range pods.Items {
 		for j := range pods.Items[i].Status.ContainerStatuses {
+			// The results container finishes after the trial run, once the results file is reported
+			if trial.IsResultsContainer(pods.Items[i].Status.ContainerStatuses[j].Name) {
+				continue
+			}
 			s := &pods.Items[i].Status.ContainerStatuses[j].State
 			if s.Running != nil {
 				startedAt, _ = earliestTime(startedAt, &s.Running.StartedAt)
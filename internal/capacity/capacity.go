@@ -0,0 +1,350 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+)
+
+// Footprint is the estimated scheduling footprint of a workload
+type Footprint struct {
+	// Name identifies the workload in messages
+	Name string
+	// Replicas is the number of pods the workload runs
+	Replicas int32
+	// Requests are the resource requests of a single pod
+	Requests corev1.ResourceList
+}
+
+// Total returns the resource requests of all of the pods of the workload
+func (f *Footprint) Total() corev1.ResourceList {
+	total := make(corev1.ResourceList, len(f.Requests))
+	for name, q := range f.Requests {
+		total[name] = *resource.NewMilliQuantity(q.MilliValue()*int64(f.Replicas), q.Format)
+	}
+	return total
+}
+
+// Target is a patch target along with the patches that will be applied to it
+type Target struct {
+	// Ref is the reference to the patch target
+	Ref corev1.ObjectReference
+	// Patches are the operations on the target in the order they are applied
+	Patches []redskyv1beta1.PatchOperation
+}
+
+// Targets groups the patch operations by target, preserving the order of the targets and of the patches on each target
+func Targets(ops []redskyv1beta1.PatchOperation) []Target {
+	type key struct{ apiVersion, kind, namespace, name string }
+	var targets []Target
+	index := make(map[key]int, len(ops))
+	for i := range ops {
+		ref := &ops[i].TargetRef
+		k := key{apiVersion: ref.APIVersion, kind: ref.Kind, namespace: ref.Namespace, name: ref.Name}
+		if n, ok := index[k]; ok {
+			targets[n].Patches = append(targets[n].Patches, ops[i])
+			continue
+		}
+		index[k] = len(targets)
+		targets = append(targets, Target{Ref: *ref, Patches: []redskyv1beta1.PatchOperation{ops[i]}})
+	}
+	return targets
+}
+
+// NewFootprint returns the footprint of a workload, or nil if the object is not a supported workload type
+func NewFootprint(u *unstructured.Unstructured) (*Footprint, error) {
+	return PatchedFootprint(u)
+}
+
+// PatchedFootprint returns the footprint a workload will have after the supplied patches are applied in order, or nil
+// if the object is not a supported workload type
+func PatchedFootprint(u *unstructured.Unstructured, patches ...redskyv1beta1.PatchOperation) (*Footprint, error) {
+	obj := newWorkload(u)
+	if obj == nil {
+		return nil, nil
+	}
+
+	patched, err := json.Marshal(u.Object)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range patches {
+		if patched, err = applyPatch(patched, obj, patches[i].PatchType, patches[i].Data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := json.Unmarshal(patched, obj); err != nil {
+		return nil, err
+	}
+
+	f := &Footprint{Name: fmt.Sprintf("%s/%s", strings.ToLower(u.GetKind()), u.GetName()), Replicas: 1}
+	switch w := obj.(type) {
+	case *appsv1.Deployment:
+		f.setReplicas(w.Spec.Replicas)
+		f.Requests = PodRequests(&w.Spec.Template.Spec)
+	case *appsv1.StatefulSet:
+		f.setReplicas(w.Spec.Replicas)
+		f.Requests = PodRequests(&w.Spec.Template.Spec)
+	case *appsv1.ReplicaSet:
+		f.setReplicas(w.Spec.Replicas)
+		f.Requests = PodRequests(&w.Spec.Template.Spec)
+	case *batchv1.Job:
+		f.setReplicas(w.Spec.Parallelism)
+		f.Requests = PodRequests(&w.Spec.Template.Spec)
+	case *corev1.Pod:
+		f.Requests = PodRequests(&w.Spec)
+	}
+	return f, nil
+}
+
+// PodRequests returns the effective resource requests used to schedule a pod with the supplied specification
+func PodRequests(spec *corev1.PodSpec) corev1.ResourceList {
+	requests := corev1.ResourceList{}
+	for i := range spec.Containers {
+		add(requests, spec.Containers[i].Resources.Requests)
+	}
+
+	// Init containers run one at a time so only the largest request matters
+	for i := range spec.InitContainers {
+		for name, q := range spec.InitContainers[i].Resources.Requests {
+			if v, ok := requests[name]; !ok || q.Cmp(v) > 0 {
+				requests[name] = q.DeepCopy()
+			}
+		}
+	}
+
+	add(requests, spec.Overhead)
+	return requests
+}
+
+// Capacity is the allocatable and unrequested resources of the schedulable nodes in a cluster
+type Capacity struct {
+	// Allocatable is the allocatable resources of each schedulable node
+	Allocatable []corev1.ResourceList
+	// Free is the allocatable resources of each schedulable node which are not requested by a running pod
+	Free []corev1.ResourceList
+}
+
+// NewCapacity computes the capacity of the supplied nodes given the pods currently scheduled on them
+func NewCapacity(nodes []corev1.Node, pods []corev1.Pod) *Capacity {
+	c := &Capacity{}
+	index := make(map[string]int, len(nodes))
+	for i := range nodes {
+		if !schedulable(&nodes[i]) {
+			continue
+		}
+		index[nodes[i].Name] = len(c.Allocatable)
+		c.Allocatable = append(c.Allocatable, nodes[i].Status.Allocatable.DeepCopy())
+		c.Free = append(c.Free, nodes[i].Status.Allocatable.DeepCopy())
+	}
+
+	for i := range pods {
+		p := &pods[i]
+		if p.Status.Phase == corev1.PodSucceeded || p.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		if n, ok := index[p.Spec.NodeName]; ok {
+			for name, q := range PodRequests(&p.Spec) {
+				if v, ok := c.Free[n][name]; ok {
+					v.Sub(q)
+					c.Free[n][name] = v
+				}
+			}
+		}
+	}
+
+	return c
+}
+
+// Check estimates if the demand fits in the cluster once the currently running released workloads are replaced. An
+// empty message indicates the demand fits; otherwise the message describes the shortfall and infeasible indicates
+// the demand can never fit on the current nodes (as opposed to not fitting until other pods are removed).
+func (c *Capacity) Check(demand, released []Footprint) (message string, infeasible bool) {
+	// Without any nodes there is nothing we can reasonably estimate against
+	if len(c.Allocatable) == 0 {
+		return "", false
+	}
+
+	// Every pod must fit on at least one node
+	for i := range demand {
+		if demand[i].Replicas > 0 && !fitsAny(c.Allocatable, demand[i].Requests) {
+			return fmt.Sprintf("pods of %s request %s which exceeds the allocatable resources of every node",
+				demand[i].Name, format(demand[i].Requests)), true
+		}
+	}
+
+	total := corev1.ResourceList{}
+	for i := range demand {
+		add(total, demand[i].Total())
+	}
+
+	allocatable := corev1.ResourceList{}
+	free := corev1.ResourceList{}
+	for i := range c.Allocatable {
+		add(allocatable, c.Allocatable[i])
+		add(free, c.Free[i])
+	}
+	for i := range released {
+		add(free, released[i].Total())
+	}
+
+	if name, ok := exceeds(total, allocatable); ok {
+		return fmt.Sprintf("total %s request of %s exceeds the cluster allocatable %s",
+			name, quantity(total, name), quantity(allocatable, name)), true
+	}
+	if name, ok := exceeds(total, free); ok {
+		return fmt.Sprintf("insufficient %s: %s requested, %s available",
+			name, quantity(total, name), quantity(free, name)), false
+	}
+	return "", false
+}
+
+// applyPatch applies a single patch to the JSON representation of a workload
+func applyPatch(original []byte, obj interface{}, patchType types.PatchType, data []byte) ([]byte, error) {
+	switch patchType {
+	case types.StrategicMergePatchType:
+		return strategicpatch.StrategicMergePatch(original, data, obj)
+	case types.MergePatchType:
+		return jsonpatch.MergePatch(original, data)
+	case types.JSONPatchType:
+		p, err := jsonpatch.DecodePatch(data)
+		if err != nil {
+			return nil, err
+		}
+		return p.Apply(original)
+	default:
+		return nil, fmt.Errorf("unsupported patch type: %s", patchType)
+	}
+}
+
+func (f *Footprint) setReplicas(replicas *int32) {
+	if replicas != nil {
+		f.Replicas = *replicas
+	}
+}
+
+// newWorkload returns an empty typed object for the supported workload types
+func newWorkload(u *unstructured.Unstructured) interface{} {
+	gvk := u.GroupVersionKind()
+	switch gvk.GroupKind().String() {
+	case "Deployment.apps":
+		return &appsv1.Deployment{}
+	case "StatefulSet.apps":
+		return &appsv1.StatefulSet{}
+	case "ReplicaSet.apps":
+		return &appsv1.ReplicaSet{}
+	case "Job.batch":
+		return &batchv1.Job{}
+	case "Pod":
+		return &corev1.Pod{}
+	}
+	return nil
+}
+
+// schedulable checks to see if new pods can be scheduled on a node
+func schedulable(n *corev1.Node) bool {
+	if n.Spec.Unschedulable {
+		return false
+	}
+	for _, c := range n.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return true
+}
+
+// fitsAny checks to see if the requests fit within at least one of the resource lists
+func fitsAny(capacity []corev1.ResourceList, requests corev1.ResourceList) bool {
+	for i := range capacity {
+		if _, ok := exceeds(requests, capacity[i]); !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// exceeds returns the name of the first (non-zero) resource in the requests which exceeds the capacity
+func exceeds(requests, capacity corev1.ResourceList) (corev1.ResourceName, bool) {
+	for _, name := range names(requests) {
+		q := requests[name]
+		if q.IsZero() {
+			continue
+		}
+		if v, ok := capacity[name]; !ok || q.Cmp(v) > 0 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// add accumulates the resources from the source list into the destination list
+func add(dst, src corev1.ResourceList) {
+	for name, q := range src {
+		if v, ok := dst[name]; ok {
+			v.Add(q)
+			dst[name] = v
+		} else {
+			dst[name] = q.DeepCopy()
+		}
+	}
+}
+
+// quantity returns the string representation of a single resource in a list
+func quantity(rl corev1.ResourceList, name corev1.ResourceName) string {
+	q, ok := rl[name]
+	if !ok {
+		q = resource.Quantity{}
+	}
+	return q.String()
+}
+
+// format returns a string representation of the non-zero resources in a list
+func format(rl corev1.ResourceList) string {
+	var s []string
+	for _, name := range names(rl) {
+		if q := rl[name]; !q.IsZero() {
+			s = append(s, fmt.Sprintf("%s=%s", name, q.String()))
+		}
+	}
+	return strings.Join(s, ", ")
+}
+
+// names returns the sorted resource names in a list
+func names(rl corev1.ResourceList) []corev1.ResourceName {
+	n := make([]corev1.ResourceName, 0, len(rl))
+	for name := range rl {
+		n = append(n, name)
+	}
+	sort.Slice(n, func(i, j int) bool { return n[i] < n[j] })
+	return n
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package capacity
+
+import (
+	"testing"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestPatchedFootprint(t *testing.T) {
+	deployment := map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "Deployment",
+		"metadata":   map[string]interface{}{"name": "app", "namespace": "default"},
+		"spec": map[string]interface{}{
+			"replicas": int64(2),
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{
+							"name":      "app",
+							"resources": map[string]interface{}{"requests": map[string]interface{}{"cpu": "500m", "memory": "1Gi"}},
+						},
+						map[string]interface{}{
+							"name":      "sidecar",
+							"resources": map[string]interface{}{"requests": map[string]interface{}{"cpu": "100m"}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	cases := []struct {
+		desc     string
+		obj      map[string]interface{}
+		patches  []redskyv1beta1.PatchOperation
+		expected *Footprint
+	}{
+		{
+			desc: "unpatched",
+			obj:  deployment,
+			expected: &Footprint{
+				Name:     "deployment/app",
+				Replicas: 2,
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("600m"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+			},
+		},
+		{
+			desc:    "strategic",
+			obj:     deployment,
+			patches: []redskyv1beta1.PatchOperation{{PatchType: types.StrategicMergePatchType, Data: []byte(`{"spec":{"template":{"spec":{"containers":[{"name":"app","resources":{"requests":{"cpu":"2"}}}]}}}}`)}},
+			expected: &Footprint{
+				Name:     "deployment/app",
+				Replicas: 2,
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2100m"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+			},
+		},
+		{
+			desc:    "merge",
+			obj:     deployment,
+			patches: []redskyv1beta1.PatchOperation{{PatchType: types.MergePatchType, Data: []byte(`{"spec":{"replicas":5}}`)}},
+			expected: &Footprint{
+				Name:     "deployment/app",
+				Replicas: 5,
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("600m"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+			},
+		},
+		{
+			desc:    "json",
+			obj:     deployment,
+			patches: []redskyv1beta1.PatchOperation{{PatchType: types.JSONPatchType, Data: []byte(`[{"op":"replace","path":"/spec/template/spec/containers/0/resources/requests/memory","value":"4Gi"}]`)}},
+			expected: &Footprint{
+				Name:     "deployment/app",
+				Replicas: 2,
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("600m"), corev1.ResourceMemory: resource.MustParse("4Gi")},
+			},
+		},
+		{
+			desc: "multiple",
+			obj:  deployment,
+			patches: []redskyv1beta1.PatchOperation{
+				{PatchType: types.StrategicMergePatchType, Data: []byte(`{"spec":{"template":{"spec":{"containers":[{"name":"app","resources":{"requests":{"cpu":"2"}}}]}}}}`)},
+				{PatchType: types.MergePatchType, Data: []byte(`{"spec":{"replicas":3}}`)},
+			},
+			expected: &Footprint{
+				Name:     "deployment/app",
+				Replicas: 3,
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2100m"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+			},
+		},
+		{
+			desc: "unsupported",
+			obj: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata":   map[string]interface{}{"name": "app", "namespace": "default"},
+			},
+			patches: []redskyv1beta1.PatchOperation{{PatchType: types.MergePatchType, Data: []byte(`{"data":{"foo":"bar"}}`)}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			u := &unstructured.Unstructured{Object: c.obj}
+			f, err := PatchedFootprint(u, c.patches...)
+			if assert.NoError(t, err) {
+				if c.expected == nil {
+					assert.Nil(t, f)
+					return
+				}
+				if assert.NotNil(t, f) {
+					assert.Equal(t, c.expected.Name, f.Name)
+					assert.Equal(t, c.expected.Replicas, f.Replicas)
+					assert.Equal(t, format(c.expected.Requests), format(f.Requests))
+				}
+			}
+		})
+	}
+}
+
+func TestTargets(t *testing.T) {
+	app := corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "app"}
+	db := corev1.ObjectReference{APIVersion: "apps/v1", Kind: "StatefulSet", Namespace: "default", Name: "db"}
+	ops := []redskyv1beta1.PatchOperation{
+		{TargetRef: app, Data: []byte("1")},
+		{TargetRef: db, Data: []byte("2")},
+		{TargetRef: app, Data: []byte("3")},
+	}
+
+	targets := Targets(ops)
+	if assert.Len(t, targets, 2) {
+		assert.Equal(t, app, targets[0].Ref)
+		assert.Equal(t, []redskyv1beta1.PatchOperation{ops[0], ops[2]}, targets[0].Patches)
+		assert.Equal(t, db, targets[1].Ref)
+		assert.Equal(t, []redskyv1beta1.PatchOperation{ops[1]}, targets[1].Patches)
+	}
+}
+
+func TestPodRequests(t *testing.T) {
+	spec := &corev1.PodSpec{
+		InitContainers: []corev1.Container{
+			{Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")}}},
+		},
+		Containers: []corev1.Container{
+			{Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("128Mi")}}},
+			{Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("128Mi")}}},
+		},
+		Overhead: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+	}
+	assert.Equal(t, "cpu=2, memory=320Mi", format(PodRequests(spec)))
+}
+
+func TestCapacity_Check(t *testing.T) {
+	nodes := []corev1.Node{
+		newNode("node-1", "4", "8Gi", true),
+		newNode("node-2", "4", "8Gi", true),
+		newNode("node-3", "16", "64Gi", false),
+	}
+	pods := []corev1.Pod{
+		newPod("node-1", "3", "2Gi", corev1.PodRunning),
+		newPod("node-2", "2", "2Gi", corev1.PodRunning),
+		newPod("node-2", "2", "2Gi", corev1.PodSucceeded),
+		newPod("node-3", "2", "2Gi", corev1.PodRunning),
+	}
+
+	cases := []struct {
+		desc       string
+		demand     []Footprint
+		released   []Footprint
+		message    string
+		infeasible bool
+	}{
+		{
+			desc:   "fits",
+			demand: []Footprint{newFootprint("deployment/app", 2, "1", "1Gi")},
+		},
+		{
+			desc:       "pod too large",
+			demand:     []Footprint{newFootprint("deployment/app", 1, "8", "1Gi")},
+			message:    "pods of deployment/app request cpu=8, memory=1Gi which exceeds the allocatable resources of every node",
+			infeasible: true,
+		},
+		{
+			desc:       "missing resource",
+			demand:     []Footprint{{Name: "pod/gpu", Replicas: 1, Requests: corev1.ResourceList{"nvidia.com/gpu": resource.MustParse("1")}}},
+			message:    "pods of pod/gpu request nvidia.com/gpu=1 which exceeds the allocatable resources of every node",
+			infeasible: true,
+		},
+		{
+			desc:       "cluster too small",
+			demand:     []Footprint{newFootprint("statefulset/db", 3, "3", "1Gi")},
+			message:    "total cpu request of 9 exceeds the cluster allocatable 8",
+			infeasible: true,
+		},
+		{
+			desc:    "insufficient",
+			demand:  []Footprint{newFootprint("deployment/app", 4, "1", "1Gi")},
+			message: "insufficient cpu: 4 requested, 3 available",
+		},
+		{
+			desc:     "released",
+			demand:   []Footprint{newFootprint("deployment/app", 4, "1", "1Gi")},
+			released: []Footprint{newFootprint("deployment/app", 2, "1", "1Gi")},
+		},
+	}
+	cluster := NewCapacity(nodes, pods)
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			message, infeasible := cluster.Check(c.demand, c.released)
+			assert.Equal(t, c.message, message)
+			assert.Equal(t, c.infeasible, infeasible)
+		})
+	}
+}
+
+func newNode(name, cpu, memory string, ready bool) corev1.Node {
+	status := corev1.ConditionTrue
+	if !ready {
+		status = corev1.ConditionFalse
+	}
+	n := corev1.Node{}
+	n.Name = name
+	n.Status.Allocatable = corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu), corev1.ResourceMemory: resource.MustParse(memory)}
+	n.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status}}
+	return n
+}
+
+func newPod(node, cpu, memory string, phase corev1.PodPhase) corev1.Pod {
+	p := corev1.Pod{}
+	p.Spec.NodeName = node
+	p.Spec.Containers = []corev1.Container{{Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu), corev1.ResourceMemory: resource.MustParse(memory)}}}}
+	p.Status.Phase = phase
+	return p
+}
+
+func newFootprint(name string, replicas int32, cpu, memory string) Footprint {
+	return Footprint{Name: name, Replicas: replicas, Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu), corev1.ResourceMemory: resource.MustParse(memory)}}
+}
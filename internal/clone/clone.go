@@ -0,0 +1,156 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clone
+
+import (
+	"strings"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/redskyops/redskyops-controller/internal/trial"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// DefaultKinds are the types of supporting objects cloned when the clone configuration does not specify any
+var DefaultKinds = []schema.GroupVersionKind{
+	{Version: "v1", Kind: "ConfigMap"},
+	{Version: "v1", Kind: "Secret"},
+	{Version: "v1", Kind: "Service"},
+	{Version: "v1", Kind: "ServiceAccount"},
+	{Group: "apps", Version: "v1", Kind: "Deployment"},
+	{Group: "apps", Version: "v1", Kind: "StatefulSet"},
+}
+
+// SourceNamespace returns the namespace the application is cloned from
+func SourceNamespace(exp *redskyv1beta1.Experiment) string {
+	if exp.Spec.Clone != nil && exp.Spec.Clone.Namespace != "" {
+		return exp.Spec.Clone.Namespace
+	}
+	return exp.Namespace
+}
+
+// Kinds returns the types of supporting objects to clone
+func Kinds(c *redskyv1beta1.ApplicationClone) []schema.GroupVersionKind {
+	if len(c.Kinds) == 0 {
+		return DefaultKinds
+	}
+	kinds := make([]schema.GroupVersionKind, 0, len(c.Kinds))
+	for _, k := range c.Kinds {
+		kinds = append(kinds, schema.GroupVersionKind{Group: k.Group, Version: k.Version, Kind: k.Kind})
+	}
+	return kinds
+}
+
+// Targets returns the references to patch targets in the trial namespace, these are the objects that must be cloned
+func Targets(t *redskyv1beta1.Trial) []corev1.ObjectReference {
+	var refs []corev1.ObjectReference
+	for i := range t.Status.PatchOperations {
+		ref := &t.Status.PatchOperations[i].TargetRef
+		if ref.Namespace == t.Namespace && !trial.IsTrialJobReference(t, ref) {
+			refs = append(refs, *ref)
+		}
+	}
+	return refs
+}
+
+// NewObject returns a copy of the source object which can be created in the target namespace, or nil if the object
+// should not be cloned (e.g. it is managed by another object or it is generated by the cluster)
+func NewObject(src *unstructured.Unstructured, namespace string) *unstructured.Unstructured {
+	// Objects owned by a controller are re-created by the cloned controller
+	if metav1.GetControllerOf(src) != nil {
+		return nil
+	}
+
+	// Service account tokens are generated for the cloned service accounts
+	if src.GetKind() == "Secret" && src.GetAPIVersion() == "v1" {
+		if t, _, _ := unstructured.NestedString(src.Object, "type"); t == string(corev1.SecretTypeServiceAccountToken) {
+			return nil
+		}
+	}
+
+	u := &unstructured.Unstructured{Object: rewriteNamespace(src.DeepCopy().Object, src.GetNamespace(), namespace).(map[string]interface{})}
+
+	// Only keep the user specified metadata
+	u.SetNamespace(namespace)
+	u.SetUID("")
+	u.SetResourceVersion("")
+	u.SetGeneration(0)
+	u.SetSelfLink("")
+	u.SetCreationTimestamp(metav1.Time{})
+	u.SetDeletionTimestamp(nil)
+	u.SetDeletionGracePeriodSeconds(nil)
+	u.SetOwnerReferences(nil)
+	u.SetFinalizers(nil)
+	u.SetManagedFields(nil)
+	unstructured.RemoveNestedField(u.Object, "status")
+
+	// Remove the fields allocated by the cluster
+	switch u.GroupVersionKind().GroupKind() {
+	case schema.GroupKind{Kind: "Service"}:
+		if ip, _, _ := unstructured.NestedString(u.Object, "spec", "clusterIP"); ip != corev1.ClusterIPNone {
+			unstructured.RemoveNestedField(u.Object, "spec", "clusterIP")
+		}
+		unstructured.RemoveNestedField(u.Object, "spec", "clusterIPs")
+		unstructured.RemoveNestedField(u.Object, "spec", "healthCheckNodePort")
+		if ports, ok, _ := unstructured.NestedSlice(u.Object, "spec", "ports"); ok {
+			for i := range ports {
+				if p, ok := ports[i].(map[string]interface{}); ok {
+					delete(p, "nodePort")
+				}
+			}
+			_ = unstructured.SetNestedSlice(u.Object, ports, "spec", "ports")
+		}
+	case schema.GroupKind{Kind: "ServiceAccount"}:
+		unstructured.RemoveNestedField(u.Object, "secrets")
+	case schema.GroupKind{Kind: "PersistentVolumeClaim"}:
+		unstructured.RemoveNestedField(u.Object, "spec", "volumeName")
+		annotations := u.GetAnnotations()
+		delete(annotations, "pv.kubernetes.io/bind-completed")
+		delete(annotations, "pv.kubernetes.io/bound-by-controller")
+		u.SetAnnotations(annotations)
+	}
+
+	return u
+}
+
+// rewriteNamespace replaces references to the source namespace: "namespace" fields with the exact value and in-cluster
+// DNS names of the form "<service>.<namespace>.svc"
+func rewriteNamespace(v interface{}, from, to string) interface{} {
+	if from == "" || from == to {
+		return v
+	}
+
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		for k := range vv {
+			if s, ok := vv[k].(string); ok && k == "namespace" && s == from {
+				vv[k] = to
+				continue
+			}
+			vv[k] = rewriteNamespace(vv[k], from, to)
+		}
+	case []interface{}:
+		for i := range vv {
+			vv[i] = rewriteNamespace(vv[i], from, to)
+		}
+	case string:
+		return strings.ReplaceAll(vv, "."+from+".svc", "."+to+".svc")
+	}
+	return v
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package clone
+
+import (
+	"testing"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestNewObject(t *testing.T) {
+	cases := []struct {
+		desc     string
+		src      map[string]interface{}
+		expected map[string]interface{}
+	}{
+		{
+			desc: "metadata",
+			src: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata": map[string]interface{}{
+					"name":              "app",
+					"namespace":         "default",
+					"uid":               "1234",
+					"resourceVersion":   "42",
+					"creationTimestamp": "2020-01-01T00:00:00Z",
+					"labels":            map[string]interface{}{"app": "test"},
+				},
+				"data": map[string]interface{}{"url": "http://db.default.svc.cluster.local:5432"},
+			},
+			expected: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "ConfigMap",
+				"metadata": map[string]interface{}{
+					"name":      "app",
+					"namespace": "trial-1",
+					"labels":    map[string]interface{}{"app": "test"},
+				},
+				"data": map[string]interface{}{"url": "http://db.trial-1.svc.cluster.local:5432"},
+			},
+		},
+		{
+			desc: "service",
+			src: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Service",
+				"metadata":   map[string]interface{}{"name": "app", "namespace": "default"},
+				"spec": map[string]interface{}{
+					"type":      "NodePort",
+					"clusterIP": "10.0.0.1",
+					"ports":     []interface{}{map[string]interface{}{"port": int64(80), "nodePort": int64(30080)}},
+				},
+				"status": map[string]interface{}{"loadBalancer": map[string]interface{}{}},
+			},
+			expected: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Service",
+				"metadata":   map[string]interface{}{"name": "app", "namespace": "trial-1"},
+				"spec": map[string]interface{}{
+					"type":  "NodePort",
+					"ports": []interface{}{map[string]interface{}{"port": int64(80)}},
+				},
+			},
+		},
+		{
+			desc: "headless service",
+			src: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Service",
+				"metadata":   map[string]interface{}{"name": "app", "namespace": "default"},
+				"spec":       map[string]interface{}{"clusterIP": "None"},
+			},
+			expected: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Service",
+				"metadata":   map[string]interface{}{"name": "app", "namespace": "trial-1"},
+				"spec":       map[string]interface{}{"clusterIP": "None"},
+			},
+		},
+		{
+			desc: "role binding subjects",
+			src: map[string]interface{}{
+				"apiVersion": "rbac.authorization.k8s.io/v1",
+				"kind":       "RoleBinding",
+				"metadata":   map[string]interface{}{"name": "app", "namespace": "default"},
+				"subjects":   []interface{}{map[string]interface{}{"kind": "ServiceAccount", "name": "app", "namespace": "default"}},
+			},
+			expected: map[string]interface{}{
+				"apiVersion": "rbac.authorization.k8s.io/v1",
+				"kind":       "RoleBinding",
+				"metadata":   map[string]interface{}{"name": "app", "namespace": "trial-1"},
+				"subjects":   []interface{}{map[string]interface{}{"kind": "ServiceAccount", "name": "app", "namespace": "trial-1"}},
+			},
+		},
+		{
+			desc: "service account token",
+			src: map[string]interface{}{
+				"apiVersion": "v1",
+				"kind":       "Secret",
+				"metadata":   map[string]interface{}{"name": "app-token", "namespace": "default"},
+				"type":       string(corev1.SecretTypeServiceAccountToken),
+			},
+		},
+		{
+			desc: "controlled",
+			src: map[string]interface{}{
+				"apiVersion": "apps/v1",
+				"kind":       "ReplicaSet",
+				"metadata": map[string]interface{}{
+					"name":            "app-1234",
+					"namespace":       "default",
+					"ownerReferences": []interface{}{map[string]interface{}{"apiVersion": "apps/v1", "kind": "Deployment", "name": "app", "uid": "1234", "controller": true}},
+				},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			actual := NewObject(&unstructured.Unstructured{Object: c.src}, "trial-1")
+			if c.expected == nil {
+				assert.Nil(t, actual)
+			} else if assert.NotNil(t, actual) {
+				assert.Equal(t, c.expected, actual.Object)
+			}
+		})
+	}
+}
+
+func TestTargets(t *testing.T) {
+	tt := &redskyv1beta1.Trial{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "trial-1", Name: "test-001"},
+		Status: redskyv1beta1.TrialStatus{PatchOperations: []redskyv1beta1.PatchOperation{
+			{TargetRef: corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "trial-1", Name: "app"}},
+			{TargetRef: corev1.ObjectReference{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "shared", Name: "db"}},
+			{TargetRef: corev1.ObjectReference{APIVersion: "batch/v1", Kind: "Job", Namespace: "trial-1", Name: "test-001"}},
+		}},
+	}
+	assert.Equal(t, []corev1.ObjectReference{
+		{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "trial-1", Name: "app"},
+	}, Targets(tt))
+}
+
+func TestSourceNamespace(t *testing.T) {
+	exp := &redskyv1beta1.Experiment{ObjectMeta: metav1.ObjectMeta{Namespace: "default"}}
+	assert.Equal(t, "default", SourceNamespace(exp))
+	exp.Spec.Clone = &redskyv1beta1.ApplicationClone{Namespace: "app"}
+	assert.Equal(t, "app", SourceNamespace(exp))
+}
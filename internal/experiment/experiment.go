@@ -25,6 +25,9 @@ import (
 const (
 	// HasTrialFinalizer is a finalizer that indicates an experiment has at least one trial
 	HasTrialFinalizer = "hasTrialFinalizer.redskyops.dev"
+	// HasNamespaceFinalizer is a finalizer that indicates an experiment has at least one namespace created from its
+	// namespace template
+	HasNamespaceFinalizer = "hasNamespaceFinalizer.redskyops.dev"
 )
 
 // TODO Make the constant names better reflect the code, not the text
@@ -43,6 +46,8 @@ const (
 	PhaseCompleted = "Completed"
 	// PhaseDeleted indicates that the experiment has been deleted and is waiting for trials to be cleaned up
 	PhaseDeleted = "Deleted"
+	// PhaseNamespaceFailed indicates that trial namespaces cannot be created from the namespace template
+	PhaseNamespaceFailed = "Namespace failed"
 )
 
 // UpdateStatus will ensure the experiment's status matches what is in the supplied trial list; returns true only if
@@ -98,6 +103,10 @@ func summarize(exp *redskyv1beta1.Experiment, activeTrials int32, totalTrials in
 		return PhasePaused
 	}
 
+	if exp.Status.NamespaceFailure != nil {
+		return PhaseNamespaceFailed
+	}
+
 	if totalTrials == 0 {
 		if remote {
 			return PhaseCreated
@@ -18,13 +18,23 @@ package experiment
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
 
 	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/redskyops/redskyops-controller/internal/meta"
 	"github.com/redskyops/redskyops-controller/internal/trial"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	apierrs "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -48,7 +58,10 @@ func NextTrialNamespace(ctx context.Context, c client.Client, exp *redskyv1beta1
 
 	// Match the potential namespaces
 	var selector client.ListOption
-	if n := exp.Spec.TrialTemplate.Namespace; n != "" {
+	if IsPooled(exp) {
+		// When using a namespace pool, trials only run in pooled namespaces
+		selector = client.MatchingLabels{redskyv1beta1.LabelNamespacePool: exp.Name}
+	} else if n := exp.Spec.TrialTemplate.Namespace; n != "" {
 		// If there is an explicit target namespace on the trial template it is the only one we will be allowed to use
 		selector = client.MatchingFields{"metadata.name": n}
 	} else if exp.Spec.NamespaceSelector == nil && exp.Spec.NamespaceTemplate == nil {
@@ -69,13 +82,17 @@ func NextTrialNamespace(ctx context.Context, c client.Client, exp *redskyv1beta1
 		return "", err
 	}
 	for i := range namespaceList.Items {
+		// Namespaces which are being deleted (e.g. they were cleaned up) cannot be used
+		if !namespaceList.Items[i].DeletionTimestamp.IsZero() || namespaceList.Items[i].Status.Phase == corev1.NamespaceTerminating {
+			continue
+		}
 		if n := namespaceList.Items[i].Name; !activeNamespaces[n] {
 			return n, nil
 		}
 	}
 
-	// If we could not find a namespace, we may be able to create it
-	if exp.Spec.NamespaceTemplate != nil {
+	// If we could not find a namespace, we may be able to create it (pooled namespaces are created ahead of time)
+	if exp.Spec.NamespaceTemplate != nil && !IsPooled(exp) && NamespaceRetryAfter(exp, time.Now()) == 0 {
 		return createNamespaceFromTemplate(ctx, c, exp)
 	}
 
@@ -83,6 +100,85 @@ func NextTrialNamespace(ctx context.Context, c client.Client, exp *redskyv1beta1
 	return "", nil
 }
 
+// NamespaceRetryInterval is the amount of time to wait before creating another namespace from a template which failed
+// because of insufficient permissions, the permissions may be granted without changing the experiment
+const NamespaceRetryInterval = 5 * time.Minute
+
+// namespaceFailureError indicates a namespace could not be created or isolated because of insufficient permissions,
+// the failure is recorded on the experiment status
+type namespaceFailureError struct {
+	err error
+}
+
+func (e *namespaceFailureError) Error() string {
+	return e.err.Error()
+}
+
+func (e *namespaceFailureError) Unwrap() error {
+	return e.err
+}
+
+// IsNamespaceFailure checks to see if the error is a namespace failure, the status of the experiment was modified to
+// record the failure and must be updated
+func IsNamespaceFailure(err error) bool {
+	nf := &namespaceFailureError{}
+	return errors.As(err, &nf)
+}
+
+// NamespaceRetryAfter returns the amount of time until namespaces may be created from the namespace template again,
+// zero is returned if there is no recorded failure for the current namespace template
+func NamespaceRetryAfter(exp *redskyv1beta1.Experiment, now time.Time) time.Duration {
+	f := exp.Status.NamespaceFailure
+	if f == nil || f.TemplateHash != namespaceTemplateHash(exp) {
+		return 0
+	}
+	if remaining := f.LastAttemptTime.Add(NamespaceRetryInterval).Sub(now); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// UpdateNamespaceFailure clears the recorded namespace failure once the namespace template changes or a namespace is
+// created from the template after the failure; returns true only if changes were necessary
+func UpdateNamespaceFailure(exp *redskyv1beta1.Experiment, namespaceList *corev1.NamespaceList) bool {
+	f := exp.Status.NamespaceFailure
+	if f == nil {
+		return false
+	}
+
+	resolved := f.TemplateHash != namespaceTemplateHash(exp)
+	for i := range namespaceList.Items {
+		n := &namespaceList.Items[i]
+		if n.DeletionTimestamp.IsZero() && IsCreatedNamespace(exp, n) && n.CreationTimestamp.After(f.LastAttemptTime.Time) {
+			resolved = true
+		}
+	}
+
+	if resolved {
+		exp.Status.NamespaceFailure = nil
+	}
+	return resolved
+}
+
+// namespaceFailed records a namespace failure on the experiment status
+func namespaceFailed(exp *redskyv1beta1.Experiment, err error) error {
+	exp.Status.NamespaceFailure = &redskyv1beta1.NamespaceFailure{
+		Message:         err.Error(),
+		TemplateHash:    namespaceTemplateHash(exp),
+		LastAttemptTime: metav1.Now(),
+	}
+	return &namespaceFailureError{err: err}
+}
+
+// namespaceTemplateHash returns a value which only changes when the namespace template changes
+func namespaceTemplateHash(exp *redskyv1beta1.Experiment) string {
+	data, err := json.Marshal(exp.Spec.NamespaceTemplate)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
 func ignorePermissions(err error) error {
 	if apierrs.IsUnauthorized(err) {
 		return nil
@@ -107,16 +203,26 @@ func createNamespaceFromTemplate(ctx context.Context, c client.Client, exp *reds
 	n.Labels[redskyv1beta1.LabelExperiment] = exp.Name
 	n.Labels[redskyv1beta1.LabelTrialRole] = "trialSetup"
 
-	// TODO We should also record the fact that we created the namespace for possible clean up later
+	// Record the fact that we created the namespace so it can be cleaned up later
+	if n.Annotations == nil {
+		n.Annotations = map[string]string{}
+	}
+	n.Annotations[redskyv1beta1.AnnotationNamespaceTemplate] = namespaceTemplateRef(exp)
+	if IsPooled(exp) {
+		n.Labels[redskyv1beta1.LabelNamespacePool] = exp.Name
+	}
 
 	// NOTE: The ignorePermission call is in different places for the namespace and supporting objects because
 	// if the namespace creation fails we cannot continue creating the supporting objects
 	if err := c.Create(ctx, n); err != nil {
 		// Ignore duplicates, e.g. it is possible that the namespace template has an explicit name
-		if apierrs.IsAlreadyExists(err) || ignorePermissions(err) == nil {
+		if apierrs.IsAlreadyExists(err) {
 			return "", nil
 		}
-		// TODO Fail the experiment? Set replicas to activeTrials? Just ignore log it and don't do anything?
+		// Retrying will not help until the permissions or the template change
+		if ignorePermissions(err) == nil {
+			return "", namespaceFailed(exp, fmt.Errorf("unable to create trial namespace: %w", err))
+		}
 		return "", err
 	}
 
@@ -138,14 +244,236 @@ func createNamespaceFromTemplate(ctx context.Context, c client.Client, exp *reds
 		}
 	}
 
+	// Unlike the setup service account, the isolation objects are not optional: remove a namespace we could not
+	// isolate so trials do not run in it without the limits that were requested
+	var isolation []runtime.Object
+	if ts.ResourceQuota != nil {
+		isolation = append(isolation, ts.ResourceQuota)
+	}
+	if ts.LimitRange != nil {
+		isolation = append(isolation, ts.LimitRange)
+	}
+	if ts.NetworkPolicy != nil {
+		isolation = append(isolation, ts.NetworkPolicy)
+	}
+	for _, obj := range isolation {
+		if err := c.Create(ctx, obj); err != nil {
+			_ = c.Delete(ctx, n)
+			ierr := fmt.Errorf("unable to isolate trial namespace %s: %w", n.Name, err)
+			if ignorePermissions(err) == nil {
+				// Do not create (and delete) another namespace that cannot be isolated either
+				return "", namespaceFailed(exp, ierr)
+			}
+			return "", ierr
+		}
+	}
+
 	return n.Name, nil
 }
 
+// CreatedNamespaceLabels returns the labels which match namespaces created from the namespace template of the
+// experiment; matching namespaces must also be checked using `IsCreatedNamespace`
+func CreatedNamespaceLabels(exp *redskyv1beta1.Experiment) client.MatchingLabels {
+	return client.MatchingLabels{
+		redskyv1beta1.LabelExperiment: exp.Name,
+		redskyv1beta1.LabelTrialRole:  "trialSetup",
+	}
+}
+
+// IsCreatedNamespace checks to see if the namespace was created from the namespace template of the experiment
+func IsCreatedNamespace(exp *redskyv1beta1.Experiment, n *corev1.Namespace) bool {
+	return n.Annotations[redskyv1beta1.AnnotationNamespaceTemplate] == namespaceTemplateRef(exp)
+}
+
+// UpdateCreatedNamespaces records the namespaces created from the namespace template (which are not being deleted)
+// on the experiment; returns true only if changes were necessary
+func UpdateCreatedNamespaces(exp *redskyv1beta1.Experiment, namespaceList *corev1.NamespaceList) bool {
+	var names []string
+	for i := range namespaceList.Items {
+		n := &namespaceList.Items[i]
+		if n.DeletionTimestamp.IsZero() && IsCreatedNamespace(exp, n) {
+			names = append(names, n.Name)
+		}
+	}
+	sort.Strings(names)
+
+	// Finalizers cannot be added once the experiment is deleted
+	var dirty bool
+	if len(names) > 0 {
+		if exp.DeletionTimestamp.IsZero() {
+			dirty = meta.AddFinalizer(exp, HasNamespaceFinalizer) || dirty
+		}
+	} else {
+		dirty = meta.RemoveFinalizer(exp, HasNamespaceFinalizer) || dirty
+	}
+
+	if !reflect.DeepEqual(exp.Status.CreatedNamespaces, names) {
+		exp.Status.CreatedNamespaces = names
+		dirty = true
+	}
+	return dirty
+}
+
+// NamespaceNeedsCleanup checks to see if a namespace created from the namespace template should be deleted. Once the
+// experiment is deleted, namespaces are deleted as soon as none of the experiment's trials remain in them; otherwise
+// the namespace must be idle for the TTL on the namespace template. If the namespace will need cleanup in the future,
+// the amount of time until the TTL expires is returned.
+func NamespaceNeedsCleanup(exp *redskyv1beta1.Experiment, n *corev1.Namespace, trialList *redskyv1beta1.TrialList, now time.Time) (bool, time.Duration) {
+	// Already deleted, no cleanup necessary
+	if !n.DeletionTimestamp.IsZero() {
+		return false, 0
+	}
+
+	// Find the trials running in the namespace
+	var trials []*redskyv1beta1.Trial
+	for i := range trialList.Items {
+		if trialList.Items[i].Namespace == n.Name {
+			trials = append(trials, &trialList.Items[i])
+		}
+	}
+
+	// Wait for the trials to be removed (e.g. to allow setup deletion) if the experiment is gone
+	if !exp.DeletionTimestamp.IsZero() {
+		return len(trials) == 0, 0
+	}
+
+	// Pooled namespaces are kept for the next trial
+	if n.Labels[redskyv1beta1.LabelNamespacePool] != "" {
+		return false, 0
+	}
+
+	// Without a TTL, namespaces are only deleted with the experiment
+	if exp.Spec.NamespaceTemplate == nil || exp.Spec.NamespaceTemplate.TTLSecondsAfterFinished == nil || *exp.Spec.NamespaceTemplate.TTLSecondsAfterFinished < 0 {
+		return false, 0
+	}
+
+	// Determine when the namespace became idle
+	finishTime := n.CreationTimestamp.Time
+	for _, t := range trials {
+		if trial.IsActive(t) {
+			return false, 0
+		}
+		for _, c := range t.Status.Conditions {
+			if c.Status == corev1.ConditionTrue && c.LastTransitionTime.After(finishTime) {
+				finishTime = c.LastTransitionTime.Time
+			}
+		}
+	}
+
+	ttl := time.Duration(*exp.Spec.NamespaceTemplate.TTLSecondsAfterFinished) * time.Second
+	if remaining := finishTime.Add(ttl).Sub(now); remaining > 0 {
+		return false, remaining
+	}
+	return true, 0
+}
+
+// IsPooled checks to see if the experiment uses a pool of namespaces created ahead of time
+func IsPooled(exp *redskyv1beta1.Experiment) bool {
+	return exp.Spec.NamespaceTemplate != nil && exp.Spec.NamespaceTemplate.PoolSize > 0
+}
+
+// CreatePoolNamespace creates a new namespace for the pool, returning an empty string if the namespace already exists;
+// insufficient permissions are reported as a namespace failure (see `IsNamespaceFailure`)
+func CreatePoolNamespace(ctx context.Context, c client.Client, exp *redskyv1beta1.Experiment) (string, error) {
+	return createNamespaceFromTemplate(ctx, c, exp)
+}
+
+// ResizePool returns the number of namespaces which must be created to fill the pool and the idle namespaces which
+// exceed the pool size and should be deleted
+func ResizePool(exp *redskyv1beta1.Experiment, trialList *redskyv1beta1.TrialList, namespaceList *corev1.NamespaceList) (int, []*corev1.Namespace) {
+	// Only maintain the pool while the experiment exists
+	if !IsPooled(exp) || !exp.DeletionTimestamp.IsZero() {
+		return 0, nil
+	}
+
+	var pooled int32
+	var idle []*corev1.Namespace
+	active := activeNamespaces(trialList)
+	for i := range namespaceList.Items {
+		n := &namespaceList.Items[i]
+		if !isPoolNamespace(exp, n) {
+			continue
+		}
+		pooled++
+		if !active[n.Name] {
+			idle = append(idle, n)
+		}
+	}
+
+	size := exp.Spec.NamespaceTemplate.PoolSize
+	if pooled < size {
+		return int(size - pooled), nil
+	}
+
+	// Prefer deleting the namespaces without setup applied
+	sort.SliceStable(idle, func(i, j int) bool {
+		return idle[i].Annotations[redskyv1beta1.AnnotationSetupHash] == "" && idle[j].Annotations[redskyv1beta1.AnnotationSetupHash] != ""
+	})
+	excess := int(pooled - size)
+	if excess > len(idle) {
+		excess = len(idle)
+	}
+	return 0, idle[:excess]
+}
+
+// UpdatePoolStatus records the health of the namespace pool on the experiment; returns true only if changes were
+// necessary
+func UpdatePoolStatus(exp *redskyv1beta1.Experiment, trialList *redskyv1beta1.TrialList, namespaceList *corev1.NamespaceList) bool {
+	var status *redskyv1beta1.NamespacePoolStatus
+	if IsPooled(exp) {
+		status = &redskyv1beta1.NamespacePoolStatus{Size: exp.Spec.NamespaceTemplate.PoolSize}
+		noSetup := len(exp.Spec.TrialTemplate.Spec.SetupTasks) == 0
+		active := activeNamespaces(trialList)
+		for i := range namespaceList.Items {
+			n := &namespaceList.Items[i]
+			if !isPoolNamespace(exp, n) {
+				continue
+			}
+			status.Ready++
+			if active[n.Name] {
+				status.InUse++
+			} else if noSetup || n.Annotations[redskyv1beta1.AnnotationSetupHash] != "" {
+				status.Warm++
+			}
+		}
+	}
+
+	if reflect.DeepEqual(exp.Status.NamespacePool, status) {
+		return false
+	}
+	exp.Status.NamespacePool = status
+	return true
+}
+
+// isPoolNamespace checks to see if the namespace is part of the experiment's namespace pool
+func isPoolNamespace(exp *redskyv1beta1.Experiment, n *corev1.Namespace) bool {
+	return n.DeletionTimestamp.IsZero() && n.Labels[redskyv1beta1.LabelNamespacePool] == exp.Name && IsCreatedNamespace(exp, n)
+}
+
+// activeNamespaces returns the namespaces with an active trial
+func activeNamespaces(trialList *redskyv1beta1.TrialList) map[string]bool {
+	active := make(map[string]bool, len(trialList.Items))
+	for i := range trialList.Items {
+		if trial.IsActive(&trialList.Items[i]) {
+			active[trialList.Items[i].Namespace] = true
+		}
+	}
+	return active
+}
+
+// namespaceTemplateRef returns the value used to identify namespaces created for the experiment
+func namespaceTemplateRef(exp *redskyv1beta1.Experiment) string {
+	return exp.Namespace + "/" + exp.Name
+}
+
 // trialNamespace represents the supporting resources for a trial namespace
 type trialNamespace struct {
 	ServiceAccount *corev1.ServiceAccount
 	Role           *rbacv1.Role
 	RoleBindings   []rbacv1.RoleBinding
+	ResourceQuota  *corev1.ResourceQuota
+	LimitRange     *corev1.LimitRange
+	NetworkPolicy  *networkingv1.NetworkPolicy
 }
 
 func createTrialNamespace(exp *redskyv1beta1.Experiment, namespace string) *trialNamespace {
@@ -210,6 +538,39 @@ func createTrialNamespace(exp *redskyv1beta1.Experiment, namespace string) *tria
 		})
 	}
 
+	// Add the objects used to isolate concurrent trials from each other
+	if nt := exp.Spec.NamespaceTemplate; nt != nil {
+		if nt.ResourceQuota != nil {
+			ts.ResourceQuota = &corev1.ResourceQuota{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "redsky-trial-quota",
+					Namespace: namespace,
+				},
+				Spec: *nt.ResourceQuota.DeepCopy(),
+			}
+		}
+
+		if nt.LimitRange != nil {
+			ts.LimitRange = &corev1.LimitRange{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "redsky-trial-limits",
+					Namespace: namespace,
+				},
+				Spec: *nt.LimitRange.DeepCopy(),
+			}
+		}
+
+		if nt.NetworkPolicy != nil {
+			ts.NetworkPolicy = &networkingv1.NetworkPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "redsky-trial-network-policy",
+					Namespace: namespace,
+				},
+				Spec: *nt.NetworkPolicy.DeepCopy(),
+			}
+		}
+	}
+
 	// Don't actually return the default service account for creation
 	if ts.ServiceAccount.Name == "default" {
 		ts.ServiceAccount = nil
@@ -0,0 +1,270 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package experiment
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNamespaceNeedsCleanup(t *testing.T) {
+	now := time.Date(2020, 6, 1, 12, 0, 0, 0, time.UTC)
+	created := metav1.NewTime(now.Add(-time.Hour))
+	deleted := metav1.NewTime(now)
+	ttl := int32(600)
+
+	exp := func(ttl *int32, deletionTimestamp *metav1.Time) *redskyv1beta1.Experiment {
+		return &redskyv1beta1.Experiment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test", DeletionTimestamp: deletionTimestamp},
+			Spec:       redskyv1beta1.ExperimentSpec{NamespaceTemplate: &redskyv1beta1.NamespaceTemplateSpec{TTLSecondsAfterFinished: ttl}},
+		}
+	}
+	finished := func(ago time.Duration) redskyv1beta1.Trial {
+		return redskyv1beta1.Trial{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "test-1"},
+			Status: redskyv1beta1.TrialStatus{Conditions: []redskyv1beta1.TrialCondition{
+				{Type: redskyv1beta1.TrialComplete, Status: corev1.ConditionTrue, LastTransitionTime: metav1.NewTime(now.Add(-ago))},
+			}},
+		}
+	}
+	running := redskyv1beta1.Trial{ObjectMeta: metav1.ObjectMeta{Namespace: "test-1"}}
+	other := redskyv1beta1.Trial{ObjectMeta: metav1.ObjectMeta{Namespace: "test-2"}}
+
+	cases := []struct {
+		desc      string
+		exp       *redskyv1beta1.Experiment
+		trials    []redskyv1beta1.Trial
+		deleted   bool
+		cleanup   bool
+		remaining time.Duration
+	}{
+		{desc: "no ttl", exp: exp(nil, nil), trials: []redskyv1beta1.Trial{finished(time.Hour)}},
+		{desc: "running", exp: exp(&ttl, nil), trials: []redskyv1beta1.Trial{running}},
+		{desc: "expired", exp: exp(&ttl, nil), trials: []redskyv1beta1.Trial{finished(20 * time.Minute)}, cleanup: true},
+		{desc: "not expired", exp: exp(&ttl, nil), trials: []redskyv1beta1.Trial{finished(time.Minute)}, remaining: 9 * time.Minute},
+		{desc: "no trials", exp: exp(&ttl, nil), trials: []redskyv1beta1.Trial{other}, cleanup: true},
+		{desc: "experiment deleted", exp: exp(nil, &deleted), trials: []redskyv1beta1.Trial{other}, cleanup: true},
+		{desc: "experiment deleted with trials", exp: exp(nil, &deleted), trials: []redskyv1beta1.Trial{finished(time.Hour)}},
+		{desc: "namespace deleted", exp: exp(&ttl, nil), deleted: true},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			n := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-1", CreationTimestamp: created}}
+			if c.deleted {
+				n.DeletionTimestamp = &deleted
+			}
+			cleanup, remaining := NamespaceNeedsCleanup(c.exp, n, &redskyv1beta1.TrialList{Items: c.trials}, now)
+			assert.Equal(t, c.cleanup, cleanup)
+			assert.Equal(t, c.remaining, remaining)
+		})
+	}
+}
+
+func TestUpdateCreatedNamespaces(t *testing.T) {
+	exp := &redskyv1beta1.Experiment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"}}
+	created := func(name, ref string) corev1.Namespace {
+		return corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: map[string]string{redskyv1beta1.AnnotationNamespaceTemplate: ref}}}
+	}
+	deleting := created("test-c", "default/test")
+	deleting.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+
+	namespaceList := &corev1.NamespaceList{Items: []corev1.Namespace{
+		created("test-b", "default/test"),
+		created("test-a", "default/test"),
+		created("other", "other/test"),
+		deleting,
+	}}
+	assert.True(t, UpdateCreatedNamespaces(exp, namespaceList))
+	assert.Equal(t, []string{"test-a", "test-b"}, exp.Status.CreatedNamespaces)
+	assert.Contains(t, exp.Finalizers, HasNamespaceFinalizer)
+	assert.False(t, UpdateCreatedNamespaces(exp, namespaceList))
+
+	assert.True(t, UpdateCreatedNamespaces(exp, &corev1.NamespaceList{}))
+	assert.Empty(t, exp.Status.CreatedNamespaces)
+	assert.NotContains(t, exp.Finalizers, HasNamespaceFinalizer)
+
+	// A deleted experiment which gave up on its namespaces must not get the finalizer back
+	exp.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+	assert.True(t, UpdateCreatedNamespaces(exp, namespaceList))
+	assert.Equal(t, []string{"test-a", "test-b"}, exp.Status.CreatedNamespaces)
+	assert.NotContains(t, exp.Finalizers, HasNamespaceFinalizer)
+}
+
+func TestNamespacePool(t *testing.T) {
+	exp := &redskyv1beta1.Experiment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"},
+		Spec: redskyv1beta1.ExperimentSpec{
+			NamespaceTemplate: &redskyv1beta1.NamespaceTemplateSpec{PoolSize: 3},
+			TrialTemplate: redskyv1beta1.TrialTemplateSpec{Spec: redskyv1beta1.TrialSpec{
+				SetupTasks: []redskyv1beta1.SetupTask{{Name: "app"}},
+			}},
+		},
+	}
+	pooled := func(name, hash string) corev1.Namespace {
+		return corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Labels:      map[string]string{redskyv1beta1.LabelNamespacePool: "test"},
+			Annotations: map[string]string{redskyv1beta1.AnnotationNamespaceTemplate: "default/test", redskyv1beta1.AnnotationSetupHash: hash},
+		}}
+	}
+	trialList := &redskyv1beta1.TrialList{Items: []redskyv1beta1.Trial{{ObjectMeta: metav1.ObjectMeta{Namespace: "test-a"}}}}
+
+	// Fill the pool
+	namespaceList := &corev1.NamespaceList{Items: []corev1.Namespace{pooled("test-a", "abc"), pooled("test-b", "abc")}}
+	create, excess := ResizePool(exp, trialList, namespaceList)
+	assert.Equal(t, 1, create)
+	assert.Empty(t, excess)
+
+	assert.True(t, UpdatePoolStatus(exp, trialList, namespaceList))
+	assert.Equal(t, &redskyv1beta1.NamespacePoolStatus{Size: 3, Ready: 2, InUse: 1, Warm: 1}, exp.Status.NamespacePool)
+	assert.False(t, UpdatePoolStatus(exp, trialList, namespaceList))
+
+	// Shrink the pool, preferring namespaces without setup and never deleting namespaces in use
+	exp.Spec.NamespaceTemplate.PoolSize = 1
+	namespaceList.Items = append(namespaceList.Items, pooled("test-c", ""))
+	create, excess = ResizePool(exp, trialList, namespaceList)
+	assert.Equal(t, 0, create)
+	if assert.Len(t, excess, 2) {
+		assert.Equal(t, "test-c", excess[0].Name)
+		assert.Equal(t, "test-b", excess[1].Name)
+	}
+
+	// Pooled namespaces are not subject to the TTL
+	ttl := int32(0)
+	exp.Spec.NamespaceTemplate.TTLSecondsAfterFinished = &ttl
+	cleanup, _ := NamespaceNeedsCleanup(exp, &namespaceList.Items[1], trialList, time.Now())
+	assert.False(t, cleanup)
+
+	// Without a pool there is no pool status
+	exp.Spec.NamespaceTemplate.PoolSize = 0
+	assert.True(t, UpdatePoolStatus(exp, trialList, namespaceList))
+	assert.Nil(t, exp.Status.NamespacePool)
+}
+
+func TestCreateTrialNamespace(t *testing.T) {
+	exp := &redskyv1beta1.Experiment{Spec: redskyv1beta1.ExperimentSpec{
+		NamespaceTemplate: &redskyv1beta1.NamespaceTemplateSpec{
+			ResourceQuota: &corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("4")}},
+			LimitRange: &corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{{
+				Type:    corev1.LimitTypeContainer,
+				Default: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("512Mi")},
+			}}},
+			NetworkPolicy: &networkingv1.NetworkPolicySpec{
+				Ingress: []networkingv1.NetworkPolicyIngressRule{{From: []networkingv1.NetworkPolicyPeer{{PodSelector: &metav1.LabelSelector{}}}}},
+			},
+		},
+	}}
+
+	ts := createTrialNamespace(exp, "test-1")
+	assert.Nil(t, ts.ServiceAccount)
+	assert.Nil(t, ts.Role)
+	assert.Empty(t, ts.RoleBindings)
+	if assert.NotNil(t, ts.ResourceQuota) {
+		assert.Equal(t, "test-1", ts.ResourceQuota.Namespace)
+		assert.Equal(t, *exp.Spec.NamespaceTemplate.ResourceQuota, ts.ResourceQuota.Spec)
+	}
+	if assert.NotNil(t, ts.LimitRange) {
+		assert.Equal(t, "test-1", ts.LimitRange.Namespace)
+		assert.Equal(t, *exp.Spec.NamespaceTemplate.LimitRange, ts.LimitRange.Spec)
+	}
+	if assert.NotNil(t, ts.NetworkPolicy) {
+		assert.Equal(t, "test-1", ts.NetworkPolicy.Namespace)
+		assert.Equal(t, *exp.Spec.NamespaceTemplate.NetworkPolicy, ts.NetworkPolicy.Spec)
+	}
+
+	ts = createTrialNamespace(&redskyv1beta1.Experiment{}, "test-2")
+	assert.Nil(t, ts.ResourceQuota)
+	assert.Nil(t, ts.LimitRange)
+	assert.Nil(t, ts.NetworkPolicy)
+}
+
+// forbidQuotaClient is a client without permission to create resource quotas
+type forbidQuotaClient struct {
+	client.Client
+}
+
+func (c *forbidQuotaClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	if _, ok := obj.(*corev1.ResourceQuota); ok {
+		return apierrs.NewForbidden(schema.GroupResource{Resource: "resourcequotas"}, "redsky-trial-quota", fmt.Errorf("denied"))
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestCreateNamespaceFromTemplate(t *testing.T) {
+	ctx := context.TODO()
+	exp := &redskyv1beta1.Experiment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"},
+		Spec: redskyv1beta1.ExperimentSpec{
+			NamespaceTemplate: &redskyv1beta1.NamespaceTemplateSpec{
+				ObjectMeta:    metav1.ObjectMeta{Name: "test-1"},
+				ResourceQuota: &corev1.ResourceQuotaSpec{Hard: corev1.ResourceList{corev1.ResourceRequestsCPU: resource.MustParse("4")}},
+			},
+		},
+	}
+
+	// The namespace is created along with the quota
+	c := fake.NewFakeClientWithScheme(scheme.Scheme)
+	name, err := createNamespaceFromTemplate(ctx, c, exp)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "test-1", name)
+		assert.NoError(t, c.Get(ctx, client.ObjectKey{Name: "test-1"}, &corev1.Namespace{}))
+		assert.NoError(t, c.Get(ctx, client.ObjectKey{Namespace: "test-1", Name: "redsky-trial-quota"}, &corev1.ResourceQuota{}))
+	}
+
+	// A namespace without the quota is not left behind
+	c = &forbidQuotaClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme)}
+	name, err = createNamespaceFromTemplate(ctx, c, exp)
+	assert.EqualError(t, err, "unable to isolate trial namespace test-1: resourcequotas \"redsky-trial-quota\" is forbidden: denied")
+	assert.Empty(t, name)
+	assert.True(t, apierrs.IsNotFound(c.Get(ctx, client.ObjectKey{Name: "test-1"}, &corev1.Namespace{})))
+
+	// The failure is recorded so no more namespaces are created from the same template
+	assert.True(t, IsNamespaceFailure(err))
+	if assert.NotNil(t, exp.Status.NamespaceFailure) {
+		assert.Equal(t, err.Error(), exp.Status.NamespaceFailure.Message)
+	}
+	assert.True(t, NamespaceRetryAfter(exp, time.Now()) > 0)
+	assert.Zero(t, NamespaceRetryAfter(exp, time.Now().Add(NamespaceRetryInterval)))
+	assert.False(t, UpdateNamespaceFailure(exp, &corev1.NamespaceList{}))
+
+	exp.Spec.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"test": "true"}}
+	name, err = NextTrialNamespace(ctx, c, exp, &redskyv1beta1.TrialList{})
+	assert.NoError(t, err)
+	assert.Empty(t, name)
+	assert.True(t, apierrs.IsNotFound(c.Get(ctx, client.ObjectKey{Name: "test-1"}, &corev1.Namespace{})))
+
+	// Changing the template allows namespaces to be created again
+	exp.Spec.NamespaceTemplate.ResourceQuota = nil
+	assert.Zero(t, NamespaceRetryAfter(exp, time.Now()))
+	assert.True(t, UpdateNamespaceFailure(exp, &corev1.NamespaceList{}))
+	assert.Nil(t, exp.Status.NamespaceFailure)
+}
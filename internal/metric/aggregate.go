@@ -0,0 +1,106 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// aggregate reduces a list of observations to a single value, the standard deviation of the observations is also returned
+func aggregate(aggregation string, values []float64) (float64, float64, error) {
+	if len(values) == 0 {
+		return 0, 0, fmt.Errorf("no observations to aggregate")
+	}
+
+	var value float64
+	switch aggregation {
+	case "avg", "":
+		value = Mean(values)
+	case "last":
+		value = values[len(values)-1]
+	case "max":
+		value = values[0]
+		for _, v := range values[1:] {
+			value = math.Max(value, v)
+		}
+	case "min":
+		value = values[0]
+		for _, v := range values[1:] {
+			value = math.Min(value, v)
+		}
+	case "sum":
+		for _, v := range values {
+			value += v
+		}
+	default:
+		p, err := parsePercentile(aggregation)
+		if err != nil {
+			return 0, 0, err
+		}
+		value = percentile(values, p)
+	}
+
+	return value, StdDev(values), nil
+}
+
+// parsePercentile parses an aggregation of the form "pNN" into a percentile between 0 and 100
+func parsePercentile(aggregation string) (float64, error) {
+	if strings.HasPrefix(aggregation, "p") {
+		if p, err := strconv.ParseFloat(strings.TrimPrefix(aggregation, "p"), 64); err == nil && p >= 0 && p <= 100 {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported aggregation: %s (expected: avg, last, max, min, sum, pNN)", aggregation)
+}
+
+// Mean returns the arithmetic mean of the values
+func Mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// StdDev returns the sample standard deviation of the values
+func StdDev(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	m := Mean(values)
+	var ss float64
+	for _, v := range values {
+		ss += (v - m) * (v - m)
+	}
+	return math.Sqrt(ss / float64(len(values)-1))
+}
+
+// percentile returns the linearly interpolated percentile (0-100) of the values
+func percentile(values []float64, p float64) float64 {
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	return sorted[lower] + (rank-float64(lower))*(sorted[upper]-sorted[lower])
+}
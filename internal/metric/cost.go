@@ -0,0 +1,228 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/redskyops/redskyops-controller/internal/meta"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/yaml"
+)
+
+// Pricing is the format of the pricing document referenced by cost metrics. Prices are in dollars per hour for a
+// single unit of the resource: CPU is measured in cores, memory and ephemeral storage are measured in GiB and all other
+// resources (e.g. "nvidia.com/gpu") are measured in whole units.
+type Pricing struct {
+	// NodePoolLabel is the node label whose value identifies the node pool, e.g. "cloud.google.com/gke-nodepool"
+	NodePoolLabel string `json:"nodePoolLabel,omitempty"`
+	// Default prices are used for nodes which are not in a node pool with a price for the resource
+	Default ResourcePrices `json:"default,omitempty"`
+	// NodePools are the prices for each node pool, keyed by the value of the node pool label
+	NodePools map[string]ResourcePrices `json:"nodePools,omitempty"`
+}
+
+// ResourcePrices are the hourly prices of individual resources
+type ResourcePrices map[corev1.ResourceName]float64
+
+// ParsePricing parses and validates a pricing document
+func ParsePricing(data string) (*Pricing, error) {
+	p := &Pricing{}
+	if err := yaml.UnmarshalStrict([]byte(data), p); err != nil {
+		return nil, err
+	}
+
+	if len(p.Default) == 0 && len(p.NodePools) == 0 {
+		return nil, fmt.Errorf("pricing must include default or node pool prices")
+	}
+	if len(p.NodePools) > 0 && p.NodePoolLabel == "" {
+		return nil, fmt.Errorf("pricing must include a node pool label when node pool prices are specified")
+	}
+
+	check := func(where string, prices ResourcePrices) error {
+		for name, price := range prices {
+			if price < 0 {
+				return fmt.Errorf("%s price for '%s' must not be negative", where, name)
+			}
+		}
+		return nil
+	}
+	if err := check("default", p.Default); err != nil {
+		return nil, err
+	}
+	for pool, prices := range p.NodePools {
+		if err := check(fmt.Sprintf("node pool '%s'", pool), prices); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// price returns the hourly price of a resource on the specified node
+func (p *Pricing) price(node *corev1.Node, name corev1.ResourceName) float64 {
+	if node != nil && p.NodePoolLabel != "" {
+		if prices, ok := p.NodePools[node.Labels[p.NodePoolLabel]]; ok {
+			if price, ok := prices[name]; ok {
+				return price
+			}
+		}
+	}
+	return p.Default[name]
+}
+
+// sampleCostMetric returns the current hourly cost of the pods matched by the metric selector
+func sampleCostMetric(ctx context.Context, r client.Reader, namespace string, m *redskyv1beta1.Metric, trial *redskyv1beta1.Trial) (float64, error) {
+	if r == nil {
+		return 0, fmt.Errorf("unable to read cost information for '%s'", m.Name)
+	}
+
+	// Load the pricing from the experiment namespace
+	if m.Pricing == nil {
+		return 0, fmt.Errorf("missing pricing for '%s'", m.Name)
+	}
+	cm := &corev1.ConfigMap{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: namespace, Name: m.Pricing.Name}, cm); err != nil {
+		return 0, err
+	}
+	data, ok := cm.Data[m.Pricing.Key]
+	if !ok {
+		return 0, fmt.Errorf("config map '%s' does not contain key '%s'", m.Pricing.Name, m.Pricing.Key)
+	}
+	pricing, err := ParsePricing(data)
+	if err != nil {
+		return 0, err
+	}
+
+	// Find the pods in the trial namespace
+	pods := &corev1.PodList{}
+	if sel, err := meta.MatchingSelector(m.Selector); err != nil {
+		return 0, err
+	} else if err := r.List(ctx, pods, client.InNamespace(trial.Namespace), sel); err != nil {
+		return 0, err
+	}
+
+	// Determine the resources consumed by each pod
+	var resources map[string]corev1.ResourceList
+	switch strings.TrimSpace(m.Query) {
+	case "requests", "":
+		resources = podRequests(pods)
+	case "usage":
+		if resources, err = podUsage(ctx, r, trial.Namespace, pods); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("unknown cost basis '%s' (expected: requests, usage)", m.Query)
+	}
+
+	// Price the resources using the node each pod is running on
+	nodes := make(map[string]*corev1.Node)
+	var cost float64
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Spec.NodeName == "" || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+
+		node, ok := nodes[pod.Spec.NodeName]
+		if !ok {
+			node = &corev1.Node{}
+			if err := r.Get(ctx, types.NamespacedName{Name: pod.Spec.NodeName}, node); err != nil {
+				return 0, err
+			}
+			nodes[pod.Spec.NodeName] = node
+		}
+
+		for name, q := range resources[pod.Name] {
+			cost += costQuantity(name, q) * pricing.price(node, name)
+		}
+	}
+
+	return cost, nil
+}
+
+// podRequests returns the total resource requests of the containers in each pod
+func podRequests(pods *corev1.PodList) map[string]corev1.ResourceList {
+	result := make(map[string]corev1.ResourceList, len(pods.Items))
+	for _, pod := range pods.Items {
+		total := corev1.ResourceList{}
+		for _, c := range pod.Spec.Containers {
+			for name, q := range c.Resources.Requests {
+				if t, ok := total[name]; ok {
+					t.Add(q)
+					total[name] = t
+				} else {
+					total[name] = q.DeepCopy()
+				}
+			}
+		}
+		result[pod.Name] = total
+	}
+	return result
+}
+
+// podUsage returns the total resource usage of the containers in each pod as reported by the metrics API
+func podUsage(ctx context.Context, r client.Reader, namespace string, pods *corev1.PodList) (map[string]corev1.ResourceList, error) {
+	list := &unstructured.UnstructuredList{}
+	list.SetAPIVersion("metrics.k8s.io/v1beta1")
+	list.SetKind("PodMetricsList")
+	if err := r.List(ctx, list, client.InNamespace(namespace)); err != nil {
+		return nil, err
+	}
+
+	result := make(map[string]corev1.ResourceList, len(pods.Items))
+	for i := range list.Items {
+		total := corev1.ResourceList{}
+		containers, _, _ := unstructured.NestedSlice(list.Items[i].Object, "containers")
+		for _, c := range containers {
+			usage, _, _ := unstructured.NestedStringMap(c.(map[string]interface{}), "usage")
+			for name, s := range usage {
+				q, err := resource.ParseQuantity(s)
+				if err != nil {
+					return nil, err
+				}
+				if t, ok := total[corev1.ResourceName(name)]; ok {
+					t.Add(q)
+					total[corev1.ResourceName(name)] = t
+				} else {
+					total[corev1.ResourceName(name)] = q
+				}
+			}
+		}
+		result[list.Items[i].GetName()] = total
+	}
+	return result, nil
+}
+
+// costQuantity returns the float value of a quantity in the units used for pricing
+func costQuantity(name corev1.ResourceName, q resource.Quantity) float64 {
+	switch name {
+	case corev1.ResourceCPU:
+		return float64(q.MilliValue()) / 1000
+	case corev1.ResourceMemory, corev1.ResourceEphemeralStorage:
+		return float64(q.Value()) / (1 << 30)
+	default:
+		return float64(q.Value())
+	}
+}
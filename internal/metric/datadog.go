@@ -17,63 +17,127 @@ limitations under the License.
 package metric
 
 import (
-	"fmt"
-	"math"
+	"context"
+	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
 	datadog "github.com/zorkian/go-datadog-api"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func captureDatadogMetric(aggregator, query string, startTime, completionTime time.Time) (float64, float64, error) {
-	apiKey := os.Getenv("DATADOG_API_KEY")
-	if apiKey == "" {
-		apiKey = os.Getenv("DD_API_KEY")
+func captureDatadogMetric(ctx context.Context, r client.Reader, namespace string, m *redskyv1beta1.Metric, startTime, completionTime time.Time) (float64, float64, error) {
+	config := m.Datadog
+	if config == nil {
+		config = &redskyv1beta1.DatadogMetricConfig{}
 	}
 
-	applicationKey := os.Getenv("DATADOG_APP_KEY")
-	if applicationKey == "" {
-		applicationKey = os.Getenv("DD_APP_KEY")
+	apiKey, applicationKey, err := datadogKeys(ctx, r, namespace, config)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// The client does not accept a context, bind one to the requests so the metric timeout is honored
+	c := datadog.NewClient(apiKey, applicationKey)
+	c.HttpClient = &http.Client{Transport: &contextRoundTripper{ctx: ctx, next: http.DefaultTransport}}
+	if deadline, ok := ctx.Deadline(); ok {
+		c.RetryTimeout = time.Until(deadline)
+	}
+	if m.URL != "" {
+		c.SetBaseUrl(m.URL)
+	} else if config.Site != "" {
+		c.SetBaseUrl("https://api." + strings.TrimPrefix(config.Site, "app."))
 	}
 
-	client := datadog.NewClient(apiKey, applicationKey)
+	// The scheme was historically used as the aggregator
+	aggregator := m.Aggregation
+	if aggregator == "" {
+		aggregator = m.Scheme
+	}
 
-	metrics, err := client.QueryMetrics(startTime.Unix(), completionTime.Unix(), query)
+	value, stddev, err := queryDatadog(c, m.Query, aggregator, config.SeriesAggregation, startTime, completionTime)
 	if err != nil {
 		return 0, 0, err
 	}
 
-	if len(metrics) != 1 {
-		return 0, 0, fmt.Errorf("expected one series")
+	// Execute the error query (if configured)
+	if m.ErrorQuery != "" {
+		if stddev, _, err = queryDatadog(c, m.ErrorQuery, aggregator, config.SeriesAggregation, startTime, completionTime); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	return value, stddev, nil
+}
+
+// queryDatadog returns the aggregated value of a query, if the query produces multiple series the error is the
+// standard deviation of the values across series
+func queryDatadog(c *datadog.Client, query, aggregator, seriesAggregation string, startTime, completionTime time.Time) (float64, float64, error) {
+	metrics, err := c.QueryMetrics(startTime.Unix(), completionTime.Unix(), query)
+	if err != nil {
+		return 0, 0, err
 	}
 
-	var value, n float64
-	for _, p := range metrics[0].Points {
-		if p[1] == nil {
+	// Reduce the points of each series to a single value
+	var values []float64
+	for _, s := range metrics {
+		var points []float64
+		for _, p := range s.Points {
+			if p[1] == nil {
+				continue
+			}
+			points = append(points, *p[1])
+		}
+		if len(points) == 0 {
 			continue
 		}
 
-		// TODO What is `metrics[0].Aggr`?
-		switch aggregator {
-		case "avg", "":
-			value = value + *p[1]
-			n++
-		case "last":
-			value = *p[1]
-		case "max":
-			value = math.Max(value, *p[1])
-		case "min":
-			value = math.Min(value, *p[1])
-		case "sum":
-			value = value + *p[1]
-		default:
-			return 0, 0, fmt.Errorf("unsupported aggregator: %s (expected: avg, last, max, min, sum)", aggregator)
+		v, _, err := aggregate(aggregator, points)
+		if err != nil {
+			return 0, 0, err
+		}
+		values = append(values, v)
+	}
+
+	switch len(values) {
+	case 0:
+		return 0, 0, &CaptureError{Message: "metric data not available", Query: query, CompletionTime: completionTime}
+	case 1:
+		return values[0], 0, nil
+	default:
+		return aggregate(seriesAggregation, values)
+	}
+}
+
+// datadogKeys returns the API and application keys, falling back to the environment when no secret is referenced
+func datadogKeys(ctx context.Context, r client.Reader, namespace string, config *redskyv1beta1.DatadogMetricConfig) (string, string, error) {
+	s := &secretResolver{reader: r, namespace: namespace}
+
+	apiKey := os.Getenv("DATADOG_API_KEY")
+	if apiKey == "" {
+		apiKey = os.Getenv("DD_API_KEY")
+	}
+	if config.APIKey != nil {
+		v, err := s.value(ctx, config.APIKey)
+		if err != nil {
+			return "", "", err
 		}
+		apiKey = string(v)
 	}
 
-	if n > 0 {
-		value = value / n
+	applicationKey := os.Getenv("DATADOG_APP_KEY")
+	if applicationKey == "" {
+		applicationKey = os.Getenv("DD_APP_KEY")
+	}
+	if config.ApplicationKey != nil {
+		v, err := s.value(ctx, config.ApplicationKey)
+		if err != nil {
+			return "", "", err
+		}
+		applicationKey = string(v)
 	}
 
-	return value, 0, nil
+	return apiKey, applicationKey, nil
 }
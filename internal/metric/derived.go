@@ -0,0 +1,344 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+)
+
+// DerivedInputs returns the names of the metrics referenced by the expression of a derived metric
+func DerivedInputs(query string) ([]string, error) {
+	e, err := parseExpression(query)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	seen := make(map[string]bool)
+	e.walk(func(n *node) {
+		if n.op == opMetric && !seen[n.name] {
+			seen[n.name] = true
+			names = append(names, n.name)
+		}
+	})
+	return names, nil
+}
+
+// DependenciesCaptured checks to see if all of the metrics a metric depends on have been captured
+func DependenciesCaptured(m *redskyv1beta1.Metric, trial *redskyv1beta1.Trial) bool {
+	if m == nil || m.Type != redskyv1beta1.MetricDerived {
+		return true
+	}
+
+	names, err := DerivedInputs(m.Query)
+	if err != nil {
+		// Allow the capture to fail with the parse error
+		return true
+	}
+
+	for _, name := range names {
+		captured := false
+		for _, v := range trial.Spec.Values {
+			if v.Name == name && v.AttemptsRemaining == 0 && v.Value != "" {
+				captured = true
+				break
+			}
+		}
+		if !captured {
+			return false
+		}
+	}
+	return true
+}
+
+func captureDerivedMetric(m *redskyv1beta1.Metric, trial *redskyv1beta1.Trial) (float64, float64, error) {
+	e, err := parseExpression(m.Query)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Index the captured values
+	values := make(map[string]measurement, len(trial.Spec.Values))
+	for _, v := range trial.Spec.Values {
+		if v.AttemptsRemaining > 0 || v.Value == "" {
+			continue
+		}
+		mv := measurement{}
+		if mv.value, err = strconv.ParseFloat(v.Value, 64); err != nil {
+			return 0, 0, err
+		}
+		if v.Error != "" {
+			if mv.err, err = strconv.ParseFloat(v.Error, 64); err != nil {
+				return 0, 0, err
+			}
+		}
+		values[v.Name] = mv
+	}
+
+	result, err := e.eval(values)
+	if err != nil {
+		return 0, 0, fmt.Errorf("unable to evaluate '%s': %w", m.Name, err)
+	}
+	if math.IsNaN(result.value) || math.IsInf(result.value, 0) {
+		return 0, 0, fmt.Errorf("unable to evaluate '%s': result is not a number", m.Name)
+	}
+	return result.value, result.err, nil
+}
+
+// measurement is a value and its error (standard deviation)
+type measurement struct {
+	value float64
+	err   float64
+}
+
+type op int
+
+const (
+	opNumber op = iota
+	opMetric
+	opNegate
+	opAdd
+	opSubtract
+	opMultiply
+	opDivide
+)
+
+// node is an element of the expression tree
+type node struct {
+	op          op
+	number      float64
+	name        string
+	left, right *node
+}
+
+func (n *node) walk(f func(*node)) {
+	f(n)
+	if n.left != nil {
+		n.left.walk(f)
+	}
+	if n.right != nil {
+		n.right.walk(f)
+	}
+}
+
+// eval evaluates the expression, propagating errors using a first order approximation assuming the inputs are independent
+func (n *node) eval(values map[string]measurement) (measurement, error) {
+	switch n.op {
+	case opNumber:
+		return measurement{value: n.number}, nil
+	case opMetric:
+		v, ok := values[n.name]
+		if !ok {
+			return measurement{}, fmt.Errorf("metric '%s' is not available", n.name)
+		}
+		return v, nil
+	case opNegate:
+		v, err := n.left.eval(values)
+		return measurement{value: -v.value, err: v.err}, err
+	}
+
+	a, err := n.left.eval(values)
+	if err != nil {
+		return measurement{}, err
+	}
+	b, err := n.right.eval(values)
+	if err != nil {
+		return measurement{}, err
+	}
+
+	switch n.op {
+	case opAdd:
+		return measurement{value: a.value + b.value, err: math.Hypot(a.err, b.err)}, nil
+	case opSubtract:
+		return measurement{value: a.value - b.value, err: math.Hypot(a.err, b.err)}, nil
+	case opMultiply:
+		return measurement{value: a.value * b.value, err: math.Hypot(b.value*a.err, a.value*b.err)}, nil
+	case opDivide:
+		if b.value == 0 {
+			return measurement{}, fmt.Errorf("division by zero")
+		}
+		return measurement{value: a.value / b.value, err: math.Hypot(a.err/b.value, a.value*b.err/(b.value*b.value))}, nil
+	default:
+		return measurement{}, fmt.Errorf("unknown operator")
+	}
+}
+
+// parseExpression parses an arithmetic expression of numbers and metric names
+func parseExpression(expr string) (*node, error) {
+	p := &parser{input: expr}
+	n, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, fmt.Errorf("unexpected '%c' at position %d in expression: %s", p.input[p.pos], p.pos, expr)
+	}
+	return n, nil
+}
+
+// parser is a recursive descent parser for the expression grammar:
+//   sum     = product { ("+" | "-") product }
+//   product = unary { ("*" | "/") unary }
+//   unary   = "-" unary | primary
+//   primary = number | name | '"' quoted-name '"' | "(" sum ")"
+// Names start with a letter or underscore and may contain letters, digits and underscores; names containing any other
+// characters (e.g. hyphens, which would otherwise be ambiguous with subtraction) must be double quoted.
+type parser struct {
+	input string
+	pos   int
+}
+
+func (p *parser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(rune(p.input[p.pos])) {
+		p.pos++
+	}
+}
+
+func (p *parser) peek() byte {
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return p.input[p.pos]
+	}
+	return 0
+}
+
+func (p *parser) parseSum() (*node, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var o op
+		switch p.peek() {
+		case '+':
+			o = opAdd
+		case '-':
+			o = opSubtract
+		default:
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = &node{op: o, left: left, right: right}
+	}
+}
+
+func (p *parser) parseProduct() (*node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var o op
+		switch p.peek() {
+		case '*':
+			o = opMultiply
+		case '/':
+			o = opDivide
+		default:
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &node{op: o, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (*node, error) {
+	if p.peek() == '-' {
+		p.pos++
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &node{op: opNegate, left: n}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (*node, error) {
+	c := p.peek()
+	switch {
+	case c == '(':
+		p.pos++
+		n, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ')' in expression: %s", p.input)
+		}
+		p.pos++
+		return n, nil
+
+	case c == '.' || (c >= '0' && c <= '9'):
+		start := p.pos
+		for p.pos < len(p.input) && strings.IndexByte("0123456789.eE", p.input[p.pos]) >= 0 {
+			// Allow a sign on the exponent
+			if (p.input[p.pos] == 'e' || p.input[p.pos] == 'E') && p.pos+1 < len(p.input) && strings.IndexByte("+-", p.input[p.pos+1]) >= 0 {
+				p.pos++
+			}
+			p.pos++
+		}
+		v, err := strconv.ParseFloat(p.input[start:p.pos], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s' in expression: %s", p.input[start:p.pos], p.input)
+		}
+		return &node{op: opNumber, number: v}, nil
+
+	case c == '"':
+		start := p.pos + 1
+		end := strings.IndexByte(p.input[start:], '"')
+		if end < 0 {
+			return nil, fmt.Errorf("missing '\"' in expression: %s", p.input)
+		}
+		if end == 0 {
+			return nil, fmt.Errorf("empty name at position %d in expression: %s", p.pos, p.input)
+		}
+		p.pos = start + end + 1
+		return &node{op: opMetric, name: p.input[start : start+end]}, nil
+
+	case c == '_' || unicode.IsLetter(rune(c)):
+		start := p.pos
+		for p.pos < len(p.input) {
+			r := rune(p.input[p.pos])
+			if r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				break
+			}
+			p.pos++
+		}
+		return &node{op: opMetric, name: p.input[start:p.pos]}, nil
+
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end of expression: %s", p.input)
+
+	default:
+		return nil, fmt.Errorf("unexpected '%c' at position %d in expression: %s", c, p.pos, p.input)
+	}
+}
@@ -0,0 +1,235 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// authRoundTripper adds authentication headers to each request
+type authRoundTripper struct {
+	header   http.Header
+	token    string
+	username string
+	password string
+	next     http.RoundTripper
+	// key identifies the resolved authentication and transport security configuration
+	key string
+}
+
+// RoundTrip adds the authentication headers to a copy of the request before delegating
+func (rt *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for k, v := range rt.header {
+		req.Header[k] = v
+	}
+	if rt.token != "" {
+		req.Header.Set("Authorization", "Bearer "+rt.token)
+	} else if rt.username != "" || rt.password != "" {
+		req.SetBasicAuth(rt.username, rt.password)
+	}
+	return rt.next.RoundTrip(req)
+}
+
+// contextRoundTripper runs each request under a context, used for clients which do not accept a context
+type contextRoundTripper struct {
+	ctx  context.Context
+	next http.RoundTripper
+}
+
+// RoundTrip delegates using a copy of the request bound to the context
+func (rt *contextRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return rt.next.RoundTrip(req.WithContext(rt.ctx))
+}
+
+// maxTransports bounds the transport cache, each distinct transport security configuration has its own transport
+const maxTransports = 100
+
+// transports caches the transports used for custom transport security configurations so connections are reused
+// across captures, the transports are keyed by a digest of the resolved configuration
+var transports = struct {
+	sync.Mutex
+	transports map[string]*http.Transport
+}{transports: make(map[string]*http.Transport)}
+
+// newRoundTripper returns a round tripper configured using the authentication of a metric, secret references are
+// resolved in the supplied namespace. A nil round tripper is returned if there is no authentication configured.
+func newRoundTripper(ctx context.Context, r client.Reader, namespace string, auth *redskyv1beta1.MetricAuthentication) (http.RoundTripper, error) {
+	if auth == nil {
+		return nil, nil
+	}
+
+	s := &secretResolver{reader: r, namespace: namespace}
+	rt := &authRoundTripper{header: make(http.Header), next: http.DefaultTransport}
+	var parts [][]byte
+
+	// Transport security
+	if auth.TLS != nil {
+		tlsConfig := &tls.Config{
+			ServerName:         auth.TLS.ServerName,
+			InsecureSkipVerify: auth.TLS.InsecureSkipVerify,
+		}
+		parts = append(parts, []byte(auth.TLS.ServerName), []byte(strconv.FormatBool(auth.TLS.InsecureSkipVerify)))
+
+		if auth.TLS.CA != nil {
+			ca, err := s.value(ctx, auth.TLS.CA)
+			if err != nil {
+				return nil, err
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("unable to parse certificate authority from secret '%s'", auth.TLS.CA.Name)
+			}
+			tlsConfig.RootCAs = pool
+			parts = append(parts, ca)
+		}
+
+		if auth.TLS.Cert != nil || auth.TLS.Key != nil {
+			if auth.TLS.Cert == nil || auth.TLS.Key == nil {
+				return nil, fmt.Errorf("client certificate authentication requires both a certificate and a key")
+			}
+			cert, err := s.value(ctx, auth.TLS.Cert)
+			if err != nil {
+				return nil, err
+			}
+			key, err := s.value(ctx, auth.TLS.Key)
+			if err != nil {
+				return nil, err
+			}
+			pair, err := tls.X509KeyPair(cert, key)
+			if err != nil {
+				return nil, err
+			}
+			tlsConfig.Certificates = []tls.Certificate{pair}
+			parts = append(parts, cert, key)
+		}
+
+		rt.next = cachedTransport(digest(parts...), tlsConfig)
+	}
+
+	// Credentials
+	if auth.BearerToken != nil {
+		token, err := s.value(ctx, auth.BearerToken)
+		if err != nil {
+			return nil, err
+		}
+		rt.token = string(token)
+	}
+
+	if auth.BasicAuth != nil {
+		username, err := s.value(ctx, &auth.BasicAuth.Username)
+		if err != nil {
+			return nil, err
+		}
+		password, err := s.value(ctx, &auth.BasicAuth.Password)
+		if err != nil {
+			return nil, err
+		}
+		rt.username, rt.password = string(username), string(password)
+	}
+	parts = append(parts, []byte(rt.token), []byte(rt.username), []byte(rt.password))
+
+	// Additional headers
+	for _, h := range auth.Headers {
+		value := h.Value
+		if h.ValueFrom != nil {
+			v, err := s.value(ctx, h.ValueFrom)
+			if err != nil {
+				return nil, err
+			}
+			value = string(v)
+		}
+		rt.header.Add(h.Name, value)
+		parts = append(parts, []byte(h.Name), []byte(value))
+	}
+
+	rt.key = digest(parts...)
+	return rt, nil
+}
+
+// cachedTransport returns the shared transport for a transport security configuration, creating it if necessary
+func cachedTransport(key string, tlsConfig *tls.Config) *http.Transport {
+	transports.Lock()
+	defer transports.Unlock()
+	if transport, ok := transports.transports[key]; ok {
+		return transport
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	if len(transports.transports) >= maxTransports {
+		for _, t := range transports.transports {
+			t.CloseIdleConnections()
+		}
+		transports.transports = make(map[string]*http.Transport)
+	}
+	transports.transports[key] = transport
+	return transport
+}
+
+// digest returns a key which identifies a list of values without retaining them
+func digest(values ...[]byte) string {
+	h := sha256.New()
+	for _, v := range values {
+		_, _ = fmt.Fprintf(h, "%d:", len(v))
+		_, _ = h.Write(v)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// secretResolver fetches secret values from a single namespace, each secret is only fetched once
+type secretResolver struct {
+	reader    client.Reader
+	namespace string
+	secrets   map[string]*corev1.Secret
+}
+
+func (s *secretResolver) value(ctx context.Context, sel *corev1.SecretKeySelector) ([]byte, error) {
+	secret, ok := s.secrets[sel.Name]
+	if !ok {
+		if s.reader == nil {
+			return nil, fmt.Errorf("unable to read secret '%s'", sel.Name)
+		}
+		secret = &corev1.Secret{}
+		if err := s.reader.Get(ctx, types.NamespacedName{Namespace: s.namespace, Name: sel.Name}, secret); err != nil {
+			return nil, err
+		}
+		if s.secrets == nil {
+			s.secrets = make(map[string]*corev1.Secret)
+		}
+		s.secrets[sel.Name] = secret
+	}
+
+	value, ok := secret.Data[sel.Key]
+	if !ok {
+		return nil, fmt.Errorf("secret '%s' does not contain key '%s'", sel.Name, sel.Key)
+	}
+	return value, nil
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/redskyops/redskyops-controller/internal/trial"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// resultsGracePeriod is how long after the trial run completes the results file is waited for
+const resultsGracePeriod = 30 * time.Second
+
+// PodLogReader is a reader which can also read the logs of a pod container, it is required to read the results file
+// reported by the trial run job
+type PodLogReader interface {
+	client.Reader
+	// PodLogs returns the log output of the named container
+	PodLogs(ctx context.Context, namespace, name, container string) ([]byte, error)
+}
+
+// WithPodLogs returns a reader which uses the supplied client to read pod logs
+func WithPodLogs(r client.Reader, pods corev1client.PodsGetter) PodLogReader {
+	return &podLogReader{Reader: r, pods: pods}
+}
+
+type podLogReader struct {
+	client.Reader
+	pods corev1client.PodsGetter
+}
+
+func (r *podLogReader) PodLogs(ctx context.Context, namespace, name, container string) ([]byte, error) {
+	return r.pods.Pods(namespace).GetLogs(name, &corev1.PodLogOptions{Container: container}).Context(ctx).DoRaw()
+}
+
+func captureJobMetric(ctx context.Context, r client.Reader, m *redskyv1beta1.Metric, t *redskyv1beta1.Trial, target runtime.Object) (value float64, stddev float64, err error) {
+	// Make sure we got a pod list
+	list, ok := target.(*corev1.PodList)
+	if !ok {
+		return 0, 0, fmt.Errorf("expected target to be a pod list")
+	}
+
+	// Prefer the results file, the termination messages are limited in size
+	if t.Spec.ResultsFile {
+		if value, stddev, err = captureResultsFile(ctx, r, m, t, list); err == nil {
+			return value, stddev, nil
+		} else if _, ok := err.(*CaptureError); ok {
+			return value, stddev, err
+		}
+	}
+
+	// Look for a terminated container whose termination message produces a value
+	err = fmt.Errorf("unable to find a termination message for '%s'", m.Name)
+	for i := range list.Items {
+		for _, cs := range list.Items[i].Status.ContainerStatuses {
+			if cs.State.Terminated == nil || cs.State.Terminated.Message == "" {
+				continue
+			}
+
+			if value, stddev, err = evaluateJSONResults(m, []byte(cs.State.Terminated.Message)); err != nil {
+				continue
+			}
+
+			return value, stddev, nil
+		}
+	}
+
+	return value, stddev, err
+}
+
+// captureResultsFile evaluates the results file reported by the trial run job pods, a capture error is returned if
+// the results container is expected to report the file soon
+func captureResultsFile(ctx context.Context, r client.Reader, m *redskyv1beta1.Metric, t *redskyv1beta1.Trial, list *corev1.PodList) (float64, float64, error) {
+	lr, ok := r.(PodLogReader)
+	if !ok {
+		return 0, 0, fmt.Errorf("unable to read the results file for '%s'", m.Name)
+	}
+
+	err := fmt.Errorf("unable to find a results file for '%s'", m.Name)
+	for i := range list.Items {
+		pod := &list.Items[i]
+		for _, cs := range pod.Status.ContainerStatuses {
+			if !trial.IsResultsContainer(cs.Name) {
+				continue
+			}
+
+			// Wait for the results container to report the file, unless it has been waiting too long
+			if cs.State.Terminated == nil {
+				if t.Status.CompletionTime != nil && time.Since(t.Status.CompletionTime.Time) < resultsGracePeriod {
+					return 0, 0, &CaptureError{Message: "waiting for the results file", RetryAfter: 2 * time.Second}
+				}
+				continue
+			}
+			if cs.State.Terminated.ExitCode != 0 {
+				continue
+			}
+
+			var data []byte
+			if data, err = lr.PodLogs(ctx, pod.Namespace, pod.Name, cs.Name); err != nil {
+				continue
+			}
+
+			value, stddev, e := evaluateJSONResults(m, data)
+			if e != nil {
+				err = e
+				continue
+			}
+			return value, stddev, nil
+		}
+	}
+
+	return 0, 0, err
+}
+
+// evaluateJSONResults evaluates the metric query against a JSON document
+func evaluateJSONResults(m *redskyv1beta1.Metric, results []byte) (float64, float64, error) {
+	// Unmarshal as generic JSON
+	var data interface{}
+	if err := json.Unmarshal(results, &data); err != nil {
+		return 0, 0, err
+	}
+
+	return evaluateJSONPath(m, data)
+}
@@ -20,9 +20,11 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
 	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
@@ -34,32 +36,54 @@ import (
 // TODO Combine it with the Prometheus clients?
 var httpClient = &http.Client{Timeout: 10 * time.Second}
 
-func captureJSONPathMetric(m *redskyv1beta1.Metric, target runtime.Object) (value float64, stddev float64, err error) {
-	var urls []string
+// DefaultRetryAfter is the delay before retrying a request which was rejected with a server error (or because too
+// many requests were made) when the response does not include a "Retry-After" header
+const DefaultRetryAfter = 5 * time.Second
 
-	if urls, err = toURL(target, m); err != nil {
-		return value, stddev, err
-	}
+// MaxRetryWindow is the amount of time after the trial run completes during which rejected requests are retried
+// without counting against the remaining attempts of the metric
+const MaxRetryWindow = 5 * time.Minute
 
-	for _, u := range urls {
-		if value, stddev, err = captureOneJSONPathMetric(u, m.Name, m.Query); err != nil {
-			continue
-		}
+func captureJSONPathMetric(ctx context.Context, rt http.RoundTripper, m *redskyv1beta1.Metric, target runtime.Object, completionTime time.Time) (float64, float64, error) {
+	c := httpClient
+	if rt != nil {
+		c = &http.Client{Timeout: httpClient.Timeout, Transport: rt}
+	}
 
-		return value, stddev, nil
+	urls, pods, err := toURL(target, m)
+	if err != nil {
+		return 0, 0, err
 	}
 
-	return value, stddev, err
+	return captureURLs(m, urls, pods, func(u string) (float64, float64, error) {
+		return captureOneJSONPathMetric(ctx, c, u, m, completionTime)
+	})
 }
 
-func captureOneJSONPathMetric(url, name, query string) (float64, float64, error) {
-	// Fetch the URL
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+func captureOneJSONPathMetric(ctx context.Context, c *http.Client, url string, m *redskyv1beta1.Metric, completionTime time.Time) (float64, float64, error) {
+	// Build the request
+	method := m.Method
+	if method == "" {
+		method = http.MethodGet
+		if m.Body != "" {
+			method = http.MethodPost
+		}
+	}
+	var body io.Reader
+	if m.Body != "" {
+		body = strings.NewReader(m.Body)
+	}
+	req, err := http.NewRequest(strings.ToUpper(method), url, body)
 	if err != nil {
 		return 0, 0, err
 	}
 	req.Header.Set("Accept", "application/json")
-	resp, err := httpClient.Do(req.WithContext(context.TODO()))
+	if m.Body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	// Fetch the URL
+	resp, err := c.Do(req.WithContext(ctx))
 	if err != nil {
 		return 0, 0, err
 	}
@@ -68,44 +92,111 @@ func captureOneJSONPathMetric(url, name, query string) (float64, float64, error)
 	}()
 
 	// Check the response status
-	if resp.StatusCode != http.StatusOK {
-		// TODO Should we not ignore this?
-		return 0, 0, nil
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, 0, &CaptureError{
+			Message:    fmt.Sprintf("unexpected response status for '%s': %s", m.Name, resp.Status),
+			Address:    url,
+			Query:      m.Query,
+			RetryAfter: retryDelay(resp, completionTime, time.Now()),
+		}
 	}
 
 	// Unmarshal as generic JSON
-	data := make(map[string]interface{})
+	var data interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
 		return 0, 0, err
 	}
 
+	return evaluateJSONPath(m, data)
+}
+
+// retryDelay returns the amount of time to wait before retrying a rejected request, zero is returned if the request
+// should not be retried or if the retry window has elapsed (in which case the failure counts as an attempt)
+func retryDelay(resp *http.Response, completionTime, now time.Time) time.Duration {
+	remaining := completionTime.Add(MaxRetryWindow).Sub(now)
+	if remaining <= 0 {
+		return 0
+	}
+
+	d := retryAfter(resp.Header.Get("Retry-After"), now)
+	if d == 0 && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500) {
+		d = DefaultRetryAfter
+	}
+	if d > remaining {
+		d = remaining
+	}
+	return d
+}
+
+// retryAfter returns the duration specified by a "Retry-After" header value, which may be either a number of seconds
+// or an HTTP date; zero is returned if the value is missing or invalid
+func retryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// evaluateJSONPath evaluates the JSON path expression of a metric against generic JSON data, multiple results are
+// reduced using the metric aggregation
+func evaluateJSONPath(m *redskyv1beta1.Metric, data interface{}) (float64, float64, error) {
 	// Evaluate the JSON path
-	jp := jsonpath.New(name)
-	if err := jp.Parse(query); err != nil {
+	jp := jsonpath.New(m.Name)
+	if err := jp.Parse(m.Query); err != nil {
 		return 0, 0, err
 	}
-	values, err := jp.FindResults(data)
+	results, err := jp.FindResults(data)
 	if err != nil {
 		return 0, 0, err
 	}
 
-	// Convert the result to a float
-	if len(values) == 1 && len(values[0]) == 1 {
-		v := reflect.ValueOf(values[0][0].Interface())
-		switch v.Kind() {
-		case reflect.Float64:
-			return v.Float(), 0, nil
-		case reflect.String:
-			v, err := strconv.ParseFloat(v.String(), 64)
+	// Convert the results to floats
+	var values []float64
+	for i := range results {
+		for j := range results[i] {
+			v, err := toFloat(results[i][j])
 			if err != nil {
 				return 0, 0, err
 			}
-			return v, 0, nil
-		default:
-			return 0, 0, fmt.Errorf("could not convert match to a floating point number")
+			values = append(values, v)
 		}
 	}
 
-	// If we made it this far we weren't able to extract the value
-	return 0, 0, fmt.Errorf("query '%s' did not match", query)
+	switch len(values) {
+	case 0:
+		// If we made it this far we weren't able to extract the value
+		return 0, 0, fmt.Errorf("query '%s' did not match", m.Query)
+	case 1:
+		return values[0], 0, nil
+	default:
+		return aggregate(m.Aggregation, values)
+	}
+}
+
+// toFloat converts a JSON path match to a floating point number
+func toFloat(v reflect.Value) (float64, error) {
+	v = reflect.ValueOf(v.Interface())
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), nil
+	case reflect.Bool:
+		if v.Bool() {
+			return 1, nil
+		}
+		return 0, nil
+	case reflect.String:
+		return strconv.ParseFloat(v.String(), 64)
+	default:
+		return 0, fmt.Errorf("could not convert match to a floating point number")
+	}
 }
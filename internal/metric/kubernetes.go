@@ -0,0 +1,123 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"fmt"
+	"strings"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// KubernetesTarget returns an empty list of the metrics API resources queried by the supplied metric, the returned
+// boolean indicates if the resources are namespaced
+func KubernetesTarget(m *redskyv1beta1.Metric) (*unstructured.UnstructuredList, bool, error) {
+	kind, _, err := splitKubernetesQuery(m.Query)
+	if err != nil {
+		return nil, false, err
+	}
+
+	list := &unstructured.UnstructuredList{}
+	list.SetAPIVersion("metrics.k8s.io/v1beta1")
+	switch kind {
+	case "pods":
+		list.SetKind("PodMetricsList")
+		return list, true, nil
+	case "nodes":
+		list.SetKind("NodeMetricsList")
+		return list, false, nil
+	default:
+		return nil, false, fmt.Errorf("unsupported metrics API resource: %s", kind)
+	}
+}
+
+func sampleKubernetesMetric(m *redskyv1beta1.Metric, target runtime.Object) (float64, error) {
+	// Make sure we got a metrics list
+	list, ok := target.(*unstructured.UnstructuredList)
+	if !ok {
+		return 0, fmt.Errorf("expected target to be a metrics list")
+	}
+
+	kind, name, err := splitKubernetesQuery(m.Query)
+	if err != nil {
+		return 0, err
+	}
+
+	// Sum the usage across all of the matched resources
+	var value float64
+	var found bool
+	for i := range list.Items {
+		var usages []interface{}
+		if kind == "pods" {
+			containers, _, _ := unstructured.NestedSlice(list.Items[i].Object, "containers")
+			for _, c := range containers {
+				if cm, ok := c.(map[string]interface{}); ok {
+					usages = append(usages, cm["usage"])
+				}
+			}
+		} else {
+			usages = append(usages, list.Items[i].Object["usage"])
+		}
+
+		for _, u := range usages {
+			um, ok := u.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			s, ok := um[name].(string)
+			if !ok {
+				continue
+			}
+			q, err := resource.ParseQuantity(s)
+			if err != nil {
+				return 0, err
+			}
+			value += quantityValue(corev1.ResourceName(name), q)
+			found = true
+		}
+	}
+
+	if !found {
+		return 0, fmt.Errorf("unable to find %s usage for '%s'", name, m.Name)
+	}
+	return value, nil
+}
+
+// splitKubernetesQuery returns the metrics API resource kind and resource name from a query
+func splitKubernetesQuery(query string) (string, string, error) {
+	query = strings.TrimSpace(query)
+	kind, name := "pods", query
+	if i := strings.Index(query, "/"); i >= 0 {
+		kind, name = query[0:i], query[i+1:]
+	}
+	if name == "" {
+		return "", "", fmt.Errorf("missing resource name in query: %s", query)
+	}
+	return kind, name, nil
+}
+
+// quantityValue returns the float value of a quantity, CPU is measured in cores
+func quantityValue(name corev1.ResourceName, q resource.Quantity) float64 {
+	if name == corev1.ResourceCPU {
+		return float64(q.MilliValue()) / 1000
+	}
+	return float64(q.Value())
+}
@@ -17,7 +17,9 @@ limitations under the License.
 package metric
 
 import (
+	"context"
 	"fmt"
+	"net"
 	"strconv"
 	"strings"
 	"time"
@@ -25,7 +27,9 @@ import (
 	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
 	"github.com/redskyops/redskyops-controller/internal/template"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // CaptureError describes problems that arise while capturing metric values
@@ -46,8 +50,17 @@ func (e *CaptureError) Error() string {
 	return e.Message
 }
 
-// CaptureMetric captures a point-in-time metric value and it's error (standard deviation)
-func CaptureMetric(metric *redskyv1beta1.Metric, trial *redskyv1beta1.Trial, target runtime.Object) (float64, float64, error) {
+// CaptureMetric captures a point-in-time metric value and it's error (standard deviation), the reader is used to
+// resolve secrets referenced by the metric from the namespace of the experiment
+func CaptureMetric(ctx context.Context, r client.Reader, metric *redskyv1beta1.Metric, trial *redskyv1beta1.Trial, target runtime.Object) (float64, float64, error) {
+	if IsRepeated(metric) {
+		return captureRepeatedMetric(ctx, r, metric, trial, target)
+	}
+	return captureMetric(ctx, r, metric, trial, target)
+}
+
+// captureMetric captures a single value of a metric
+func captureMetric(ctx context.Context, r client.Reader, metric *redskyv1beta1.Metric, trial *redskyv1beta1.Trial, target runtime.Object) (float64, float64, error) {
 	// Work on a copy so we can render the queries in place
 	metric = metric.DeepCopy()
 
@@ -64,27 +77,185 @@ func CaptureMetric(metric *redskyv1beta1.Metric, trial *redskyv1beta1.Trial, tar
 		value, err := strconv.ParseFloat(metric.Query, 64)
 		return value, 0, err
 	case redskyv1beta1.MetricPrometheus:
-		return capturePrometheusMetric(metric, target, trial.Status.CompletionTime.Time)
+		rt, err := newRoundTripper(ctx, r, trial.ExperimentNamespacedName().Namespace, metric.Authentication)
+		if err != nil {
+			return 0, 0, err
+		}
+		return capturePrometheusMetric(ctx, rt, metric, target, trial.Status.StartTime.Time, trial.Status.CompletionTime.Time)
 	case redskyv1beta1.MetricDatadog:
-		return captureDatadogMetric(metric.Scheme, metric.Query, trial.Status.StartTime.Time, trial.Status.CompletionTime.Time)
+		return captureDatadogMetric(ctx, r, trial.ExperimentNamespacedName().Namespace, metric, trial.Status.StartTime.Time, trial.Status.CompletionTime.Time)
 	case redskyv1beta1.MetricJSONPath:
-		return captureJSONPathMetric(metric, target)
+		rt, err := newRoundTripper(ctx, r, trial.ExperimentNamespacedName().Namespace, metric.Authentication)
+		if err != nil {
+			return 0, 0, err
+		}
+		if metric.Body, err = template.New().RenderMetricBody(metric, trial, target); err != nil {
+			return 0, 0, err
+		}
+		return captureJSONPathMetric(ctx, rt, metric, target, trial.Status.CompletionTime.Time)
+	case redskyv1beta1.MetricJob:
+		return captureJobMetric(ctx, r, metric, trial, target)
+	case redskyv1beta1.MetricKubernetes, redskyv1beta1.MetricCost:
+		return captureSampledMetric(metric, trial)
+	case redskyv1beta1.MetricDerived:
+		return captureDerivedMetric(metric, trial)
+	case redskyv1beta1.MetricSynthetic:
+		return captureSyntheticMetric(metric, trial, target)
 	default:
 		return 0, 0, fmt.Errorf("unknown metric type: %s", metric.Type)
 	}
 }
 
-func toURL(target runtime.Object, m *redskyv1beta1.Metric) ([]string, error) {
-	// Allow a specified URL to take precedence over a selector
-	if m.URL != "" {
-		return []string{m.URL}, nil
+// IsRepeated checks to see if a metric is sampled repeatedly over sub-windows of the trial run
+func IsRepeated(m *redskyv1beta1.Metric) bool {
+	return m.Samples > 1 && (m.Type == redskyv1beta1.MetricPrometheus || m.Type == redskyv1beta1.MetricDatadog)
+}
+
+// captureRepeatedMetric divides the trial run into equal sub-windows and captures the metric for each one, the queries
+// are rendered using the bounds of the sub-window so the "Range" of a query matches the sub-window. Sub-windows that
+// do not produce a value are ignored, the mean and standard deviation of the remaining samples are returned.
+func captureRepeatedMetric(ctx context.Context, r client.Reader, metric *redskyv1beta1.Metric, trial *redskyv1beta1.Trial, target runtime.Object) (float64, float64, error) {
+	if trial.Status.StartTime == nil || trial.Status.CompletionTime == nil {
+		return 0, 0, fmt.Errorf("unable to sample metric '%s' without trial start and completion times", metric.Name)
 	}
 
-	// Make sure we got a service list
-	// TODO We can probably handle a pod list by addressing it directly
-	list, ok := target.(*corev1.ServiceList)
-	if !ok {
-		return nil, fmt.Errorf("expected target to be a service list")
+	start, end := trial.Status.StartTime.Time, trial.Status.CompletionTime.Time
+	window := end.Sub(start) / time.Duration(metric.Samples)
+	if window <= 0 {
+		return 0, 0, fmt.Errorf("trial run is too short to sample metric '%s' %d times", metric.Name, metric.Samples)
+	}
+
+	var values []float64
+	var lastErr error
+	for i := 0; i < int(metric.Samples); i++ {
+		t := trial.DeepCopy()
+		t.Status.StartTime = &metav1.Time{Time: start.Add(time.Duration(i) * window)}
+		t.Status.CompletionTime = &metav1.Time{Time: t.Status.StartTime.Add(window)}
+
+		value, _, err := captureMetric(ctx, r, metric, t, target)
+		if err != nil {
+			// Retries and failures to reach the metric source apply to every sub-window
+			if cerr, ok := err.(*CaptureError); !ok || cerr.RetryAfter > 0 {
+				return 0, 0, err
+			}
+			lastErr = err
+			continue
+		}
+		values = append(values, value)
+	}
+
+	if len(values) == 0 {
+		return 0, 0, lastErr
+	}
+	return Mean(values), StdDev(values), nil
+}
+
+// DefaultTimeout is the amount of time allowed to capture a metric value when the metric does not specify a timeout
+const DefaultTimeout = 30 * time.Second
+
+// Timeout returns the maximum amount of time allowed to capture the metric value
+func Timeout(m *redskyv1beta1.Metric) time.Duration {
+	if m.Timeout != nil && m.Timeout.Duration > 0 {
+		return m.Timeout.Duration
+	}
+	return DefaultTimeout
+}
+
+// SampleInterval is the minimum amount of time between observations of sampled metrics
+const SampleInterval = 15 * time.Second
+
+// MaxSamples is the maximum number of observations recorded on the trial for a sampled metric
+const MaxSamples = 120
+
+// NextSampleTime returns the time of the next observation of a sampled metric, the interval grows with the length of
+// the trial run so the samples cover the whole run without exceeding the maximum
+func NextSampleTime(start, last time.Time) time.Time {
+	interval := last.Sub(start) / (MaxSamples / 2)
+	if interval < SampleInterval {
+		interval = SampleInterval
+	}
+	return last.Add(interval)
+}
+
+// AppendSample records an observation of a sampled metric; once the maximum number of samples is exceeded, every other
+// sample is discarded so the remaining samples are still evenly spread over the trial run
+func AppendSample(values []string, value float64) []string {
+	values = append(values, strconv.FormatFloat(value, 'f', -1, 64))
+	if len(values) <= MaxSamples {
+		return values
+	}
+
+	// Always keep the latest sample
+	kept := values[:0]
+	for i := (len(values) - 1) % 2; i < len(values); i += 2 {
+		kept = append(kept, values[i])
+	}
+	return kept
+}
+
+// HasSampledMetrics checks to see if any of the metrics must be observed while the trial run job is executing
+func HasSampledMetrics(metrics []redskyv1beta1.Metric) bool {
+	for i := range metrics {
+		if IsSampled(&metrics[i]) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSampled checks to see if a metric must be observed while the trial run job is executing
+func IsSampled(m *redskyv1beta1.Metric) bool {
+	return m.Type == redskyv1beta1.MetricKubernetes || m.Type == redskyv1beta1.MetricCost
+}
+
+// SampleMetric captures a single observation of a sampled metric, the reader is used to resolve objects referenced by
+// the metric from the namespace of the experiment
+func SampleMetric(ctx context.Context, r client.Reader, metric *redskyv1beta1.Metric, trial *redskyv1beta1.Trial, target runtime.Object) (float64, error) {
+	// Work on a copy so we can render the queries in place
+	metric = metric.DeepCopy()
+
+	// Execute the query as a template against the current state of the trial
+	var err error
+	if metric.Query, metric.ErrorQuery, err = template.New().RenderMetricQueries(metric, trial, nil); err != nil {
+		return 0, err
+	}
+
+	switch metric.Type {
+	case redskyv1beta1.MetricKubernetes:
+		return sampleKubernetesMetric(metric, target)
+	case redskyv1beta1.MetricCost:
+		return sampleCostMetric(ctx, r, trial.ExperimentNamespacedName().Namespace, metric, trial)
+	default:
+		return 0, fmt.Errorf("metric type cannot be sampled: %s", metric.Type)
+	}
+}
+
+// captureSampledMetric aggregates the samples collected while the trial run job was executing
+func captureSampledMetric(m *redskyv1beta1.Metric, trial *redskyv1beta1.Trial) (float64, float64, error) {
+	for _, s := range trial.Status.MetricSamples {
+		if s.Name != m.Name {
+			continue
+		}
+
+		values := make([]float64, 0, len(s.Values))
+		for _, v := range s.Values {
+			fv, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return 0, 0, err
+			}
+			values = append(values, fv)
+		}
+		return aggregate(m.Aggregation, values)
+	}
+
+	return 0, 0, fmt.Errorf("no samples were collected for '%s'", m.Name)
+}
+
+// toURL returns the URLs used to collect a metric, the returned boolean indicates the URLs address individual pods
+func toURL(target runtime.Object, m *redskyv1beta1.Metric) ([]string, bool, error) {
+	// Allow a specified URL to take precedence over a selector
+	if m.URL != "" {
+		return []string{m.URL}, false, nil
 	}
 
 	// Get URL components
@@ -92,41 +263,130 @@ func toURL(target runtime.Object, m *redskyv1beta1.Metric) ([]string, error) {
 	if scheme == "" {
 		scheme = "http"
 	} else if scheme != "http" && scheme != "https" {
-		return nil, fmt.Errorf("scheme must be 'http' or 'https': %s", scheme)
+		return nil, false, fmt.Errorf("scheme must be 'http' or 'https': %s", scheme)
 	}
 	path := "/" + strings.TrimLeft(m.Path, "/")
 
-	// Construct a URL for each service (use IP literals instead of host names to avoid DNS lookups)
+	// Construct a URL for each address (use IP literals instead of host names to avoid DNS lookups)
 	var urls []string
-	for _, s := range list.Items {
-		// When debugging in minikube, use `minikube tunnel` to expose the cluster IP on the host
-		// TODO How do we setup port forwarding in GCP?
-		host := s.Spec.ClusterIP
-		if host == "None" {
-			// Only actual clusterIPs are support
-			continue
+	var pods bool
+	switch list := target.(type) {
+	case *corev1.ServiceList:
+		for _, s := range list.Items {
+			// When debugging in minikube, use `minikube tunnel` to expose the cluster IP on the host
+			// TODO How do we setup port forwarding in GCP?
+			host := s.Spec.ClusterIP
+			if host == "None" {
+				// Headless services must be resolved to endpoints
+				continue
+			}
+
+			port := m.Port.IntValue()
+			if port < 1 {
+				portName := m.Port.StrVal
+				// TODO Default an empty portName to scheme?
+				for _, sp := range s.Spec.Ports {
+					if sp.Name == portName || len(s.Spec.Ports) == 1 {
+						port = int(sp.Port)
+					}
+				}
+			}
+
+			if port < 1 {
+				return nil, false, fmt.Errorf("metric '%s' has unresolvable port: %s", m.Name, m.Port.String())
+			}
+
+			urls = append(urls, fmt.Sprintf("%s://%s:%d%s", scheme, host, port, path))
 		}
-		port := m.Port.IntValue()
-
-		if port < 1 {
-			portName := m.Port.StrVal
-			// TODO Default an empty portName to scheme?
-			for _, sp := range s.Spec.Ports {
-				if sp.Name == portName || len(s.Spec.Ports) == 1 {
-					port = int(sp.Port)
+
+	case *corev1.EndpointsList:
+		pods = true
+		for _, e := range list.Items {
+			for _, ss := range e.Subsets {
+				port := m.Port.IntValue()
+				if port < 1 {
+					for _, ep := range ss.Ports {
+						if ep.Name == m.Port.StrVal || len(ss.Ports) == 1 {
+							port = int(ep.Port)
+						}
+					}
+				}
+
+				if port < 1 {
+					return nil, false, fmt.Errorf("metric '%s' has unresolvable port: %s", m.Name, m.Port.String())
+				}
+
+				// Only ready addresses are used
+				for _, addr := range ss.Addresses {
+					urls = append(urls, fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(addr.IP, strconv.Itoa(port)), path))
 				}
 			}
 		}
 
-		if port < 1 {
-			return nil, fmt.Errorf("metric '%s' has unresolvable port: %s", m.Name, m.Port.String())
+	case *corev1.PodList:
+		pods = true
+		for _, p := range list.Items {
+			if p.Status.Phase != corev1.PodRunning || p.Status.PodIP == "" {
+				continue
+			}
+
+			port := m.Port.IntValue()
+			if port < 1 {
+				var ports []corev1.ContainerPort
+				for _, c := range p.Spec.Containers {
+					ports = append(ports, c.Ports...)
+				}
+				for _, cp := range ports {
+					if cp.Name == m.Port.StrVal || len(ports) == 1 {
+						port = int(cp.ContainerPort)
+					}
+				}
+			}
+
+			if port < 1 {
+				return nil, false, fmt.Errorf("metric '%s' has unresolvable port: %s", m.Name, m.Port.String())
+			}
+
+			urls = append(urls, fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(p.Status.PodIP, strconv.Itoa(port)), path))
 		}
 
-		urls = append(urls, fmt.Sprintf("%s://%s:%d%s", scheme, host, port, path))
+	default:
+		return nil, false, fmt.Errorf("expected target to be a service, endpoints or pod list")
 	}
 
 	if len(urls) == 0 {
-		return nil, fmt.Errorf("unable to find metric targets for '%s'", m.Name)
+		return nil, false, fmt.Errorf("unable to find metric targets for '%s'", m.Name)
+	}
+	return urls, pods, nil
+}
+
+// captureURLs captures a metric value using a list of URLs. When the URLs address individual pods, a value is captured
+// from each URL and the results are aggregated; otherwise the value from the first URL to succeed is used.
+func captureURLs(m *redskyv1beta1.Metric, urls []string, pods bool, capture func(string) (float64, float64, error)) (value float64, stddev float64, err error) {
+	if !pods {
+		for _, u := range urls {
+			if value, stddev, err = capture(u); err != nil {
+				if cerr, ok := err.(*CaptureError); ok && cerr.RetryAfter > 0 {
+					// Do not try the remaining URLs if we were asked to wait
+					return value, stddev, err
+				}
+				continue
+			}
+
+			return value, stddev, nil
+		}
+
+		return value, stddev, err
+	}
+
+	// Every pod must produce a value, the error is the standard deviation of the values across pods
+	values := make([]float64, 0, len(urls))
+	for _, u := range urls {
+		v, _, err := capture(u)
+		if err != nil {
+			return 0, 0, err
+		}
+		values = append(values, v)
 	}
-	return urls, nil
+	return aggregate(m.PodAggregation, values)
 }
@@ -17,22 +17,32 @@ limitations under the License.
 package metric
 
 import (
+	"context"
 	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/redskyops/redskyops-controller/internal/trial"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 func TestCaptureMetric(t *testing.T) {
@@ -110,6 +120,28 @@ func TestCaptureMetric(t *testing.T) {
 			},
 			expected: 1,
 		},
+		{
+			desc: "prometheus vector",
+			metric: &redskyv1beta1.Metric{
+				Name:  "testMetric",
+				Query: "vector(2)",
+				Type:  redskyv1beta1.MetricPrometheus,
+				URL:   promHttpTest.URL,
+			},
+			expected: 2,
+		},
+		{
+			desc: "prometheus range",
+			metric: &redskyv1beta1.Metric{
+				Name:        "testMetric",
+				Query:       "up",
+				Type:        redskyv1beta1.MetricPrometheus,
+				URL:         promHttpTest.URL,
+				Step:        &metav1.Duration{Duration: time.Second},
+				Aggregation: "max",
+			},
+			expected: 5,
+		},
 		{
 			desc: "prometheus url",
 			metric: &redskyv1beta1.Metric{
@@ -146,6 +178,47 @@ func TestCaptureMetric(t *testing.T) {
 			},
 			expected: 5,
 		},
+		{
+			desc: "default job",
+			metric: &redskyv1beta1.Metric{
+				Name:  "testMetric",
+				Query: "{.throughput}",
+				Type:  redskyv1beta1.MetricJob,
+			},
+			obj: &corev1.PodList{
+				Items: []corev1.Pod{
+					{
+						Status: corev1.PodStatus{
+							ContainerStatuses: []corev1.ContainerStatus{
+								{
+									Name: "sidecar",
+									State: corev1.ContainerState{
+										Terminated: &corev1.ContainerStateTerminated{Message: "not json"},
+									},
+								},
+								{
+									Name: "load-test",
+									State: corev1.ContainerState{
+										Terminated: &corev1.ContainerStateTerminated{Message: `{"throughput": 1520.5}`},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			expected: 1520.5,
+		},
+		{
+			desc: "default kubernetes",
+			metric: &redskyv1beta1.Metric{
+				Name:        "testMetric",
+				Query:       "cpu",
+				Type:        redskyv1beta1.MetricKubernetes,
+				Aggregation: "max",
+			},
+			expected: 0.75,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -154,16 +227,1029 @@ func TestCaptureMetric(t *testing.T) {
 				Status: redskyv1beta1.TrialStatus{
 					StartTime:      &now,
 					CompletionTime: &later,
+					MetricSamples: []redskyv1beta1.MetricSamples{
+						{Name: "testMetric", Values: []string{"0.25", "0.75", "0.5"}},
+					},
 				},
 			}
 
-			duration, _, err := CaptureMetric(tc.metric, trial, tc.obj)
+			duration, _, err := CaptureMetric(context.TODO(), nil, tc.metric, trial, tc.obj)
 			assert.NoError(t, err)
 			assert.Equal(t, tc.expected, duration)
 		})
 	}
 }
 
+// fakeLogReader returns the same log output for every pod container
+type fakeLogReader struct {
+	client.Reader
+	logs string
+}
+
+func (r *fakeLogReader) PodLogs(ctx context.Context, namespace, name, container string) ([]byte, error) {
+	return []byte(r.logs), nil
+}
+
+func TestCaptureJobResultsFile(t *testing.T) {
+	now := metav1.Now()
+	earlier := metav1.NewTime(now.Add(-time.Minute))
+
+	// The results file is not limited to the size of a termination message
+	results := fmt.Sprintf(`{"throughput": 1520.5, "padding": "%s"}`, strings.Repeat("x", 5000))
+	pod := func(results corev1.ContainerState) *corev1.PodList {
+		return &corev1.PodList{Items: []corev1.Pod{{
+			ObjectMeta: metav1.ObjectMeta{Name: "trial-run", Namespace: "default"},
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{
+					{
+						Name:  "load-test",
+						State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: `{"throughput": 1000}`}},
+					},
+					{
+						Name:  trial.ResultsContainerName,
+						State: results,
+					},
+				},
+			},
+		}}}
+	}
+	reported := corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{}}
+	waiting := corev1.ContainerState{Running: &corev1.ContainerStateRunning{}}
+
+	testCases := []struct {
+		desc           string
+		reader         client.Reader
+		completionTime *metav1.Time
+		target         *corev1.PodList
+		expected       float64
+		retry          bool
+	}{
+		{
+			desc:           "results file",
+			reader:         &fakeLogReader{logs: results},
+			completionTime: &now,
+			target:         pod(reported),
+			expected:       1520.5,
+		},
+		{
+			desc:           "waiting for results",
+			reader:         &fakeLogReader{logs: results},
+			completionTime: &now,
+			target:         pod(waiting),
+			retry:          true,
+		},
+		{
+			desc:           "results not written",
+			reader:         &fakeLogReader{logs: results},
+			completionTime: &earlier,
+			target:         pod(waiting),
+			expected:       1000,
+		},
+		{
+			desc:           "invalid results",
+			reader:         &fakeLogReader{logs: "not json"},
+			completionTime: &now,
+			target:         pod(reported),
+			expected:       1000,
+		},
+		{
+			desc:           "no log reader",
+			completionTime: &now,
+			target:         pod(reported),
+			expected:       1000,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%q", tc.desc), func(t *testing.T) {
+			tr := &redskyv1beta1.Trial{}
+			tr.Spec.ResultsFile = true
+			tr.Status.StartTime = &earlier
+			tr.Status.CompletionTime = tc.completionTime
+			m := &redskyv1beta1.Metric{Name: "throughput", Type: redskyv1beta1.MetricJob, Query: "{.throughput}"}
+
+			value, _, err := CaptureMetric(context.TODO(), tc.reader, m, tr, tc.target)
+			if tc.retry {
+				if assert.IsType(t, &CaptureError{}, err) {
+					assert.NotZero(t, err.(*CaptureError).RetryAfter)
+				}
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.expected, value)
+			}
+		})
+	}
+}
+
+func TestCaptureMetricFromPods(t *testing.T) {
+	now := metav1.NewTime(time.Now().Add(time.Duration(-10) * time.Minute))
+	later := metav1.NewTime(now.Add(5 * time.Second))
+
+	var ports []int32
+	for _, v := range []int{2, 4} {
+		response := map[string]int{"value": v}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_ = json.NewEncoder(w).Encode(response)
+		}))
+		defer ts.Close()
+
+		u, err := url.Parse(ts.URL)
+		require.NoError(t, err)
+		port, err := strconv.ParseInt(u.Port(), 10, 32)
+		require.NoError(t, err)
+		ports = append(ports, int32(port))
+	}
+
+	pods := &corev1.PodList{}
+	endpoints := &corev1.EndpointsList{}
+	for _, port := range ports {
+		pods.Items = append(pods.Items, corev1.Pod{
+			Spec: corev1.PodSpec{
+				Containers: []corev1.Container{
+					{Ports: []corev1.ContainerPort{{Name: "metrics", ContainerPort: port}, {Name: "http", ContainerPort: 80}}},
+				},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning, PodIP: "127.0.0.1"},
+		})
+		endpoints.Items = append(endpoints.Items, corev1.Endpoints{
+			Subsets: []corev1.EndpointSubset{
+				{
+					Addresses: []corev1.EndpointAddress{{IP: "127.0.0.1"}},
+					Ports:     []corev1.EndpointPort{{Name: "metrics", Port: port}},
+				},
+			},
+		})
+	}
+	pods.Items = append(pods.Items, corev1.Pod{Status: corev1.PodStatus{Phase: corev1.PodPending}})
+
+	testCases := []struct {
+		desc        string
+		aggregation string
+		obj         runtime.Object
+		expected    float64
+	}{
+		{desc: "pods default", obj: pods, expected: 3},
+		{desc: "pods sum", aggregation: "sum", obj: pods, expected: 6},
+		{desc: "endpoints max", aggregation: "max", obj: endpoints, expected: 4},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%q", tc.desc), func(t *testing.T) {
+			trial := &redskyv1beta1.Trial{
+				Status: redskyv1beta1.TrialStatus{
+					StartTime:      &now,
+					CompletionTime: &later,
+				},
+			}
+			m := &redskyv1beta1.Metric{
+				Name:           "testMetric",
+				Query:          "{.value}",
+				Type:           redskyv1beta1.MetricJSONPath,
+				Port:           intstr.FromString("metrics"),
+				PodAggregation: tc.aggregation,
+			}
+
+			value, stddev, err := CaptureMetric(context.TODO(), nil, m, trial, tc.obj)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, value)
+			assert.InDelta(t, 1.414, stddev, 0.001)
+		})
+	}
+}
+
+func TestCaptureJSONPathMetric(t *testing.T) {
+	now := metav1.NewTime(time.Now().Add(time.Duration(-1) * time.Minute))
+	later := metav1.NewTime(now.Add(5 * time.Second))
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/search":
+			body := make(map[string]string)
+			if r.Method != http.MethodPost || json.NewDecoder(r.Body).Decode(&body) != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"trial": body["trial"], "hits": 12})
+		case "/values":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "latencies": []interface{}{1, 2, 3, 6}})
+		case "/busy":
+			w.Header().Set("Retry-After", "30")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/throttled":
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	trial := &redskyv1beta1.Trial{
+		ObjectMeta: metav1.ObjectMeta{Name: "trial-1", Namespace: "default"},
+		Status: redskyv1beta1.TrialStatus{
+			StartTime:      &now,
+			CompletionTime: &later,
+		},
+	}
+
+	testCases := []struct {
+		desc          string
+		metric        *redskyv1beta1.Metric
+		expected      float64
+		expectedError float64
+		retryAfter    time.Duration
+		fail          bool
+	}{
+		{
+			desc: "post body",
+			metric: &redskyv1beta1.Metric{
+				Query: "{.hits}",
+				Body:  `{"trial": "{{ .Trial.Name }}"}`,
+				URL:   ts.URL + "/search",
+			},
+			expected: 12,
+		},
+		{
+			desc: "boolean",
+			metric: &redskyv1beta1.Metric{
+				Query: "{.ok}",
+				URL:   ts.URL + "/values",
+			},
+			expected: 1,
+		},
+		{
+			desc: "multiple values",
+			metric: &redskyv1beta1.Metric{
+				Query:       "{.latencies[*]}",
+				URL:         ts.URL + "/values",
+				Aggregation: "max",
+			},
+			expected:      6,
+			expectedError: 2.160246899469287,
+		},
+		{
+			desc: "retry after",
+			metric: &redskyv1beta1.Metric{
+				Query: "{.hits}",
+				URL:   ts.URL + "/busy",
+			},
+			retryAfter: 30 * time.Second,
+			fail:       true,
+		},
+		{
+			desc: "server error",
+			metric: &redskyv1beta1.Metric{
+				Query: "{.hits}",
+				URL:   ts.URL + "/error",
+			},
+			retryAfter: DefaultRetryAfter,
+			fail:       true,
+		},
+		{
+			desc: "too many requests",
+			metric: &redskyv1beta1.Metric{
+				Query: "{.hits}",
+				URL:   ts.URL + "/throttled",
+			},
+			retryAfter: DefaultRetryAfter,
+			fail:       true,
+		},
+		{
+			desc: "not found",
+			metric: &redskyv1beta1.Metric{
+				Query: "{.hits}",
+				URL:   ts.URL + "/missing",
+			},
+			fail: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%q", tc.desc), func(t *testing.T) {
+			tc.metric.Name = "testMetric"
+			tc.metric.Type = redskyv1beta1.MetricJSONPath
+
+			value, stddev, err := CaptureMetric(context.TODO(), nil, tc.metric, trial, nil)
+			if tc.fail {
+				if assert.IsType(t, &CaptureError{}, err) {
+					assert.Equal(t, tc.retryAfter, err.(*CaptureError).RetryAfter)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, value)
+			assert.InDelta(t, tc.expectedError, stddev, 0.000001)
+		})
+	}
+
+	// Once the retry window has elapsed the failures count against the remaining attempts
+	trial.Status.CompletionTime = &metav1.Time{Time: time.Now().Add(-MaxRetryWindow)}
+	_, _, err := CaptureMetric(context.TODO(), nil, &redskyv1beta1.Metric{Name: "testMetric", Type: redskyv1beta1.MetricJSONPath, Query: "{.hits}", URL: ts.URL + "/busy"}, trial, nil)
+	if assert.IsType(t, &CaptureError{}, err) {
+		assert.Zero(t, err.(*CaptureError).RetryAfter)
+	}
+}
+
+func TestCaptureMetricAuthentication(t *testing.T) {
+	now := metav1.NewTime(time.Now().Add(time.Duration(-10) * time.Minute))
+	later := metav1.NewTime(now.Add(5 * time.Second))
+
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer t0ken" || r.Header.Get("X-Scope-OrgID") != "tenant" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]int{"value": 7})
+	}))
+	defer ts.Close()
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "metric-auth", Namespace: "default"},
+		Data: map[string][]byte{
+			"token":  []byte("t0ken"),
+			"ca.crt": ca,
+		},
+	}
+	reader := fake.NewFakeClientWithScheme(scheme.Scheme, secret)
+
+	trial := &redskyv1beta1.Trial{
+		ObjectMeta: metav1.ObjectMeta{Name: "trial", Namespace: "default"},
+		Status: redskyv1beta1.TrialStatus{
+			StartTime:      &now,
+			CompletionTime: &later,
+		},
+	}
+	m := &redskyv1beta1.Metric{
+		Name:  "testMetric",
+		Query: "{.value}",
+		Type:  redskyv1beta1.MetricJSONPath,
+		URL:   ts.URL,
+		Authentication: &redskyv1beta1.MetricAuthentication{
+			BearerToken: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "metric-auth"}, Key: "token"},
+			TLS: &redskyv1beta1.MetricTLSConfig{
+				CA:         &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "metric-auth"}, Key: "ca.crt"},
+				ServerName: "example.com",
+			},
+			Headers: []redskyv1beta1.MetricHeader{{Name: "X-Scope-OrgID", Value: "tenant"}},
+		},
+	}
+
+	value, _, err := CaptureMetric(context.TODO(), reader, m, trial, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(7), value)
+
+	m.Authentication.BearerToken.Key = "missing"
+	_, _, err = CaptureMetric(context.TODO(), reader, m, trial, nil)
+	assert.Error(t, err)
+}
+
+func TestCaptureDatadogMetric(t *testing.T) {
+	now := metav1.NewTime(time.Now().Add(time.Duration(-10) * time.Minute))
+	later := metav1.NewTime(now.Add(5 * time.Second))
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" || r.URL.Query().Get("api_key") != "secret-api-key" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Query().Get("query") {
+		case "single":
+			fmt.Fprint(w, `{"series":[{"pointlist":[[1,3],[2,null],[3,5],[4,4]]}]}`)
+		case "multi":
+			fmt.Fprint(w, `{"series":[{"pointlist":[[1,2],[2,4]]},{"pointlist":[[1,6],[2,8]]}]}`)
+		case "error":
+			fmt.Fprint(w, `{"series":[{"pointlist":[[1,0.5]]}]}`)
+		default:
+			fmt.Fprint(w, `{"series":[]}`)
+		}
+	}))
+	defer ts.Close()
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "datadog", Namespace: "default"},
+		Data:       map[string][]byte{"api-key": []byte("secret-api-key"), "app-key": []byte("secret-app-key")},
+	}
+	reader := fake.NewFakeClientWithScheme(scheme.Scheme, secret)
+
+	testCases := []struct {
+		desc          string
+		metric        *redskyv1beta1.Metric
+		expected      float64
+		expectedError float64
+		fail          bool
+	}{
+		{
+			desc:     "default aggregation",
+			metric:   &redskyv1beta1.Metric{Query: "single"},
+			expected: 4,
+		},
+		{
+			desc:     "min",
+			metric:   &redskyv1beta1.Metric{Query: "single", Aggregation: "min"},
+			expected: 3,
+		},
+		{
+			desc:     "legacy scheme",
+			metric:   &redskyv1beta1.Metric{Query: "single", Scheme: "last"},
+			expected: 4,
+		},
+		{
+			desc:     "percentile",
+			metric:   &redskyv1beta1.Metric{Query: "single", Aggregation: "p50"},
+			expected: 4,
+		},
+		{
+			desc:          "multiple series",
+			metric:        &redskyv1beta1.Metric{Query: "multi", Aggregation: "max"},
+			expected:      6,
+			expectedError: 2.828427,
+		},
+		{
+			desc:          "error query",
+			metric:        &redskyv1beta1.Metric{Query: "single", ErrorQuery: "error"},
+			expected:      4,
+			expectedError: 0.5,
+		},
+		{
+			desc:   "no data",
+			metric: &redskyv1beta1.Metric{Query: "empty"},
+			fail:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%q", tc.desc), func(t *testing.T) {
+			trial := &redskyv1beta1.Trial{
+				ObjectMeta: metav1.ObjectMeta{Name: "trial", Namespace: "default"},
+				Status: redskyv1beta1.TrialStatus{
+					StartTime:      &now,
+					CompletionTime: &later,
+				},
+			}
+			tc.metric.Name = "testMetric"
+			tc.metric.Type = redskyv1beta1.MetricDatadog
+			tc.metric.URL = ts.URL
+			tc.metric.Datadog = &redskyv1beta1.DatadogMetricConfig{
+				APIKey:         &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "datadog"}, Key: "api-key"},
+				ApplicationKey: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "datadog"}, Key: "app-key"},
+			}
+
+			value, stddev, err := CaptureMetric(context.TODO(), reader, tc.metric, trial, nil)
+			if tc.fail {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, value)
+			assert.InDelta(t, tc.expectedError, stddev, 0.000001)
+		})
+	}
+
+	t.Run("timeout", func(t *testing.T) {
+		slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(10 * time.Second):
+			}
+		}))
+		defer slow.Close()
+
+		trial := &redskyv1beta1.Trial{
+			ObjectMeta: metav1.ObjectMeta{Name: "trial", Namespace: "default"},
+			Status:     redskyv1beta1.TrialStatus{StartTime: &now, CompletionTime: &later},
+		}
+		m := &redskyv1beta1.Metric{Name: "testMetric", Type: redskyv1beta1.MetricDatadog, URL: slow.URL, Query: "single"}
+		m.Datadog = &redskyv1beta1.DatadogMetricConfig{
+			APIKey:         &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "datadog"}, Key: "api-key"},
+			ApplicationKey: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "datadog"}, Key: "app-key"},
+		}
+
+		ctx, cancel := context.WithTimeout(context.TODO(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, _, err := CaptureMetric(ctx, reader, m, trial, nil)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), context.DeadlineExceeded.Error())
+		}
+		assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+	})
+}
+
+func TestCaptureDerivedMetric(t *testing.T) {
+	trial := &redskyv1beta1.Trial{
+		Spec: redskyv1beta1.TrialSpec{
+			Values: []redskyv1beta1.Value{
+				{Name: "cost", Value: "3", Error: "0.3"},
+				{Name: "requests-per-second", Value: "1500", Error: "40"},
+				{Name: "pending", AttemptsRemaining: 3},
+				{Name: "a", Value: "5"},
+				{Name: "b", Value: "2"},
+			},
+		},
+	}
+
+	testCases := []struct {
+		desc          string
+		query         string
+		expected      float64
+		expectedError float64
+		fail          bool
+	}{
+		{desc: "constant", query: "(1 + 2) * -3", expected: -9},
+		{desc: "ratio", query: `cost / "requests-per-second" * 1000`, expected: 2, expectedError: 0.20698900},
+		{desc: "sum", query: "cost + cost", expected: 6, expectedError: 0.42426407},
+		{desc: "difference", query: `"requests-per-second" - 1e3`, expected: 500, expectedError: 40},
+		{desc: "subtraction without spaces", query: "a-b", expected: 3},
+		{desc: "unquoted hyphen", query: "requests-per-second", fail: true},
+		{desc: "unterminated quote", query: `"requests-per-second`, fail: true},
+		{desc: "pending", query: "pending * 2", fail: true},
+		{desc: "missing", query: "missing + 1", fail: true},
+		{desc: "divide by zero", query: "cost / (cost - 3)", fail: true},
+		{desc: "syntax", query: "cost +", fail: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%q", tc.desc), func(t *testing.T) {
+			m := &redskyv1beta1.Metric{
+				Name:  "testMetric",
+				Type:  redskyv1beta1.MetricDerived,
+				Query: tc.query,
+			}
+
+			value, stddev, err := CaptureMetric(context.TODO(), nil, m, trial, nil)
+			if tc.fail {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.InDelta(t, tc.expected, value, 0.000001)
+			assert.InDelta(t, tc.expectedError, stddev, 0.000001)
+		})
+	}
+
+	names, err := DerivedInputs(`cost / "requests-per-second" * 1000 + cost`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cost", "requests-per-second"}, names)
+	names, err = DerivedInputs("a-b")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, names)
+	assert.True(t, DependenciesCaptured(&redskyv1beta1.Metric{Type: redskyv1beta1.MetricDerived, Query: "cost * 2"}, trial))
+	assert.False(t, DependenciesCaptured(&redskyv1beta1.Metric{Type: redskyv1beta1.MetricDerived, Query: "cost * pending"}, trial))
+}
+
+func TestCaptureSyntheticMetric(t *testing.T) {
+	params := func(n int) *redskyv1beta1.Experiment {
+		exp := &redskyv1beta1.Experiment{}
+		for i := 0; i < n; i++ {
+			exp.Spec.Parameters = append(exp.Spec.Parameters, redskyv1beta1.Parameter{Name: fmt.Sprintf("x%d", i+1), Min: 0, Max: 100000})
+		}
+		return exp
+	}
+	assign := func(values ...int64) *redskyv1beta1.Trial {
+		trial := &redskyv1beta1.Trial{}
+		for i, v := range values {
+			trial.Spec.Assignments = append(trial.Spec.Assignments, redskyv1beta1.Assignment{Name: fmt.Sprintf("x%d", i+1), Value: v})
+		}
+		return trial
+	}
+
+	testCases := []struct {
+		desc       string
+		query      string
+		errorQuery string
+		exp        *redskyv1beta1.Experiment
+		trial      *redskyv1beta1.Trial
+		expected   float64
+		fail       bool
+	}{
+		{desc: "branin", query: "branin", exp: params(2), trial: assign(54280, 15167), expected: 0.397887},
+		{desc: "rosenbrock", query: "rosenbrock", exp: params(3), trial: assign(40000, 40000, 40000), expected: 0},
+		{desc: "hartmann3", query: "hartmann3", exp: params(3), trial: assign(11461, 55565, 85255), expected: -3.86278},
+		{desc: "hartmann6", query: "hartmann6", exp: params(6), trial: assign(20169, 15001, 47687, 27533, 31165, 65730), expected: -3.32237},
+		{desc: "noise", query: "branin", errorQuery: "0.000001", exp: params(2), trial: assign(54280, 15167), expected: 0.397887},
+		{desc: "wrong dimensions", query: "hartmann6", exp: params(2), trial: assign(1, 2), fail: true},
+		{desc: "missing assignment", query: "branin", exp: params(2), trial: assign(1), fail: true},
+		{desc: "unknown", query: "ackley", exp: params(2), trial: assign(1, 2), fail: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%q", tc.desc), func(t *testing.T) {
+			m := &redskyv1beta1.Metric{
+				Name:       "testMetric",
+				Type:       redskyv1beta1.MetricSynthetic,
+				Query:      tc.query,
+				ErrorQuery: tc.errorQuery,
+			}
+
+			value, _, err := CaptureMetric(context.TODO(), nil, m, tc.trial, tc.exp)
+			if tc.fail {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.InDelta(t, tc.expected, value, 0.0001)
+		})
+	}
+}
+
+func TestSampleCostMetric(t *testing.T) {
+	pricing := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "pricing", Namespace: "default"},
+		Data: map[string]string{"pricing.yaml": `
+nodePoolLabel: pool
+default:
+  cpu: 0.04
+  memory: 0.005
+nodePools:
+  gpu:
+    cpu: 0.05
+    nvidia.com/gpu: 0.9
+`},
+	}
+	nodes := []runtime.Object{
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"pool": "default-pool"}}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-2", Labels: map[string]string{"pool": "gpu"}}},
+	}
+	pod := func(name, node string, requests corev1.ResourceList) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "trial-ns", Labels: map[string]string{"app": "test"}},
+			Spec: corev1.PodSpec{
+				NodeName:   node,
+				Containers: []corev1.Container{{Resources: corev1.ResourceRequirements{Requests: requests}}},
+			},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning},
+		}
+	}
+	objs := append(nodes, pricing,
+		pod("a", "node-1", corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("500m"), corev1.ResourceMemory: resource.MustParse("2Gi")}),
+		pod("b", "node-2", corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2"), corev1.ResourceMemory: resource.MustParse("4Gi"), "nvidia.com/gpu": resource.MustParse("1")}),
+		pod("c", "", corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("8")}),
+	)
+	reader := fake.NewFakeClientWithScheme(scheme.Scheme, objs...)
+
+	trial := &redskyv1beta1.Trial{ObjectMeta: metav1.ObjectMeta{Name: "trial", Namespace: "trial-ns"}}
+	trial.Spec.ExperimentRef = &corev1.ObjectReference{Name: "experiment", Namespace: "default"}
+	m := &redskyv1beta1.Metric{
+		Name:     "cost",
+		Type:     redskyv1beta1.MetricCost,
+		Query:    "requests",
+		Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "test"}},
+		Pricing:  &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "pricing"}, Key: "pricing.yaml"},
+	}
+
+	// 0.5*0.04 + 2*0.005 + 2*0.05 + 4*0.005 + 1*0.9
+	value, err := SampleMetric(context.TODO(), reader, m, trial, nil)
+	assert.NoError(t, err)
+	assert.InDelta(t, 1.05, value, 0.000001)
+
+	m.Query = "bogus"
+	_, err = SampleMetric(context.TODO(), reader, m, trial, nil)
+	assert.Error(t, err)
+}
+
+func TestParsePricing(t *testing.T) {
+	testCases := []struct {
+		desc string
+		data string
+		fail bool
+	}{
+		{desc: "default", data: "default:\n  cpu: 0.03\n"},
+		{desc: "node pools", data: "nodePoolLabel: pool\nnodePools:\n  a:\n    memory: 0.004\n"},
+		{desc: "empty", data: "", fail: true},
+		{desc: "missing label", data: "nodePools:\n  a:\n    memory: 0.004\n", fail: true},
+		{desc: "negative", data: "default:\n  cpu: -1\n", fail: true},
+		{desc: "unknown field", data: "defaults:\n  cpu: 1\n", fail: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%q", tc.desc), func(t *testing.T) {
+			_, err := ParsePricing(tc.data)
+			if tc.fail {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCaptureRepeatedMetric(t *testing.T) {
+	promHttpTest := promHttpTestServer()
+	defer promHttpTest.Close()
+
+	start := metav1.NewTime(time.Unix(time.Now().Add(-10*time.Minute).Unix(), 0))
+	completion := metav1.NewTime(start.Add(40 * time.Second))
+	trial := &redskyv1beta1.Trial{}
+	trial.Status.StartTime = &start
+	trial.Status.CompletionTime = &completion
+
+	testCases := []struct {
+		desc     string
+		query    string
+		samples  int32
+		expected float64
+		stddev   float64
+		fail     bool
+	}{
+		{
+			desc:     "single sample",
+			query:    "time()",
+			expected: float64(completion.Unix()),
+		},
+		{
+			desc:     "sub-windows",
+			query:    "time()",
+			samples:  4,
+			expected: float64(start.Unix()) + 25,
+			stddev:   12.909944,
+		},
+		{
+			desc:    "no data",
+			query:   "absent(up)",
+			samples: 4,
+			fail:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%q", tc.desc), func(t *testing.T) {
+			m := &redskyv1beta1.Metric{
+				Name:    "testMetric",
+				Query:   tc.query,
+				Type:    redskyv1beta1.MetricPrometheus,
+				URL:     promHttpTest.URL,
+				Samples: tc.samples,
+			}
+			value, stddev, err := CaptureMetric(context.TODO(), nil, m, trial, &corev1.ServiceList{})
+			if tc.fail {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.InDelta(t, tc.expected, value, 0.001)
+				assert.InDelta(t, tc.stddev, stddev, 0.000001)
+			}
+		})
+	}
+}
+
+func TestEvaluatePrometheusQuery(t *testing.T) {
+	promHttpTest := promHttpTestServer()
+	defer promHttpTest.Close()
+
+	start := metav1.NewTime(time.Unix(time.Now().Add(-10*time.Minute).Unix(), 0))
+	trial := &redskyv1beta1.Trial{}
+	trial.Status.StartTime = &start
+
+	m := &redskyv1beta1.Metric{
+		Name:  "testMetric",
+		Query: "time()",
+		Type:  redskyv1beta1.MetricPrometheus,
+		URL:   promHttpTest.URL,
+	}
+	at := start.Add(30 * time.Second)
+	value, err := EvaluatePrometheusQuery(context.TODO(), nil, m, trial, &corev1.ServiceList{}, at)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(at.Unix()), value)
+	assert.Nil(t, trial.Status.CompletionTime)
+
+	m.Type = redskyv1beta1.MetricJSONPath
+	_, err = EvaluatePrometheusQuery(context.TODO(), nil, m, trial, &corev1.ServiceList{}, at)
+	assert.Error(t, err)
+}
+
+func TestCapturePrometheusErrorQuery(t *testing.T) {
+	promHttpTest := promHttpTestServer()
+	defer promHttpTest.Close()
+
+	start := metav1.NewTime(time.Unix(time.Now().Add(-10*time.Minute).Unix(), 0))
+	completion := metav1.NewTime(start.Add(40 * time.Second))
+	trial := &redskyv1beta1.Trial{}
+	trial.Status.StartTime = &start
+	trial.Status.CompletionTime = &completion
+
+	testCases := []struct {
+		desc       string
+		errorQuery string
+		stddev     float64
+		fail       bool
+	}{
+		{
+			desc:       "vector",
+			errorQuery: "vector(2)",
+			stddev:     2,
+		},
+		{
+			desc:       "not a number",
+			errorQuery: "NaN",
+		},
+		{
+			desc:       "multiple elements",
+			errorQuery: "up",
+			fail:       true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%q", tc.desc), func(t *testing.T) {
+			m := &redskyv1beta1.Metric{
+				Name:       "testMetric",
+				Query:      "time()",
+				ErrorQuery: tc.errorQuery,
+				Type:       redskyv1beta1.MetricPrometheus,
+				URL:        promHttpTest.URL,
+			}
+			value, stddev, err := CaptureMetric(context.TODO(), nil, m, trial, &corev1.ServiceList{})
+			if tc.fail {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, float64(completion.Unix()), value)
+				assert.Equal(t, tc.stddev, stddev)
+			}
+		})
+	}
+}
+
+func TestAppendSample(t *testing.T) {
+	var values []string
+	for i := 1; i <= MaxSamples; i++ {
+		values = AppendSample(values, float64(i))
+	}
+	assert.Len(t, values, MaxSamples)
+
+	values = AppendSample(values, MaxSamples+1)
+	assert.Len(t, values, MaxSamples/2+1)
+	assert.Equal(t, "1", values[0])
+	assert.Equal(t, "3", values[1])
+	assert.Equal(t, strconv.Itoa(MaxSamples+1), values[len(values)-1])
+}
+
+func TestNextSampleTime(t *testing.T) {
+	start := time.Now()
+	assert.Equal(t, start.Add(SampleInterval), NextSampleTime(start, start))
+	assert.Equal(t, start.Add(time.Minute+SampleInterval), NextSampleTime(start, start.Add(time.Minute)))
+
+	last := start.Add(2 * time.Hour)
+	assert.Equal(t, last.Add(2*time.Minute), NextSampleTime(start, last))
+}
+
+func TestTimeout(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		timeout  *metav1.Duration
+		expected time.Duration
+	}{
+		{desc: "default", expected: DefaultTimeout},
+		{desc: "explicit", timeout: &metav1.Duration{Duration: 5 * time.Second}, expected: 5 * time.Second},
+		{desc: "zero", timeout: &metav1.Duration{}, expected: DefaultTimeout},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%q", tc.desc), func(t *testing.T) {
+			assert.Equal(t, tc.expected, Timeout(&redskyv1beta1.Metric{Timeout: tc.timeout}))
+		})
+	}
+}
+
+func TestPrometheusAPICache(t *testing.T) {
+	a1, err := prometheusAPI("http://prometheus-a:9090", nil)
+	require.NoError(t, err)
+	a2, err := prometheusAPI("http://prometheus-a:9090", nil)
+	require.NoError(t, err)
+	b, err := prometheusAPI("http://prometheus-b:9090", nil)
+	require.NoError(t, err)
+	assert.True(t, a1 == a2, "expected cached client")
+	assert.False(t, a1 == b, "expected distinct client")
+
+	c1, err := prometheusAPI("http://prometheus-a:9090", http.DefaultTransport)
+	require.NoError(t, err)
+	assert.False(t, a1 == c1, "expected uncached custom client")
+
+	auth := &redskyv1beta1.MetricAuthentication{
+		Headers: []redskyv1beta1.MetricHeader{{Name: "X-Scope-OrgID", Value: "tenant-1"}},
+	}
+	rt1, err := newRoundTripper(context.TODO(), nil, "default", auth)
+	require.NoError(t, err)
+	rt2, err := newRoundTripper(context.TODO(), nil, "default", auth)
+	require.NoError(t, err)
+	d1, err := prometheusAPI("http://prometheus-a:9090", rt1)
+	require.NoError(t, err)
+	d2, err := prometheusAPI("http://prometheus-a:9090", rt2)
+	require.NoError(t, err)
+	assert.True(t, d1 == d2, "expected cached authenticated client")
+	assert.False(t, a1 == d1, "expected distinct authenticated client")
+
+	auth.Headers[0].Value = "tenant-2"
+	rt3, err := newRoundTripper(context.TODO(), nil, "default", auth)
+	require.NoError(t, err)
+	e, err := prometheusAPI("http://prometheus-a:9090", rt3)
+	require.NoError(t, err)
+	assert.False(t, d1 == e, "expected distinct authenticated client")
+}
+
+func TestCachedTransport(t *testing.T) {
+	auth := &redskyv1beta1.MetricAuthentication{
+		TLS: &redskyv1beta1.MetricTLSConfig{ServerName: "metrics.example.com"},
+	}
+	rt1, err := newRoundTripper(context.TODO(), nil, "default", auth)
+	require.NoError(t, err)
+	auth.Headers = []redskyv1beta1.MetricHeader{{Name: "X-Scope-OrgID", Value: "tenant-1"}}
+	rt2, err := newRoundTripper(context.TODO(), nil, "default", auth)
+	require.NoError(t, err)
+	assert.True(t, rt1.(*authRoundTripper).next == rt2.(*authRoundTripper).next, "expected shared transport")
+	assert.NotEqual(t, rt1.(*authRoundTripper).key, rt2.(*authRoundTripper).key)
+
+	auth.TLS.InsecureSkipVerify = true
+	rt3, err := newRoundTripper(context.TODO(), nil, "default", auth)
+	require.NoError(t, err)
+	assert.False(t, rt1.(*authRoundTripper).next == rt3.(*authRoundTripper).next, "expected distinct transport")
+}
+
+func TestAggregate(t *testing.T) {
+	values := []float64{4, 1, 3, 2}
+	testCases := []struct {
+		aggregation string
+		expected    float64
+	}{
+		{aggregation: "", expected: 2.5},
+		{aggregation: "avg", expected: 2.5},
+		{aggregation: "last", expected: 2},
+		{aggregation: "max", expected: 4},
+		{aggregation: "min", expected: 1},
+		{aggregation: "sum", expected: 10},
+		{aggregation: "p50", expected: 2.5},
+		{aggregation: "p100", expected: 4},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%q", tc.aggregation), func(t *testing.T) {
+			value, stddev, err := aggregate(tc.aggregation, values)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, value)
+			assert.InDelta(t, 1.291, stddev, 0.001)
+		})
+	}
+
+	_, _, err := aggregate("p101", values)
+	assert.Error(t, err)
+	_, _, err = aggregate("avg", nil)
+	assert.Error(t, err)
+}
+
+func TestSampleMetric(t *testing.T) {
+	podMetrics := &unstructured.UnstructuredList{
+		Items: []unstructured.Unstructured{
+			{Object: map[string]interface{}{
+				"containers": []interface{}{
+					map[string]interface{}{"name": "a", "usage": map[string]interface{}{"cpu": "250m", "memory": "64Mi"}},
+					map[string]interface{}{"name": "b", "usage": map[string]interface{}{"cpu": "500000000n", "memory": "1Mi"}},
+				},
+			}},
+		},
+	}
+	nodeMetrics := &unstructured.UnstructuredList{
+		Items: []unstructured.Unstructured{
+			{Object: map[string]interface{}{"usage": map[string]interface{}{"cpu": "2", "memory": "1Gi"}}},
+			{Object: map[string]interface{}{"usage": map[string]interface{}{"cpu": "1500m", "memory": "1Gi"}}},
+		},
+	}
+
+	testCases := []struct {
+		desc     string
+		query    string
+		obj      runtime.Object
+		expected float64
+	}{
+		{desc: "pod cpu", query: "cpu", obj: podMetrics, expected: 0.75},
+		{desc: "pod memory", query: "pods/memory", obj: podMetrics, expected: 65 * 1024 * 1024},
+		{desc: "node cpu", query: "nodes/cpu", obj: nodeMetrics, expected: 3.5},
+		{desc: "node memory", query: "nodes/memory", obj: nodeMetrics, expected: 2 * 1024 * 1024 * 1024},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%q", tc.desc), func(t *testing.T) {
+			m := &redskyv1beta1.Metric{
+				Name:  "testMetric",
+				Query: tc.query,
+				Type:  redskyv1beta1.MetricKubernetes,
+			}
+
+			target, _, err := KubernetesTarget(m)
+			require.NoError(t, err)
+			assert.Equal(t, "metrics.k8s.io/v1beta1", target.GetAPIVersion())
+
+			value, err := SampleMetric(context.TODO(), nil, m, &redskyv1beta1.Trial{}, tc.obj)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, value)
+		})
+	}
+}
+
 func jsonPathHttpTestServer() *httptest.Server {
 	response := map[string]int{"current_response_time_percentile_95": 5}
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -173,9 +1259,28 @@ func jsonPathHttpTestServer() *httptest.Server {
 }
 
 func promHttpTestServer() *httptest.Server {
-	resp := `{"status":"success","data":{"resultType":"scalar","result":[1595471900.283,"1"]}}`
+	scalar := `{"status":"success","data":{"resultType":"scalar","result":[1595471900.283,"1"]}}`
+	vector := `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1595471900.283,"2"]}]}}`
+	matrix := `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{},"values":[[1595471900,"1"],[1595471901,"5"],[1595471902,"3"]]}]}}`
 	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		fmt.Fprint(w, resp)
+		_ = r.ParseForm()
+		switch {
+		case r.URL.Path == "/api/v1/query_range":
+			fmt.Fprint(w, matrix)
+		case strings.HasPrefix(r.Form.Get("query"), "vector("):
+			fmt.Fprint(w, vector)
+		case r.Form.Get("query") == "time()":
+			ts, _ := time.Parse(time.RFC3339Nano, r.Form.Get("time"))
+			fmt.Fprintf(w, `{"status":"success","data":{"resultType":"scalar","result":[%[1]d,"%[1]d"]}}`, ts.Unix())
+		case r.Form.Get("query") == "up":
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"job":"a"},"value":[1595471900.283,"1"]},{"metric":{"job":"b"},"value":[1595471900.283,"1"]}]}}`)
+		case r.Form.Get("query") == "NaN":
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"scalar","result":[1595471900.283,"NaN"]}}`)
+		case r.Form.Get("query") == "absent(up)":
+			fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+		default:
+			fmt.Fprint(w, scalar)
+		}
 		return
 	}))
 }
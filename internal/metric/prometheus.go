@@ -20,45 +20,41 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	prom "github.com/prometheus/client_golang/api"
 	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
 	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/redskyops/redskyops-controller/internal/template"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
-func capturePrometheusMetric(m *redskyv1beta1.Metric, target runtime.Object, completionTime time.Time) (value float64, stddev float64, err error) {
-	var urls []string
-
-	if urls, err = toURL(target, m); err != nil {
-		return value, stddev, err
-	}
-
-	for _, u := range urls {
-		if value, stddev, err = captureOnePrometheusMetric(u, m.Query, m.ErrorQuery, completionTime); err != nil {
-			continue
-		}
-
-		return value, stddev, nil
+func capturePrometheusMetric(ctx context.Context, rt http.RoundTripper, m *redskyv1beta1.Metric, target runtime.Object, startTime, completionTime time.Time) (float64, float64, error) {
+	urls, pods, err := toURL(target, m)
+	if err != nil {
+		return 0, 0, err
 	}
 
-	return value, stddev, err
+	return captureURLs(m, urls, pods, func(u string) (float64, float64, error) {
+		return captureOnePrometheusMetric(ctx, rt, u, m, startTime, completionTime)
+	})
 }
 
-func captureOnePrometheusMetric(address, query, errorQuery string, completionTime time.Time) (float64, float64, error) {
+func captureOnePrometheusMetric(ctx context.Context, rt http.RoundTripper, address string, m *redskyv1beta1.Metric, startTime, completionTime time.Time) (float64, float64, error) {
 	// Get the Prometheus client based on the metric URL
-	// TODO Cache these by URL
-	c, err := prom.NewClient(prom.Config{Address: address})
+	promAPI, err := prometheusAPI(address, rt)
 	if err != nil {
 		return 0, 0, err
 	}
-	promAPI := promv1.NewAPI(c)
 
 	// Make sure Prometheus is ready
-	targets, err := promAPI.Targets(context.TODO())
+	targets, err := promAPI.Targets(ctx)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -75,41 +71,185 @@ func captureOnePrometheusMetric(address, query, errorQuery string, completionTim
 	}
 
 	// Execute query
-	v, _, err := promAPI.Query(context.TODO(), query, completionTime)
-	if err != nil {
-		return 0, 0, err
-	}
+	var result, errorResult float64
+	if m.Step != nil {
+		r := promv1.Range{Start: startTime, End: completionTime, Step: m.Step.Duration}
+		v, _, err := promAPI.QueryRange(ctx, m.Query, r)
+		if err != nil {
+			return 0, 0, err
+		}
 
-	// Only accept scalar results
-	if v.Type() != model.ValScalar {
-		return 0, 0, fmt.Errorf("expected scalar query result, got %s", v.Type())
-	}
+		// The standard deviation of the range is used as the error unless there is an explicit error query
+		result, errorResult, err = aggregateRange(v, m.Aggregation)
+		if err != nil {
+			return 0, 0, newPrometheusCaptureError(err, address, m.Query, completionTime)
+		}
+	} else {
+		v, _, err := promAPI.Query(ctx, m.Query, completionTime)
+		if err != nil {
+			return 0, 0, err
+		}
 
-	// Scalar result
-	result := float64(v.(*model.Scalar).Value)
-	if math.IsNaN(result) {
-		err := &CaptureError{Message: "metric data not available", Address: address, Query: query, CompletionTime: completionTime}
-		if strings.HasPrefix(query, "scalar(") {
-			err.Message += " (the scalar function may have received an input vector whose size is not 1)"
+		result, err = instantValue(v)
+		if err != nil {
+			return 0, 0, newPrometheusCaptureError(err, address, m.Query, completionTime)
 		}
-		return 0, 0, err
 	}
 
 	// Execute the error query (if configured)
-	var errorResult float64
-	if errorQuery != "" {
-		ev, _, err := promAPI.Query(context.TODO(), errorQuery, completionTime)
+	if m.ErrorQuery != "" {
+		ev, _, err := promAPI.Query(ctx, m.ErrorQuery, completionTime)
 		if err != nil {
 			return 0, 0, err
 		}
-		if ev.Type() != model.ValScalar {
-			return 0, 0, fmt.Errorf("expected scalar error query result, got %s", v.Type())
-		}
-		errorResult = float64(v.(*model.Scalar).Value)
-		if math.IsNaN(errorResult) {
+		errorResult, err = instantValue(ev)
+		if err == errNotAvailable {
 			errorResult = 0
+		} else if err != nil {
+			return 0, 0, newPrometheusCaptureError(err, address, m.ErrorQuery, completionTime)
 		}
 	}
 
 	return result, errorResult, nil
 }
+
+// EvaluatePrometheusQuery evaluates the query of a "prometheus" metric at the supplied time while the trial run job is
+// still executing; the query is rendered as if the trial completed at the evaluation time
+func EvaluatePrometheusQuery(ctx context.Context, r client.Reader, m *redskyv1beta1.Metric, trial *redskyv1beta1.Trial, target runtime.Object, at time.Time) (float64, error) {
+	if m.Type != redskyv1beta1.MetricPrometheus {
+		return 0, fmt.Errorf("metric type cannot be evaluated: %s", m.Type)
+	}
+
+	m = m.DeepCopy()
+	trial = trial.DeepCopy()
+	trial.Status.CompletionTime = &metav1.Time{Time: at}
+
+	var err error
+	if m.Query, m.ErrorQuery, err = template.New().RenderMetricQueries(m, trial, target); err != nil {
+		return 0, err
+	}
+
+	rt, err := newRoundTripper(ctx, r, trial.ExperimentNamespacedName().Namespace, m.Authentication)
+	if err != nil {
+		return 0, err
+	}
+
+	urls, pods, err := toURL(target, m)
+	if err != nil {
+		return 0, err
+	}
+
+	value, _, err := captureURLs(m, urls, pods, func(u string) (float64, float64, error) {
+		promAPI, err := prometheusAPI(u, rt)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		v, _, err := promAPI.Query(ctx, m.Query, at)
+		if err != nil {
+			return 0, 0, err
+		}
+
+		result, err := instantValue(v)
+		if err != nil {
+			return 0, 0, newPrometheusCaptureError(err, u, m.Query, at)
+		}
+		return result, 0, nil
+	})
+	return value, err
+}
+
+// maxPrometheusClients bounds the client cache, addresses of individual pods change with every trial
+const maxPrometheusClients = 100
+
+// prometheusClients caches Prometheus clients by URL and authentication configuration
+var prometheusClients = struct {
+	sync.Mutex
+	clients map[string]promv1.API
+}{clients: make(map[string]promv1.API)}
+
+// prometheusAPI returns a Prometheus API client for the supplied address; clients using a custom round tripper are only
+// cached if the round tripper was created from a metric authentication configuration
+func prometheusAPI(address string, rt http.RoundTripper) (promv1.API, error) {
+	key := address
+	if art, ok := rt.(*authRoundTripper); ok {
+		key = address + "#" + art.key
+	} else if rt != nil {
+		c, err := prom.NewClient(prom.Config{Address: address, RoundTripper: rt})
+		if err != nil {
+			return nil, err
+		}
+		return promv1.NewAPI(c), nil
+	}
+
+	prometheusClients.Lock()
+	defer prometheusClients.Unlock()
+	if promAPI, ok := prometheusClients.clients[key]; ok {
+		return promAPI, nil
+	}
+
+	c, err := prom.NewClient(prom.Config{Address: address, RoundTripper: rt})
+	if err != nil {
+		return nil, err
+	}
+	promAPI := promv1.NewAPI(c)
+	if len(prometheusClients.clients) >= maxPrometheusClients {
+		prometheusClients.clients = make(map[string]promv1.API)
+	}
+	prometheusClients.clients[key] = promAPI
+	return promAPI, nil
+}
+
+// instantValue returns the value of an instant query result, which must be a scalar or a single-element vector
+func instantValue(v model.Value) (float64, error) {
+	var result float64
+	switch vv := v.(type) {
+	case *model.Scalar:
+		result = float64(vv.Value)
+	case model.Vector:
+		if len(vv) != 1 {
+			return 0, fmt.Errorf("expected single-element vector query result, got %d elements", len(vv))
+		}
+		result = float64(vv[0].Value)
+	default:
+		return 0, fmt.Errorf("expected scalar or vector query result, got %s", v.Type())
+	}
+
+	if math.IsNaN(result) {
+		return 0, errNotAvailable
+	}
+	return result, nil
+}
+
+// aggregateRange reduces the values of a range query result, which must contain a single series
+func aggregateRange(v model.Value, aggregation string) (float64, float64, error) {
+	matrix, ok := v.(model.Matrix)
+	if !ok {
+		return 0, 0, fmt.Errorf("expected matrix range query result, got %s", v.Type())
+	}
+	if len(matrix) != 1 {
+		return 0, 0, fmt.Errorf("expected single series range query result, got %d series", len(matrix))
+	}
+
+	values := make([]float64, 0, len(matrix[0].Values))
+	for _, sp := range matrix[0].Values {
+		if !math.IsNaN(float64(sp.Value)) {
+			values = append(values, float64(sp.Value))
+		}
+	}
+	if len(values) == 0 {
+		return 0, 0, errNotAvailable
+	}
+
+	return aggregate(aggregation, values)
+}
+
+var errNotAvailable = fmt.Errorf("metric data not available")
+
+func newPrometheusCaptureError(err error, address, query string, completionTime time.Time) error {
+	cerr := &CaptureError{Message: err.Error(), Address: address, Query: query, CompletionTime: completionTime}
+	if err == errNotAvailable && strings.HasPrefix(query, "scalar(") {
+		cerr.Message += " (the scalar function may have received an input vector whose size is not 1)"
+	}
+	return cerr
+}
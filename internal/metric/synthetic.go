@@ -0,0 +1,167 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metric
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// benchmark is a well-known test function for optimization
+type benchmark struct {
+	// dimensions is the required number of inputs, zero means any number greater than one
+	dimensions int
+	// lower and upper are the bounds of the input domain
+	lower, upper float64
+	// f evaluates the function
+	f func(x []float64) float64
+}
+
+var benchmarks = map[string]benchmark{
+	"branin":     {dimensions: 2, f: branin},
+	"rosenbrock": {lower: -5, upper: 10, f: rosenbrock},
+	"hartmann3":  {dimensions: 3, lower: 0, upper: 1, f: hartmann3},
+	"hartmann6":  {dimensions: 6, lower: 0, upper: 1, f: hartmann6},
+}
+
+func captureSyntheticMetric(m *redskyv1beta1.Metric, trial *redskyv1beta1.Trial, target runtime.Object) (float64, float64, error) {
+	// Make sure we got an experiment
+	exp, ok := target.(*redskyv1beta1.Experiment)
+	if !ok {
+		return 0, 0, fmt.Errorf("expected target to be an experiment")
+	}
+
+	name := strings.ToLower(strings.TrimSpace(m.Query))
+	b, ok := benchmarks[name]
+	if !ok {
+		return 0, 0, fmt.Errorf("unknown benchmark function '%s' (expected: branin, rosenbrock, hartmann3, hartmann6)", name)
+	}
+
+	// Normalize the assignments to the unit hypercube using the parameter bounds
+	u := make([]float64, 0, len(exp.Spec.Parameters))
+	for _, p := range exp.Spec.Parameters {
+		var found bool
+		for _, a := range trial.Spec.Assignments {
+			if a.Name != p.Name {
+				continue
+			}
+			found = true
+			if p.Max > p.Min {
+				u = append(u, float64(a.Value-p.Min)/float64(p.Max-p.Min))
+			} else {
+				u = append(u, 0)
+			}
+		}
+		if !found {
+			return 0, 0, fmt.Errorf("missing assignment for parameter '%s'", p.Name)
+		}
+	}
+
+	if (b.dimensions > 0 && len(u) != b.dimensions) || len(u) < 2 {
+		return 0, 0, fmt.Errorf("benchmark function '%s' cannot be evaluated with %d parameters", name, len(u))
+	}
+
+	// Scale the normalized values to the domain of the function
+	x := make([]float64, len(u))
+	for i := range u {
+		if name == "branin" {
+			// Branin has a different domain for each dimension
+			x[i] = []float64{-5, 0}[i] + 15*u[i]
+			continue
+		}
+		x[i] = b.lower + (b.upper-b.lower)*u[i]
+	}
+	value := b.f(x)
+
+	// The error query is the standard deviation of the noise to add
+	var noise float64
+	if m.ErrorQuery != "" {
+		var err error
+		if noise, err = strconv.ParseFloat(strings.TrimSpace(m.ErrorQuery), 64); err != nil {
+			return 0, 0, err
+		}
+		value += rand.NormFloat64() * noise
+	}
+
+	return value, noise, nil
+}
+
+// branin is defined on x1 in [-5, 10] and x2 in [0, 15], the global minimum is 0.397887
+func branin(x []float64) float64 {
+	b := 5.1 / (4 * math.Pi * math.Pi)
+	c := 5 / math.Pi
+	t := 1 / (8 * math.Pi)
+	return math.Pow(x[1]-b*x[0]*x[0]+c*x[0]-6, 2) + 10*(1-t)*math.Cos(x[0]) + 10
+}
+
+// rosenbrock is usually evaluated on [-5, 10] in each dimension, the global minimum is 0 at (1, ..., 1)
+func rosenbrock(x []float64) float64 {
+	var sum float64
+	for i := 0; i < len(x)-1; i++ {
+		sum += 100*math.Pow(x[i+1]-x[i]*x[i], 2) + math.Pow(1-x[i], 2)
+	}
+	return sum
+}
+
+// hartmann3 is defined on [0, 1] in each dimension, the global minimum is -3.86278
+func hartmann3(x []float64) float64 {
+	return hartmann(x, [][]float64{
+		{3, 10, 30},
+		{0.1, 10, 35},
+		{3, 10, 30},
+		{0.1, 10, 35},
+	}, [][]float64{
+		{0.3689, 0.1170, 0.2673},
+		{0.4699, 0.4387, 0.7470},
+		{0.1091, 0.8732, 0.5547},
+		{0.0381, 0.5743, 0.8828},
+	})
+}
+
+// hartmann6 is defined on [0, 1] in each dimension, the global minimum is -3.32237
+func hartmann6(x []float64) float64 {
+	return hartmann(x, [][]float64{
+		{10, 3, 17, 3.5, 1.7, 8},
+		{0.05, 10, 17, 0.1, 8, 14},
+		{3, 3.5, 1.7, 10, 17, 8},
+		{17, 8, 0.05, 10, 0.1, 14},
+	}, [][]float64{
+		{0.1312, 0.1696, 0.5569, 0.0124, 0.8283, 0.5886},
+		{0.2329, 0.4135, 0.8307, 0.3736, 0.1004, 0.9991},
+		{0.2348, 0.1451, 0.3522, 0.2883, 0.3047, 0.6650},
+		{0.4047, 0.8828, 0.8732, 0.5743, 0.1091, 0.0381},
+	})
+}
+
+func hartmann(x []float64, a, p [][]float64) float64 {
+	alpha := []float64{1.0, 1.2, 3.0, 3.2}
+	var sum float64
+	for i := range alpha {
+		var inner float64
+		for j := range x {
+			inner += a[i][j] * math.Pow(x[j]-p[i][j], 2)
+		}
+		sum += alpha[i] * math.Exp(-inner)
+	}
+	return -sum
+}
@@ -265,7 +265,7 @@ func (r *ReadinessChecker) podFailed(ctx context.Context, obj *unstructured.Unst
 // listPods returns the pods "owned" by the supplied unstructured object
 func (r *ReadinessChecker) listPods(ctx context.Context, obj *unstructured.Unstructured) (*corev1.PodList, error) {
 	// Get the pod selector
-	sel, err := podSelector(obj)
+	sel, err := PodSelector(obj)
 	if err != nil {
 		return nil, err
 	}
@@ -278,9 +278,9 @@ func (r *ReadinessChecker) listPods(ctx context.Context, obj *unstructured.Unstr
 	return list, err
 }
 
-// podSelector returns the label selector for pods "owned" by the specified object; returns nil if the selector could
+// PodSelector returns the label selector for pods "owned" by the specified object; returns nil if the selector could
 // not be determined for the supplied object.
-func podSelector(obj *unstructured.Unstructured) (labels.Selector, error) {
+func PodSelector(obj *unstructured.Unstructured) (labels.Selector, error) {
 	// TODO Instead of a label selector would we ever want to return a generic client.ListOption; e.g. a field selector?
 	var ls *metav1.LabelSelector
 
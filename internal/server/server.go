@@ -23,9 +23,11 @@ import (
 	"strconv"
 
 	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/redskyops/redskyops-controller/internal/metric"
 	"github.com/redskyops/redskyops-controller/internal/trial"
 	redskyapi "github.com/redskyops/redskyops-controller/redskyapi/experiments/v1alpha1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 )
 
@@ -173,6 +175,8 @@ func FromClusterTrial(in *redskyv1beta1.Trial) *redskyapi.TrialValues {
 	for _, c := range in.Status.Conditions {
 		if c.Type == redskyv1beta1.TrialFailed && c.Status == corev1.ConditionTrue {
 			out.Failed = true
+			out.FailureReason = c.Reason
+			out.FailureMessage = c.Message
 		}
 	}
 
@@ -196,6 +200,81 @@ func FromClusterTrial(in *redskyv1beta1.Trial) *redskyapi.TrialValues {
 	return out
 }
 
+// StartTrialGroup marks a trial as the first replica in a group of trials that share the same suggestion
+func StartTrialGroup(t *redskyv1beta1.Trial) {
+	// The group is named after the first trial so we need to know the name before the trial is created
+	if t.Name == "" {
+		t.Name = t.GenerateName + rand.String(5)
+	}
+
+	if t.Labels == nil {
+		t.Labels = make(map[string]string)
+	}
+	t.Labels[redskyv1beta1.LabelTrialGroup] = t.Name
+	t.Labels[redskyv1beta1.LabelTrialReplica] = "0"
+}
+
+// ToClusterReplicaTrial copies the suggestion from the first trial in a group to a new replica trial
+func ToClusterReplicaTrial(t *redskyv1beta1.Trial, first *redskyv1beta1.Trial, replica int) {
+	group := first.Labels[redskyv1beta1.LabelTrialGroup]
+	t.Name = fmt.Sprintf("%s-%d", group, replica)
+	t.Labels[redskyv1beta1.LabelTrialGroup] = group
+	t.Labels[redskyv1beta1.LabelTrialReplica] = strconv.Itoa(replica)
+	t.GetAnnotations()[redskyv1beta1.AnnotationReportTrialURL] = first.GetAnnotations()[redskyv1beta1.AnnotationReportTrialURL]
+
+	t.Spec.Assignments = append([]redskyv1beta1.Assignment(nil), first.Spec.Assignments...)
+
+	trial.UpdateStatus(t)
+
+	controllerutil.AddFinalizer(t, Finalizer)
+}
+
+// FromClusterTrialGroup converts the cluster state of a group of replica trials to a single API state; the value of
+// each metric is the mean of the replica values and the error is the standard deviation of the replica values
+func FromClusterTrialGroup(in []*redskyv1beta1.Trial) *redskyapi.TrialValues {
+	out := &redskyapi.TrialValues{}
+
+	// The suggestion fails if any of the replicas fail
+	var names []string
+	values := make(map[string][]redskyapi.Value)
+	for _, t := range in {
+		tv := FromClusterTrial(t)
+		if tv.Failed {
+			return tv
+		}
+
+		for _, v := range tv.Values {
+			if _, ok := values[v.MetricName]; !ok {
+				names = append(names, v.MetricName)
+			}
+			values[v.MetricName] = append(values[v.MetricName], v)
+		}
+	}
+
+	for _, name := range names {
+		vs := values[name]
+
+		// With a single value there is no spread to measure, keep the reported error
+		if len(vs) == 1 {
+			out.Values = append(out.Values, vs[0])
+			continue
+		}
+
+		fs := make([]float64, len(vs))
+		for i := range vs {
+			fs[i] = vs[i].Value
+		}
+
+		out.Values = append(out.Values, redskyapi.Value{
+			MetricName: name,
+			Value:      metric.Mean(fs),
+			Error:      metric.StdDev(fs),
+		})
+	}
+
+	return out
+}
+
 // StopExperiment updates the experiment in the event that it should be paused or halted
 func StopExperiment(exp *redskyv1beta1.Experiment, err error) bool {
 	if rse, ok := err.(*redskyapi.Error); ok && rse.Type == redskyapi.ErrExperimentStopped {
@@ -416,6 +416,21 @@ func TestFromClusterTrial(t *testing.T) {
 				Failed: true,
 			},
 		},
+		{
+			desc: "failed with reason",
+			in: &redskyv1beta1.Trial{
+				Status: redskyv1beta1.TrialStatus{
+					Conditions: []redskyv1beta1.TrialCondition{
+						{Type: redskyv1beta1.TrialFailed, Status: corev1.ConditionTrue, Reason: "Infeasible", Message: "insufficient cpu"},
+					},
+				},
+			},
+			expectedOut: &redskyapi.TrialValues{
+				Failed:         true,
+				FailureReason:  "Infeasible",
+				FailureMessage: "insufficient cpu",
+			},
+		},
 		{
 			desc: "conditions not failed",
 			in: &redskyv1beta1.Trial{
@@ -449,6 +464,113 @@ func TestFromClusterTrial(t *testing.T) {
 	}
 }
 
+func TestToClusterReplicaTrial(t *testing.T) {
+	first := &redskyv1beta1.Trial{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "generate_name",
+			Annotations:  map[string]string{},
+		},
+	}
+	ToClusterTrial(first, &redskyapi.TrialAssignments{
+		TrialMeta:   redskyapi.TrialMeta{SelfURL: "some/path/1"},
+		Assignments: []redskyapi.Assignment{{ParameterName: "one", Value: json.Number("111")}},
+	})
+	StartTrialGroup(first)
+	assert.Equal(t, "generate_name001", first.Labels[redskyv1beta1.LabelTrialGroup])
+	assert.Equal(t, "0", first.Labels[redskyv1beta1.LabelTrialReplica])
+
+	replica := &redskyv1beta1.Trial{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "generate_name",
+			Labels:       map[string]string{},
+			Annotations:  map[string]string{},
+		},
+	}
+	ToClusterReplicaTrial(replica, first, 2)
+	assert.Equal(t, &redskyv1beta1.Trial{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:         "generate_name001-2",
+			GenerateName: "generate_name",
+			Labels: map[string]string{
+				redskyv1beta1.LabelTrialGroup:   "generate_name001",
+				redskyv1beta1.LabelTrialReplica: "2",
+			},
+			Annotations: map[string]string{
+				redskyv1beta1.AnnotationReportTrialURL: "some/path/1",
+			},
+			Finalizers: []string{
+				Finalizer,
+			},
+		},
+		Status: redskyv1beta1.TrialStatus{
+			Phase:       "Created",
+			Assignments: "one=111",
+		},
+		Spec: redskyv1beta1.TrialSpec{
+			Assignments: []redskyv1beta1.Assignment{
+				{Name: "one", Value: 111},
+			},
+		},
+	}, replica)
+}
+
+func TestFromClusterTrialGroup(t *testing.T) {
+	complete := []redskyv1beta1.TrialCondition{{Type: redskyv1beta1.TrialComplete, Status: corev1.ConditionTrue}}
+	failed := []redskyv1beta1.TrialCondition{{Type: redskyv1beta1.TrialFailed, Status: corev1.ConditionTrue}}
+	newTrial := func(conditions []redskyv1beta1.TrialCondition, values ...redskyv1beta1.Value) *redskyv1beta1.Trial {
+		return &redskyv1beta1.Trial{
+			Spec:   redskyv1beta1.TrialSpec{Values: values},
+			Status: redskyv1beta1.TrialStatus{Conditions: conditions},
+		}
+	}
+
+	cases := []struct {
+		desc        string
+		in          []*redskyv1beta1.Trial
+		expectedOut *redskyapi.TrialValues
+	}{
+		{
+			desc: "single replica",
+			in: []*redskyv1beta1.Trial{
+				newTrial(complete, redskyv1beta1.Value{Name: "one", Value: "1", Error: "0.5"}),
+			},
+			expectedOut: &redskyapi.TrialValues{
+				Values: []redskyapi.Value{{MetricName: "one", Value: 1, Error: 0.5}},
+			},
+		},
+		{
+			desc: "multiple replicas",
+			in: []*redskyv1beta1.Trial{
+				newTrial(complete, redskyv1beta1.Value{Name: "one", Value: "1"}, redskyv1beta1.Value{Name: "two", Value: "10"}),
+				newTrial(complete, redskyv1beta1.Value{Name: "one", Value: "2"}, redskyv1beta1.Value{Name: "two", Value: "10"}),
+				newTrial(complete, redskyv1beta1.Value{Name: "one", Value: "3"}, redskyv1beta1.Value{Name: "two", Value: "10"}),
+			},
+			expectedOut: &redskyapi.TrialValues{
+				Values: []redskyapi.Value{
+					{MetricName: "one", Value: 2, Error: 1},
+					{MetricName: "two", Value: 10, Error: 0},
+				},
+			},
+		},
+		{
+			desc: "failed replica",
+			in: []*redskyv1beta1.Trial{
+				newTrial(complete, redskyv1beta1.Value{Name: "one", Value: "1"}),
+				newTrial(failed),
+			},
+			expectedOut: &redskyapi.TrialValues{
+				Failed: true,
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			out := FromClusterTrialGroup(c.in)
+			assert.Equal(t, c.expectedOut, out)
+		})
+	}
+}
+
 func TestStopExperiment(t *testing.T) {
 	cases := []struct {
 		desc        string
@@ -122,39 +122,12 @@ func NewJob(t *redskyv1beta1.Trial, mode string) (*batchv1.Job, error) {
 		// For Helm installs, serialize a Konjure configuration
 		helmConfig := newHelmGeneratorConfig(&task)
 		if helmConfig != nil {
-			te := template.New()
-
 			// Helm Values
-			for _, hv := range task.HelmValues {
-				hgv := helmGeneratorValue{
-					Name:        hv.Name,
-					ForceString: hv.ForceString,
-				}
-
-				if hv.ValueFrom != nil {
-					// Evaluate the external value source
-					switch {
-					case hv.ValueFrom.ParameterRef != nil:
-						v, ok := t.GetAssignment(hv.ValueFrom.ParameterRef.Name)
-						if !ok {
-							return nil, fmt.Errorf("invalid parameter reference '%s' for Helm value '%s'", hv.ValueFrom.ParameterRef.Name, hv.Name)
-						}
-						hgv.Value = v
-
-					default:
-						return nil, fmt.Errorf("unknown source for Helm value '%s'", hv.Name)
-					}
-				} else {
-					// If there is no external source, evaluate the value field as a template
-					v, err := te.RenderHelmValue(&hv, t)
-					if err != nil {
-						return nil, err
-					}
-					hgv.Value = v
-				}
-
-				helmConfig.Values = append(helmConfig.Values, hgv)
+			values, err := helmValues(t, &task)
+			if err != nil {
+				return nil, err
 			}
+			helmConfig.Values = append(helmConfig.Values, values...)
 
 			// Helm Values From
 			for _, hvf := range task.HelmValuesFrom {
@@ -197,9 +170,51 @@ func NewJob(t *redskyv1beta1.Trial, mode string) (*batchv1.Job, error) {
 		job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes, *v)
 	}
 
+	// Mount the assignments file into each of the setup task containers
+	if err := trial.MountAssignments(t, &job.Spec.Template); err != nil {
+		return nil, err
+	}
+
 	return job, nil
 }
 
+// helmValues evaluates the Helm values of a setup task against the trial
+func helmValues(t *redskyv1beta1.Trial, task *redskyv1beta1.SetupTask) ([]helmGeneratorValue, error) {
+	te := template.New()
+	var values []helmGeneratorValue
+	for _, hv := range task.HelmValues {
+		hgv := helmGeneratorValue{
+			Name:        hv.Name,
+			ForceString: hv.ForceString,
+		}
+
+		if hv.ValueFrom != nil {
+			// Evaluate the external value source
+			switch {
+			case hv.ValueFrom.ParameterRef != nil:
+				v, ok := t.GetAssignment(hv.ValueFrom.ParameterRef.Name)
+				if !ok {
+					return nil, fmt.Errorf("invalid parameter reference '%s' for Helm value '%s'", hv.ValueFrom.ParameterRef.Name, hv.Name)
+				}
+				hgv.Value = v
+
+			default:
+				return nil, fmt.Errorf("unknown source for Helm value '%s'", hv.Name)
+			}
+		} else {
+			// If there is no external source, evaluate the value field as a template
+			v, err := te.RenderHelmValue(&hv, t)
+			if err != nil {
+				return nil, err
+			}
+			hgv.Value = v
+		}
+
+		values = append(values, hgv)
+	}
+	return values, nil
+}
+
 type helmGeneratorValue struct {
 	File        string      `json:"file,omitempty"`
 	Name        string      `json:"name,omitempty"`
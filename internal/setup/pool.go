@@ -0,0 +1,123 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package setup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// ReasonSetupReused is the reason used when a pooled namespace already has the setup tasks applied
+	ReasonSetupReused = "SetupReused"
+	// ReasonSetupRetained is the reason used when the setup tasks are kept in a pooled namespace for the next trial
+	ReasonSetupRetained = "SetupRetained"
+
+	// roleSetupRecycle is the trial role of the job which deletes the setup previously applied to a pooled namespace,
+	// it differs from the regular setup jobs so it does not change the setup conditions of the trial
+	roleSetupRecycle = "trialSetupRecycle"
+)
+
+// RecycleJobName returns the name of the job used to delete the setup previously applied to a pooled namespace
+func RecycleJobName(t *redskyv1beta1.Trial) string {
+	return fmt.Sprintf("%s-recycle", t.Name)
+}
+
+// NewRecycleJob returns a setup delete job which removes the setup previously applied to a pooled namespace before
+// the trial applies its own setup; nil is returned if none of the setup tasks delete anything
+func NewRecycleJob(t *redskyv1beta1.Trial) (*batchv1.Job, error) {
+	var needsDelete bool
+	for _, task := range t.Spec.SetupTasks {
+		needsDelete = needsDelete || !task.SkipDelete
+	}
+	if !needsDelete {
+		return nil, nil
+	}
+
+	job, err := NewJob(t, ModeDelete)
+	if err != nil {
+		return nil, err
+	}
+
+	job.Name = RecycleJobName(t)
+	job.Labels[redskyv1beta1.LabelTrialRole] = roleSetupRecycle
+	job.Spec.Template.Labels[redskyv1beta1.LabelTrialRole] = roleSetupRecycle
+	return job, nil
+}
+
+// IsReused checks to see if the trial is using the setup applied to a pooled namespace by a previous trial
+func IsReused(t *redskyv1beta1.Trial) bool {
+	for _, c := range t.Status.Conditions {
+		if c.Type == redskyv1beta1.TrialSetupCreated {
+			return c.Status == corev1.ConditionTrue && c.Reason == ReasonSetupReused
+		}
+	}
+	return false
+}
+
+// poolHashTask is the part of a setup task which determines the state of the pooled namespace
+type poolHashTask struct {
+	Task        redskyv1beta1.SetupTask    `json:"task"`
+	HelmValues  []helmGeneratorValue       `json:"helmValues,omitempty"`
+	Assignments []redskyv1beta1.Assignment `json:"assignments,omitempty"`
+}
+
+// Hash returns a value which only changes when the objects created by the setup tasks of the trial would change.
+// Helm tasks only depend on their evaluated values, other tasks depend on all of the assignments (which they receive
+// as environment variables).
+func Hash(t *redskyv1beta1.Trial) (string, error) {
+	data := struct {
+		Tasks              []poolHashTask  `json:"tasks"`
+		Volumes            []corev1.Volume `json:"volumes,omitempty"`
+		ServiceAccountName string          `json:"serviceAccountName,omitempty"`
+	}{
+		Volumes:            t.Spec.SetupVolumes,
+		ServiceAccountName: t.Spec.SetupServiceAccountName,
+	}
+
+	for i := range t.Spec.SetupTasks {
+		task := &t.Spec.SetupTasks[i]
+		if task.SkipCreate {
+			continue
+		}
+
+		ht := poolHashTask{Task: *task}
+		if task.HelmChart != "" {
+			values, err := helmValues(t, task)
+			if err != nil {
+				return "", err
+			}
+			ht.HelmValues = values
+		} else {
+			ht.Assignments = t.Spec.Assignments
+		}
+		data.Tasks = append(data.Tasks, ht)
+	}
+
+	b, err := json.Marshal(&data)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
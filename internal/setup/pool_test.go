@@ -0,0 +1,88 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package setup
+
+import (
+	"testing"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHash(t *testing.T) {
+	helmTrial := func(replicas, unused int64) *redskyv1beta1.Trial {
+		return &redskyv1beta1.Trial{Spec: redskyv1beta1.TrialSpec{
+			Assignments: []redskyv1beta1.Assignment{{Name: "replicas", Value: replicas}, {Name: "unused", Value: unused}},
+			SetupTasks: []redskyv1beta1.SetupTask{{
+				Name:      "app",
+				HelmChart: "example/app",
+				HelmValues: []redskyv1beta1.HelmValue{{
+					Name:      "replicaCount",
+					ValueFrom: &redskyv1beta1.HelmValueSource{ParameterRef: &redskyv1beta1.ParameterSelector{Name: "replicas"}},
+				}},
+			}},
+		}}
+	}
+
+	h1, err := Hash(helmTrial(1, 1))
+	assert.NoError(t, err)
+	h2, err := Hash(helmTrial(1, 2))
+	assert.NoError(t, err)
+	h3, err := Hash(helmTrial(2, 1))
+	assert.NoError(t, err)
+	assert.Equal(t, h1, h2, "unused assignments should not change the hash")
+	assert.NotEqual(t, h1, h3, "Helm values should change the hash")
+
+	// Tasks without a chart depend on every assignment
+	plain := helmTrial(1, 1)
+	plain.Spec.SetupTasks = []redskyv1beta1.SetupTask{{Name: "script", Image: "example/script"}}
+	h4, err := Hash(plain)
+	assert.NoError(t, err)
+	plain.Spec.Assignments[1].Value = 2
+	h5, err := Hash(plain)
+	assert.NoError(t, err)
+	assert.NotEqual(t, h4, h5)
+
+	// Invalid parameter references cannot be hashed
+	invalid := helmTrial(1, 1)
+	invalid.Spec.Assignments = nil
+	_, err = Hash(invalid)
+	assert.Error(t, err)
+}
+
+func TestNewRecycleJob(t *testing.T) {
+	tt := &redskyv1beta1.Trial{}
+	tt.Namespace = "pool-1"
+	tt.Name = "test-001"
+	tt.Spec.SetupTasks = []redskyv1beta1.SetupTask{{Name: "app", SkipDelete: true}}
+
+	job, err := NewRecycleJob(tt)
+	assert.NoError(t, err)
+	assert.Nil(t, job)
+
+	tt.Spec.SetupTasks = append(tt.Spec.SetupTasks, redskyv1beta1.SetupTask{Name: "db"})
+	job, err = NewRecycleJob(tt)
+	if assert.NoError(t, err) && assert.NotNil(t, job) {
+		assert.Equal(t, "test-001-recycle", job.Name)
+		assert.Equal(t, "pool-1", job.Namespace)
+		assert.Equal(t, "trialSetupRecycle", job.Labels[redskyv1beta1.LabelTrialRole])
+		assert.Equal(t, "trialSetupRecycle", job.Spec.Template.Labels[redskyv1beta1.LabelTrialRole])
+		if assert.Len(t, job.Spec.Template.Spec.Containers, 1) {
+			assert.Equal(t, []string{ModeDelete}, job.Spec.Template.Spec.Containers[0].Args)
+		}
+	}
+}
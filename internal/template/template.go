@@ -52,6 +52,10 @@ type MetricData struct {
 	Values map[string]int64
 	// List of pods from the trial namespace (only available for "pods" type metrics)
 	Pods *corev1.PodList
+	// The number of container restarts in the pods of the patched targets during the trial run
+	TargetRestarts int32
+	// The number of OOMKilled containers in the pods of the patched targets during the trial run
+	TargetOOMKills int32
 }
 
 func newPatchData(t *redskyv1beta1.Trial) *PatchData {
@@ -91,6 +95,9 @@ func newMetricData(t *redskyv1beta1.Trial, target runtime.Object) *MetricData {
 
 	d.Range = fmt.Sprintf("%.0fs", math.Max(d.CompletionTime.Sub(d.StartTime).Seconds(), 0))
 
+	d.TargetRestarts = t.Status.TargetRestarts
+	d.TargetOOMKills = t.Status.TargetOOMKills
+
 	return d
 }
 
@@ -120,6 +127,16 @@ func (e *Engine) RenderPatch(patch *redskyv1beta1.PatchTemplate, trial *redskyv1
 	return yaml.ToJSON(b.Bytes())
 }
 
+// RenderRunResource returns the JSON representation of the trial run resource (input can be a Go template that produces JSON)
+func (e *Engine) RenderRunResource(run *redskyv1beta1.TrialRunTemplate, trial *redskyv1beta1.Trial) ([]byte, error) {
+	data := newPatchData(trial)
+	b, err := e.render("run", string(run.Resource.Raw), data)
+	if err != nil {
+		return nil, err
+	}
+	return yaml.ToJSON(b.Bytes())
+}
+
 // RenderHelmValue returns a rendered string of the supplied Helm value
 func (e *Engine) RenderHelmValue(helmValue *redskyv1beta1.HelmValue, trial *redskyv1beta1.Trial) (string, error) {
 	data := newPatchData(trial)
@@ -144,6 +161,15 @@ func (e *Engine) RenderMetricQueries(metric *redskyv1beta1.Metric, trial *redsky
 	return b1.String(), b2.String(), nil
 }
 
+// RenderMetricBody returns the request body for a metric
+func (e *Engine) RenderMetricBody(metric *redskyv1beta1.Metric, trial *redskyv1beta1.Trial, target runtime.Object) (string, error) {
+	b, err := e.render(metric.Name, metric.Body, newMetricData(trial, target))
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
 func (e *Engine) render(name, text string, data interface{}) (*bytes.Buffer, error) {
 	tmpl, err := template.New(name).Funcs(e.FuncMap).Parse(text)
 	if err != nil {
@@ -0,0 +1,120 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trial
+
+import (
+	"fmt"
+	"time"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// CheckInterval is the approximate amount of time between checks of a trial while the trial run job is executing
+	CheckInterval = 15 * time.Second
+	// ReasonAborted is the reason used to fail trials which meet an abort condition
+	ReasonAborted = "Aborted"
+	// ReasonOOMKilled is the reason used to fail trials whose patched targets were OOMKilled; it matches the reason
+	// the kubelet uses when a container exceeds its memory limit
+	ReasonOOMKilled = "OOMKilled"
+	// ReasonContainerRestarted is the reason used to fail trials whose patched targets restarted
+	ReasonContainerRestarted = "ContainerRestarted"
+)
+
+// AbortThreshold returns the threshold of an abort condition
+func AbortThreshold(c *redskyv1beta1.AbortCondition) float64 {
+	if c.Threshold != nil {
+		return float64(c.Threshold.MilliValue()) / 1000
+	}
+	return 0
+}
+
+// AbortPodSelector returns the selector for the pods checked by an abort condition, the default selector excludes the
+// pods belonging to the trial setup and run jobs
+func AbortPodSelector(c *redskyv1beta1.AbortCondition) *metav1.LabelSelector {
+	if c.Selector != nil {
+		return c.Selector
+	}
+	return &metav1.LabelSelector{
+		MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: redskyv1beta1.LabelTrialRole, Operator: metav1.LabelSelectorOpDoesNotExist},
+		},
+	}
+}
+
+// CheckPodAbortCondition evaluates a "restarts" or "oomKilled" abort condition against the supplied pods, only container
+// terminations after the start of the trial run are considered. A message describing the problem is returned if the
+// trial should be aborted.
+func CheckPodAbortCondition(c *redskyv1beta1.AbortCondition, t *redskyv1beta1.Trial, pods *corev1.PodList) (string, bool) {
+	if t.Status.StartTime == nil {
+		return "", false
+	}
+	start := t.Status.StartTime
+
+	switch c.Type {
+	case redskyv1beta1.AbortRestarts:
+		if restarts, _ := ContainerTerminations(t, pods); float64(restarts) > AbortThreshold(c) {
+			return fmt.Sprintf("abort condition '%s': %d container restarts exceeds the threshold of %g", c.Name, restarts, AbortThreshold(c)), true
+		}
+
+	case redskyv1beta1.AbortOOMKilled:
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			for _, cs := range pod.Status.ContainerStatuses {
+				for _, term := range []*corev1.ContainerStateTerminated{cs.State.Terminated, cs.LastTerminationState.Terminated} {
+					if term != nil && term.Reason == ReasonOOMKilled && !term.FinishedAt.Before(start) {
+						return fmt.Sprintf("abort condition '%s': container '%s' of pod '%s' was OOMKilled", c.Name, cs.Name, pod.Name), true
+					}
+				}
+			}
+		}
+	}
+
+	return "", false
+}
+
+// ContainerTerminations returns the number of container restarts and OOMKilled terminations in the supplied pods since
+// the start of the trial run. Kubernetes only reports the most recent termination of a container, so for pods that
+// existed before the trial run, at most one restart per container can be attributed to the trial.
+func ContainerTerminations(t *redskyv1beta1.Trial, pods *corev1.PodList) (restarts int32, oomKills int32) {
+	if t.Status.StartTime == nil {
+		return 0, 0
+	}
+	start := t.Status.StartTime
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		for _, cs := range pod.Status.ContainerStatuses {
+			lt := cs.LastTerminationState.Terminated
+			if pod.CreationTimestamp.After(start.Time) {
+				// Every restart of a new pod happened during the trial run
+				restarts += cs.RestartCount
+			} else if lt != nil && !lt.FinishedAt.Before(start) {
+				restarts++
+			}
+
+			for _, term := range []*corev1.ContainerStateTerminated{cs.State.Terminated, lt} {
+				if term != nil && term.Reason == ReasonOOMKilled && !term.FinishedAt.Before(start) {
+					oomKills++
+				}
+			}
+		}
+	}
+	return restarts, oomKills
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trial
+
+import (
+	"testing"
+	"time"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckPodAbortCondition(t *testing.T) {
+	start := metav1.NewTime(time.Now().Add(-5 * time.Minute))
+	before := metav1.NewTime(start.Add(-time.Hour))
+	after := metav1.NewTime(start.Add(time.Minute))
+	two := resource.MustParse("2")
+
+	pod := func(created metav1.Time, statuses ...corev1.ContainerStatus) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", CreationTimestamp: created},
+			Status:     corev1.PodStatus{ContainerStatuses: statuses},
+		}
+	}
+	terminated := func(reason string, finishedAt metav1.Time) corev1.ContainerState {
+		return corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: reason, FinishedAt: finishedAt}}
+	}
+
+	cases := []struct {
+		desc      string
+		condition redskyv1beta1.AbortCondition
+		pods      []corev1.Pod
+		abort     bool
+	}{
+		{
+			desc:      "no restarts",
+			condition: redskyv1beta1.AbortCondition{Name: "restarts", Type: redskyv1beta1.AbortRestarts},
+			pods:      []corev1.Pod{pod(after, corev1.ContainerStatus{Name: "app"})},
+		},
+		{
+			desc:      "restarts of new pod",
+			condition: redskyv1beta1.AbortCondition{Name: "restarts", Type: redskyv1beta1.AbortRestarts},
+			pods:      []corev1.Pod{pod(after, corev1.ContainerStatus{Name: "app", RestartCount: 1})},
+			abort:     true,
+		},
+		{
+			desc:      "restarts below threshold",
+			condition: redskyv1beta1.AbortCondition{Name: "restarts", Type: redskyv1beta1.AbortRestarts, Threshold: &two},
+			pods:      []corev1.Pod{pod(after, corev1.ContainerStatus{Name: "app", RestartCount: 2})},
+		},
+		{
+			desc:      "restarts before trial run",
+			condition: redskyv1beta1.AbortCondition{Name: "restarts", Type: redskyv1beta1.AbortRestarts},
+			pods: []corev1.Pod{pod(before, corev1.ContainerStatus{
+				Name:                 "app",
+				RestartCount:         5,
+				LastTerminationState: terminated("Error", before),
+			})},
+		},
+		{
+			desc:      "restart of existing pod",
+			condition: redskyv1beta1.AbortCondition{Name: "restarts", Type: redskyv1beta1.AbortRestarts},
+			pods: []corev1.Pod{pod(before, corev1.ContainerStatus{
+				Name:                 "app",
+				RestartCount:         5,
+				LastTerminationState: terminated("Error", after),
+			})},
+			abort: true,
+		},
+		{
+			desc:      "oom killed",
+			condition: redskyv1beta1.AbortCondition{Name: "oom", Type: redskyv1beta1.AbortOOMKilled},
+			pods: []corev1.Pod{pod(before, corev1.ContainerStatus{
+				Name:                 "app",
+				LastTerminationState: terminated("OOMKilled", after),
+			})},
+			abort: true,
+		},
+		{
+			desc:      "oom killed before trial run",
+			condition: redskyv1beta1.AbortCondition{Name: "oom", Type: redskyv1beta1.AbortOOMKilled},
+			pods: []corev1.Pod{pod(before, corev1.ContainerStatus{
+				Name:                 "app",
+				LastTerminationState: terminated("OOMKilled", before),
+			})},
+		},
+		{
+			desc:      "terminated with error",
+			condition: redskyv1beta1.AbortCondition{Name: "oom", Type: redskyv1beta1.AbortOOMKilled},
+			pods: []corev1.Pod{pod(after, corev1.ContainerStatus{
+				Name:  "app",
+				State: terminated("Error", after),
+			})},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			tr := &redskyv1beta1.Trial{Status: redskyv1beta1.TrialStatus{StartTime: &start}}
+			message, abort := CheckPodAbortCondition(&c.condition, tr, &corev1.PodList{Items: c.pods})
+			assert.Equal(t, c.abort, abort)
+			if abort {
+				assert.Contains(t, message, c.condition.Name)
+			}
+		})
+	}
+}
+
+func TestContainerTerminations(t *testing.T) {
+	start := metav1.NewTime(time.Now().Add(-5 * time.Minute))
+	before := metav1.NewTime(start.Add(-time.Hour))
+	after := metav1.NewTime(start.Add(time.Minute))
+
+	pod := func(created metav1.Time, statuses ...corev1.ContainerStatus) corev1.Pod {
+		return corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "test", CreationTimestamp: created},
+			Status:     corev1.PodStatus{ContainerStatuses: statuses},
+		}
+	}
+	terminated := func(reason string, finishedAt metav1.Time) corev1.ContainerState {
+		return corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Reason: reason, FinishedAt: finishedAt}}
+	}
+
+	cases := []struct {
+		desc     string
+		started  bool
+		pods     []corev1.Pod
+		restarts int32
+		oomKills int32
+	}{
+		{
+			desc: "not started",
+			pods: []corev1.Pod{pod(after, corev1.ContainerStatus{Name: "app", RestartCount: 3})},
+		},
+		{
+			desc:     "new pod",
+			started:  true,
+			pods:     []corev1.Pod{pod(after, corev1.ContainerStatus{Name: "app", RestartCount: 3, LastTerminationState: terminated("OOMKilled", after)})},
+			restarts: 3,
+			oomKills: 1,
+		},
+		{
+			desc:    "existing pod restarted before",
+			started: true,
+			pods:    []corev1.Pod{pod(before, corev1.ContainerStatus{Name: "app", RestartCount: 3, LastTerminationState: terminated("OOMKilled", before)})},
+		},
+		{
+			desc:    "existing pod restarted during",
+			started: true,
+			pods: []corev1.Pod{pod(before,
+				corev1.ContainerStatus{Name: "app", RestartCount: 3, LastTerminationState: terminated("Error", after)},
+				corev1.ContainerStatus{Name: "sidecar", State: terminated("OOMKilled", after)},
+			)},
+			restarts: 1,
+			oomKills: 1,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			tt := &redskyv1beta1.Trial{}
+			if c.started {
+				tt.Status.StartTime = &start
+			}
+			restarts, oomKills := ContainerTerminations(tt, &corev1.PodList{Items: c.pods})
+			assert.Equal(t, c.restarts, restarts)
+			assert.Equal(t, c.oomKills, oomKills)
+		})
+	}
+}
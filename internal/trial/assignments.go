@@ -0,0 +1,182 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trial
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	// AnnotationAssignments is the pod annotation used to hold the rendered assignments file
+	AnnotationAssignments = "redskyops.dev/assignments"
+	// assignmentsVolumeName is the name of the volume used to mount the assignments file
+	assignmentsVolumeName = "redskyops-assignments"
+)
+
+// invalidEnvChars matches the characters which are replaced when normalizing environment variable names
+var invalidEnvChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// AppendAssignmentEnv appends an environment variable for each trial assignment
+func AppendAssignmentEnv(t *redskyv1beta1.Trial, env []corev1.EnvVar) []corev1.EnvVar {
+	d := t.Spec.AssignmentsDelivery
+	if d != nil && d.DisableEnv {
+		return env
+	}
+	for _, a := range t.Spec.Assignments {
+		env = append(env, corev1.EnvVar{Name: AssignmentEnvName(d, a.Name), Value: fmt.Sprintf("%d", a.Value)})
+	}
+	return env
+}
+
+// AssignmentEnvName returns the environment variable name used for the named assignment
+func AssignmentEnvName(d *redskyv1beta1.AssignmentsDelivery, name string) string {
+	var prefix, envCase string
+	var normalize bool
+	if d != nil {
+		prefix, envCase, normalize = d.EnvPrefix, d.EnvCase, d.NormalizeEnv
+	}
+
+	name = strings.ReplaceAll(prefix+name, ".", "_")
+	if normalize {
+		name = invalidEnvChars.ReplaceAllString(name, "_")
+	}
+	switch strings.ToLower(envCase) {
+	case "lower":
+		return strings.ToLower(name)
+	case "preserve":
+		return name
+	default:
+		return strings.ToUpper(name)
+	}
+}
+
+// RenderAssignments returns the assignments of the trial rendered using the specified format; for JSON and YAML,
+// dotted parameter names are expanded into nested objects
+func RenderAssignments(t *redskyv1beta1.Trial, format redskyv1beta1.AssignmentsFormat) ([]byte, error) {
+	switch format {
+	case redskyv1beta1.AssignmentsJSON, "":
+		return json.Marshal(nestAssignments(t.Spec.Assignments))
+	case redskyv1beta1.AssignmentsYAML:
+		return yaml.Marshal(nestAssignments(t.Spec.Assignments))
+	case redskyv1beta1.AssignmentsProperties:
+		names := make([]string, 0, len(t.Spec.Assignments))
+		values := make(map[string]int64, len(t.Spec.Assignments))
+		for _, a := range t.Spec.Assignments {
+			names = append(names, a.Name)
+			values[a.Name] = a.Value
+		}
+		sort.Strings(names)
+		var b strings.Builder
+		for _, n := range names {
+			_, _ = fmt.Fprintf(&b, "%s=%d\n", n, values[n])
+		}
+		return []byte(b.String()), nil
+	default:
+		return nil, fmt.Errorf("unknown assignments file format '%s'", format)
+	}
+}
+
+// MountAssignments adds the rendered assignments file to every container of the pod template; the file content is
+// stored in a pod annotation and exposed using the downward API so no additional objects are required
+func MountAssignments(t *redskyv1beta1.Trial, pod *corev1.PodTemplateSpec) error {
+	d := t.Spec.AssignmentsDelivery
+	if d == nil || d.FilePath == "" {
+		return nil
+	}
+	if !path.IsAbs(d.FilePath) {
+		return fmt.Errorf("assignments file path must be absolute: %s", d.FilePath)
+	}
+
+	b, err := RenderAssignments(t, d.FileFormat)
+	if err != nil {
+		return err
+	}
+
+	if pod.Annotations == nil {
+		pod.Annotations = make(map[string]string)
+	}
+	pod.Annotations[AnnotationAssignments] = string(b)
+
+	fileName := path.Base(d.FilePath)
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+		Name: assignmentsVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			DownwardAPI: &corev1.DownwardAPIVolumeSource{
+				Items: []corev1.DownwardAPIVolumeFile{
+					{
+						Path:     fileName,
+						FieldRef: &corev1.ObjectFieldSelector{FieldPath: fmt.Sprintf("metadata.annotations['%s']", AnnotationAssignments)},
+					},
+				},
+			},
+		},
+	})
+
+	// Use a sub-path so the mount does not hide the rest of the directory
+	for i := range pod.Spec.Containers {
+		c := &pod.Spec.Containers[i]
+		c.VolumeMounts = append(c.VolumeMounts, corev1.VolumeMount{
+			Name:      assignmentsVolumeName,
+			MountPath: d.FilePath,
+			SubPath:   fileName,
+			ReadOnly:  true,
+		})
+	}
+	return nil
+}
+
+// nestAssignments expands dotted assignment names into nested maps, names which conflict with an existing value are
+// left as is
+func nestAssignments(assignments []redskyv1beta1.Assignment) map[string]interface{} {
+	result := make(map[string]interface{}, len(assignments))
+	for _, a := range assignments {
+		if !nestAssignment(result, strings.Split(a.Name, "."), a.Value) {
+			result[a.Name] = a.Value
+		}
+	}
+	return result
+}
+
+func nestAssignment(m map[string]interface{}, keys []string, value int64) bool {
+	if len(keys) == 1 {
+		if _, ok := m[keys[0]]; ok {
+			return false
+		}
+		m[keys[0]] = value
+		return true
+	}
+
+	child, ok := m[keys[0]]
+	if !ok {
+		child = make(map[string]interface{})
+		m[keys[0]] = child
+	}
+	cm, ok := child.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	return nestAssignment(cm, keys[1:], value)
+}
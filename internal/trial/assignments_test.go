@@ -0,0 +1,135 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trial
+
+import (
+	"testing"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestAppendAssignmentEnv(t *testing.T) {
+	assignments := []redskyv1beta1.Assignment{{Name: "cpu", Value: 100}, {Name: "db.pool-size", Value: 5}}
+
+	cases := []struct {
+		desc     string
+		delivery *redskyv1beta1.AssignmentsDelivery
+		expected []corev1.EnvVar
+	}{
+		{
+			desc:     "default",
+			expected: []corev1.EnvVar{{Name: "CPU", Value: "100"}, {Name: "DB_POOL-SIZE", Value: "5"}},
+		},
+		{
+			desc:     "prefix",
+			delivery: &redskyv1beta1.AssignmentsDelivery{EnvPrefix: "app."},
+			expected: []corev1.EnvVar{{Name: "APP_CPU", Value: "100"}, {Name: "APP_DB_POOL-SIZE", Value: "5"}},
+		},
+		{
+			desc:     "normalize",
+			delivery: &redskyv1beta1.AssignmentsDelivery{EnvPrefix: "app/", NormalizeEnv: true},
+			expected: []corev1.EnvVar{{Name: "APP_CPU", Value: "100"}, {Name: "APP_DB_POOL_SIZE", Value: "5"}},
+		},
+		{
+			desc:     "lower",
+			delivery: &redskyv1beta1.AssignmentsDelivery{EnvPrefix: "RS_", EnvCase: "lower", NormalizeEnv: true},
+			expected: []corev1.EnvVar{{Name: "rs_cpu", Value: "100"}, {Name: "rs_db_pool_size", Value: "5"}},
+		},
+		{
+			desc:     "preserve",
+			delivery: &redskyv1beta1.AssignmentsDelivery{EnvPrefix: "RS_", EnvCase: "preserve"},
+			expected: []corev1.EnvVar{{Name: "RS_cpu", Value: "100"}, {Name: "RS_db_pool-size", Value: "5"}},
+		},
+		{
+			desc:     "disabled",
+			delivery: &redskyv1beta1.AssignmentsDelivery{DisableEnv: true},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			tt := &redskyv1beta1.Trial{Spec: redskyv1beta1.TrialSpec{Assignments: assignments, AssignmentsDelivery: c.delivery}}
+			assert.Equal(t, c.expected, AppendAssignmentEnv(tt, nil))
+		})
+	}
+}
+
+func TestRenderAssignments(t *testing.T) {
+	tt := &redskyv1beta1.Trial{Spec: redskyv1beta1.TrialSpec{Assignments: []redskyv1beta1.Assignment{
+		{Name: "db.pool", Value: 5},
+		{Name: "cpu", Value: 100},
+		{Name: "db.timeout", Value: 30},
+	}}}
+
+	cases := []struct {
+		desc     string
+		format   redskyv1beta1.AssignmentsFormat
+		expected string
+	}{
+		{desc: "default", expected: `{"cpu":100,"db":{"pool":5,"timeout":30}}`},
+		{desc: "json", format: redskyv1beta1.AssignmentsJSON, expected: `{"cpu":100,"db":{"pool":5,"timeout":30}}`},
+		{desc: "yaml", format: redskyv1beta1.AssignmentsYAML, expected: "cpu: 100\ndb:\n  pool: 5\n  timeout: 30\n"},
+		{desc: "properties", format: redskyv1beta1.AssignmentsProperties, expected: "cpu=100\ndb.pool=5\ndb.timeout=30\n"},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			b, err := RenderAssignments(tt, c.format)
+			if assert.NoError(t, err) {
+				assert.Equal(t, c.expected, string(b))
+			}
+		})
+	}
+
+	_, err := RenderAssignments(tt, "xml")
+	assert.Error(t, err)
+}
+
+func TestNestAssignments(t *testing.T) {
+	actual := nestAssignments([]redskyv1beta1.Assignment{{Name: "a", Value: 1}, {Name: "a.b", Value: 2}, {Name: "c.d", Value: 3}})
+	assert.Equal(t, map[string]interface{}{
+		"a":   int64(1),
+		"a.b": int64(2),
+		"c":   map[string]interface{}{"d": int64(3)},
+	}, actual)
+}
+
+func TestMountAssignments(t *testing.T) {
+	tt := &redskyv1beta1.Trial{Spec: redskyv1beta1.TrialSpec{
+		Assignments:         []redskyv1beta1.Assignment{{Name: "cpu", Value: 100}},
+		AssignmentsDelivery: &redskyv1beta1.AssignmentsDelivery{FilePath: "/etc/app/assignments.properties", FileFormat: redskyv1beta1.AssignmentsProperties},
+	}}
+	pod := &corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "a"}, {Name: "b"}}}}
+
+	if assert.NoError(t, MountAssignments(tt, pod)) {
+		assert.Equal(t, "cpu=100\n", pod.Annotations[AnnotationAssignments])
+		if assert.Len(t, pod.Spec.Volumes, 1) && assert.NotNil(t, pod.Spec.Volumes[0].DownwardAPI) {
+			assert.Equal(t, "assignments.properties", pod.Spec.Volumes[0].DownwardAPI.Items[0].Path)
+		}
+		for _, c := range pod.Spec.Containers {
+			assert.Equal(t, []corev1.VolumeMount{{
+				Name:      assignmentsVolumeName,
+				MountPath: "/etc/app/assignments.properties",
+				SubPath:   "assignments.properties",
+				ReadOnly:  true,
+			}}, c.VolumeMounts)
+		}
+	}
+
+	tt.Spec.AssignmentsDelivery.FilePath = "assignments.json"
+	assert.Error(t, MountAssignments(tt, &corev1.PodTemplateSpec{}))
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trial
+
+import (
+	"time"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// ReasonRunTimeout is the reason used to fail trials whose trial run exceeds the run timeout
+	ReasonRunTimeout = "RunTimeout"
+	// ReasonSetupTimeout is the reason used to fail trials whose setup job exceeds the setup timeout
+	ReasonSetupTimeout = "SetupTimeout"
+	// ReasonReadinessTimeout is the reason used to fail trials which do not become ready within the readiness timeout
+	ReasonReadinessTimeout = "ReadinessTimeout"
+	// ReasonCapacityTimeout is the reason used to fail trials which wait too long for enough capacity to apply their patches
+	ReasonCapacityTimeout = "CapacityTimeout"
+
+	// DefaultCapacityTimeout is the maximum amount of time to wait for capacity when the trial has no readiness timeout
+	DefaultCapacityTimeout = 15 * time.Minute
+	// MaxCapacityCheckInterval is the maximum amount of time between capacity checks of a waiting trial
+	MaxCapacityCheckInterval = 2 * time.Minute
+)
+
+// RunDeadline returns the time by which the trial run must complete, the deadline is measured from the time the
+// trial became ready (so a trial run job that never starts is also subject to the deadline)
+func RunDeadline(t *redskyv1beta1.Trial) *metav1.Time {
+	if t.Spec.RunTimeout == nil || t.Spec.RunTimeout.Duration <= 0 {
+		return nil
+	}
+
+	for _, c := range t.Status.Conditions {
+		if c.Type == redskyv1beta1.TrialReady && c.Status == corev1.ConditionTrue {
+			d := time.Duration(t.Spec.InitialDelaySeconds)*time.Second + t.Spec.RunTimeout.Duration
+			return &metav1.Time{Time: c.LastTransitionTime.Add(d)}
+		}
+	}
+	return nil
+}
+
+// ReadinessDeadline returns the time by which the trial must become ready, the deadline is measured from the time
+// the readiness checks were evaluated
+func ReadinessDeadline(t *redskyv1beta1.Trial) *metav1.Time {
+	if t.Spec.ReadinessTimeout == nil || t.Spec.ReadinessTimeout.Duration <= 0 {
+		return nil
+	}
+
+	for _, c := range t.Status.Conditions {
+		if c.Type == redskyv1beta1.TrialReady && c.Status == corev1.ConditionFalse {
+			return &metav1.Time{Time: c.LastTransitionTime.Add(t.Spec.ReadinessTimeout.Duration)}
+		}
+	}
+	return nil
+}
+
+// CapacityDeadline returns the time by which there must be enough capacity to apply the trial patches, the deadline
+// is measured from the time the patches were evaluated using the readiness timeout (or a default if it is not set)
+func CapacityDeadline(t *redskyv1beta1.Trial) *metav1.Time {
+	d := DefaultCapacityTimeout
+	if t.Spec.ReadinessTimeout != nil && t.Spec.ReadinessTimeout.Duration > 0 {
+		d = t.Spec.ReadinessTimeout.Duration
+	}
+
+	for _, c := range t.Status.Conditions {
+		if c.Type == redskyv1beta1.TrialPatched && c.Status == corev1.ConditionFalse {
+			return &metav1.Time{Time: c.LastTransitionTime.Add(d)}
+		}
+	}
+	return nil
+}
+
+// CapacityCheckInterval returns the amount of time until the capacity should be checked again, the interval grows
+// the longer the trial has been waiting to limit the number of times the whole cluster is inspected
+func CapacityCheckInterval(t *redskyv1beta1.Trial, now time.Time) time.Duration {
+	interval := CheckInterval
+	for _, c := range t.Status.Conditions {
+		if c.Type == redskyv1beta1.TrialPatched && c.Status == corev1.ConditionFalse {
+			if d := now.Sub(c.LastTransitionTime.Time) / 4; d > interval {
+				interval = d
+			}
+		}
+	}
+	if interval > MaxCapacityCheckInterval {
+		interval = MaxCapacityCheckInterval
+	}
+	return interval
+}
+
+// SetupDeadline returns the time by which a setup job created at the supplied time must complete
+func SetupDeadline(t *redskyv1beta1.Trial, created metav1.Time) *metav1.Time {
+	if t.Spec.SetupTimeout == nil || t.Spec.SetupTimeout.Duration <= 0 || created.IsZero() {
+		return nil
+	}
+	return &metav1.Time{Time: created.Add(t.Spec.SetupTimeout.Duration)}
+}
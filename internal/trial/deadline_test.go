@@ -0,0 +1,125 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trial
+
+import (
+	"testing"
+	"time"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRunDeadline(t *testing.T) {
+	ready := metav1.NewTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	readyCondition := func(status corev1.ConditionStatus) []redskyv1beta1.TrialCondition {
+		return []redskyv1beta1.TrialCondition{{Type: redskyv1beta1.TrialReady, Status: status, LastTransitionTime: ready}}
+	}
+
+	cases := []struct {
+		desc     string
+		trial    redskyv1beta1.Trial
+		deadline *metav1.Time
+	}{
+		{
+			desc: "no timeout",
+			trial: redskyv1beta1.Trial{
+				Status: redskyv1beta1.TrialStatus{Conditions: readyCondition(corev1.ConditionTrue)},
+			},
+		},
+		{
+			desc: "not ready",
+			trial: redskyv1beta1.Trial{
+				Spec:   redskyv1beta1.TrialSpec{RunTimeout: &metav1.Duration{Duration: 10 * time.Minute}},
+				Status: redskyv1beta1.TrialStatus{Conditions: readyCondition(corev1.ConditionFalse)},
+			},
+		},
+		{
+			desc: "ready",
+			trial: redskyv1beta1.Trial{
+				Spec:   redskyv1beta1.TrialSpec{RunTimeout: &metav1.Duration{Duration: 10 * time.Minute}},
+				Status: redskyv1beta1.TrialStatus{Conditions: readyCondition(corev1.ConditionTrue)},
+			},
+			deadline: &metav1.Time{Time: ready.Add(10 * time.Minute)},
+		},
+		{
+			desc: "initial delay",
+			trial: redskyv1beta1.Trial{
+				Spec:   redskyv1beta1.TrialSpec{RunTimeout: &metav1.Duration{Duration: 10 * time.Minute}, InitialDelaySeconds: 30},
+				Status: redskyv1beta1.TrialStatus{Conditions: readyCondition(corev1.ConditionTrue)},
+			},
+			deadline: &metav1.Time{Time: ready.Add(10*time.Minute + 30*time.Second)},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			assert.Equal(t, c.deadline, RunDeadline(&c.trial))
+		})
+	}
+}
+
+func TestReadinessDeadline(t *testing.T) {
+	checked := metav1.NewTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	tt := &redskyv1beta1.Trial{
+		Spec: redskyv1beta1.TrialSpec{ReadinessTimeout: &metav1.Duration{Duration: 5 * time.Minute}},
+	}
+	assert.Nil(t, ReadinessDeadline(tt))
+
+	tt.Status.Conditions = []redskyv1beta1.TrialCondition{{Type: redskyv1beta1.TrialReady, Status: corev1.ConditionFalse, LastTransitionTime: checked}}
+	assert.Equal(t, &metav1.Time{Time: checked.Add(5 * time.Minute)}, ReadinessDeadline(tt))
+
+	tt.Status.Conditions[0].Status = corev1.ConditionTrue
+	assert.Nil(t, ReadinessDeadline(tt))
+}
+
+func TestCapacityDeadline(t *testing.T) {
+	evaluated := metav1.NewTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	tt := &redskyv1beta1.Trial{}
+	assert.Nil(t, CapacityDeadline(tt))
+
+	tt.Status.Conditions = []redskyv1beta1.TrialCondition{{Type: redskyv1beta1.TrialPatched, Status: corev1.ConditionFalse, LastTransitionTime: evaluated}}
+	assert.Equal(t, &metav1.Time{Time: evaluated.Add(DefaultCapacityTimeout)}, CapacityDeadline(tt))
+
+	tt.Spec.ReadinessTimeout = &metav1.Duration{Duration: 5 * time.Minute}
+	assert.Equal(t, &metav1.Time{Time: evaluated.Add(5 * time.Minute)}, CapacityDeadline(tt))
+
+	tt.Status.Conditions[0].Status = corev1.ConditionTrue
+	assert.Nil(t, CapacityDeadline(tt))
+}
+
+func TestCapacityCheckInterval(t *testing.T) {
+	evaluated := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tt := &redskyv1beta1.Trial{}
+	assert.Equal(t, CheckInterval, CapacityCheckInterval(tt, evaluated))
+
+	tt.Status.Conditions = []redskyv1beta1.TrialCondition{{Type: redskyv1beta1.TrialPatched, Status: corev1.ConditionFalse, LastTransitionTime: metav1.NewTime(evaluated)}}
+	assert.Equal(t, CheckInterval, CapacityCheckInterval(tt, evaluated.Add(30*time.Second)))
+	assert.Equal(t, time.Minute, CapacityCheckInterval(tt, evaluated.Add(4*time.Minute)))
+	assert.Equal(t, MaxCapacityCheckInterval, CapacityCheckInterval(tt, evaluated.Add(time.Hour)))
+}
+
+func TestSetupDeadline(t *testing.T) {
+	created := metav1.NewTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	tt := &redskyv1beta1.Trial{}
+	assert.Nil(t, SetupDeadline(tt, created))
+
+	tt.Spec.SetupTimeout = &metav1.Duration{Duration: time.Minute}
+	assert.Nil(t, SetupDeadline(tt, metav1.Time{}))
+	assert.Equal(t, &metav1.Time{Time: created.Add(time.Minute)}, SetupDeadline(tt, created))
+}
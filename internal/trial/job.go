@@ -19,6 +19,7 @@ package trial
 import (
 	"encoding/json"
 	"fmt"
+	"path"
 	"time"
 
 	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
@@ -30,8 +31,17 @@ import (
 	"k8s.io/apimachinery/pkg/util/strategicpatch"
 )
 
+const (
+	// ResultsContainerName is the name of the trial run job container (and volume) used to report the results file
+	ResultsContainerName = "redskyops-results"
+	// ResultsFileEnv is the name of the environment variable holding the path of the results file
+	ResultsFileEnv = "REDSKY_RESULTS_FILE"
+	// ResultsFilePath is the path of the results file in the trial run job containers
+	ResultsFilePath = "/var/run/redskyops/results/results.json"
+)
+
 // NewJob returns a new trial run job from the template on the trial
-func NewJob(t *redskyv1beta1.Trial) *batchv1.Job {
+func NewJob(t *redskyv1beta1.Trial) (*batchv1.Job, error) {
 	job := &batchv1.Job{}
 
 	// Start with the job template
@@ -77,10 +87,20 @@ func NewJob(t *redskyv1beta1.Trial) *batchv1.Job {
 		addDefaultContainer(t, job)
 	}
 
+	// Share a results file if requested
+	if t.Spec.ResultsFile {
+		addResultsContainer(job)
+	}
+
+	// Mount the assignments file if requested
+	if err := MountAssignments(t, &job.Spec.Template); err != nil {
+		return nil, err
+	}
+
 	// Check to see if there is patch for the (as of yet, non-existent) trial job
 	job = patchSelf(t, job)
 
-	return job
+	return job, nil
 }
 
 func addDefaultContainer(t *redskyv1beta1.Trial, job *batchv1.Job) {
@@ -104,6 +124,38 @@ func addDefaultContainer(t *redskyv1beta1.Trial, job *batchv1.Job) {
 	}
 }
 
+// addResultsContainer mounts a shared directory for the results file into every container and adds a container that
+// waits for the file and reports it as its log output
+func addResultsContainer(job *batchv1.Job) {
+	spec := &job.Spec.Template.Spec
+	spec.Volumes = append(spec.Volumes, corev1.Volume{
+		Name:         ResultsContainerName,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+
+	mount := corev1.VolumeMount{Name: ResultsContainerName, MountPath: path.Dir(ResultsFilePath)}
+	env := corev1.EnvVar{Name: ResultsFileEnv, Value: ResultsFilePath}
+	for i := range spec.Containers {
+		spec.Containers[i].VolumeMounts = append(spec.Containers[i].VolumeMounts, mount)
+		spec.Containers[i].Env = append(spec.Containers[i].Env, env)
+	}
+
+	// The file may be written by any container, writers should rename a complete file into place
+	spec.Containers = append(spec.Containers, corev1.Container{
+		Name:         ResultsContainerName,
+		Image:        "busybox",
+		Command:      []string{"/bin/sh"},
+		Args:         []string{"-c", fmt.Sprintf(`until [ -f "$%[1]s" ]; do sleep 1; done; cat "$%[1]s"`, ResultsFileEnv)},
+		Env:          []corev1.EnvVar{env},
+		VolumeMounts: []corev1.VolumeMount{mount},
+	})
+}
+
+// IsResultsContainer checks to see if the container name is the name of the container reporting the results file
+func IsResultsContainer(name string) bool {
+	return name == ResultsContainerName
+}
+
 func patchSelf(t *redskyv1beta1.Trial, job *batchv1.Job) *batchv1.Job {
 	// Look for patch operations that match this trial and apply them
 	for i := range t.Status.PatchOperations {
@@ -0,0 +1,52 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trial
+
+import (
+	"testing"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestNewJobResultsFile(t *testing.T) {
+	tr := &redskyv1beta1.Trial{ObjectMeta: metav1.ObjectMeta{Name: "trial", Namespace: "default"}}
+	tr.Spec.JobTemplate = &batchv1beta1.JobTemplateSpec{}
+	tr.Spec.JobTemplate.Spec.Template.Spec.Containers = []corev1.Container{{Name: "load-test", Image: "loadimpact/k6"}}
+
+	job, err := NewJob(tr)
+	require.NoError(t, err)
+	assert.Len(t, job.Spec.Template.Spec.Containers, 1)
+	assert.Empty(t, job.Spec.Template.Spec.Volumes)
+
+	tr.Spec.ResultsFile = true
+	job, err = NewJob(tr)
+	require.NoError(t, err)
+	spec := job.Spec.Template.Spec
+	if assert.Len(t, spec.Containers, 2) {
+		assert.Contains(t, spec.Containers[0].Env, corev1.EnvVar{Name: ResultsFileEnv, Value: ResultsFilePath})
+		assert.Equal(t, spec.Containers[0].VolumeMounts, spec.Containers[1].VolumeMounts)
+		assert.True(t, IsResultsContainer(spec.Containers[1].Name))
+	}
+	if assert.Len(t, spec.Volumes, 1) {
+		assert.NotNil(t, spec.Volumes[0].EmptyDir)
+	}
+}
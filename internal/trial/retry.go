@@ -0,0 +1,160 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trial
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// ReasonSetupJobFailed is the reason used to fail trials whose setup job failed
+	ReasonSetupJobFailed = "SetupJobFailed"
+	// ReasonPatchFailed is the reason used to fail trials which could not be patched
+	ReasonPatchFailed = "PatchFailed"
+	// ReasonCloneFailed is the reason used when the application could not be cloned into the trial namespace
+	ReasonCloneFailed = "CloneFailed"
+	// ReasonMetricFailed is the reason used to fail trials whose metrics could not be collected
+	ReasonMetricFailed = "MetricFailed"
+	// ReasonInfeasible is the reason used to fail trials whose patched resource requests can never fit on the cluster
+	ReasonInfeasible = "Infeasible"
+	// ReasonInsufficientCapacity is the reason used while trials wait for enough capacity to apply their patches
+	ReasonInsufficientCapacity = "InsufficientCapacity"
+	// ReasonCapacityUnknown is the reason used when the cluster could not be inspected to check the capacity
+	ReasonCapacityUnknown = "CapacityUnknown"
+)
+
+// FailureClass returns the stage of the trial lifecycle at which the trial failed, or an empty string if the trial
+// has not failed
+func FailureClass(t *redskyv1beta1.Trial) redskyv1beta1.TrialFailureClass {
+	c := failedCondition(t)
+	if c == nil {
+		return ""
+	}
+
+	switch c.Reason {
+	case ReasonSetupJobFailed, ReasonSetupTimeout:
+		return redskyv1beta1.FailureSetup
+	case ReasonPatchFailed, ReasonCloneFailed, ReasonInfeasible, ReasonCapacityTimeout:
+		return redskyv1beta1.FailurePatch
+	case ReasonMetricFailed:
+		return redskyv1beta1.FailureMetric
+	}
+
+	// Any other failure before the trial became ready is a readiness failure
+	if !CheckCondition(&t.Status, redskyv1beta1.TrialReady, corev1.ConditionTrue) {
+		return redskyv1beta1.FailureReadiness
+	}
+	return redskyv1beta1.FailureJob
+}
+
+// ShouldRetry checks to see if the retry policy of a failed trial allows it to be retried. Failures which are a
+// direct consequence of the assignments (e.g. an abort condition or an OOMKill) are never retried.
+func ShouldRetry(t *redskyv1beta1.Trial) bool {
+	p := t.Spec.RetryPolicy
+	if p == nil || Retries(t) >= p.Limit {
+		return false
+	}
+
+	c := failedCondition(t)
+	if c == nil {
+		return false
+	}
+	switch c.Reason {
+	case ReasonAborted, ReasonOOMKilled, ReasonContainerRestarted, ReasonInfeasible:
+		return false
+	}
+
+	if len(p.RetryOn) == 0 {
+		return true
+	}
+	class := FailureClass(t)
+	for _, fc := range p.RetryOn {
+		if fc == class {
+			return true
+		}
+	}
+	return false
+}
+
+// Retries returns the number of times the assignments of the trial have already been retried
+func Retries(t *redskyv1beta1.Trial) int32 {
+	retries, _ := strconv.ParseInt(t.Annotations[redskyv1beta1.AnnotationTrialRetries], 10, 32)
+	return int32(retries)
+}
+
+// NewRetry returns a new trial that re-runs the assignments of the supplied failed trial
+func NewRetry(t *redskyv1beta1.Trial) *redskyv1beta1.Trial {
+	retries := Retries(t) + 1
+
+	r := &redskyv1beta1.Trial{}
+	r.Namespace = t.Namespace
+	r.Name = fmt.Sprintf("%s-retry-%d", retryBaseName(t), retries)
+	r.Labels = make(map[string]string, len(t.Labels))
+	for k, v := range t.Labels {
+		r.Labels[k] = v
+	}
+	r.Annotations = make(map[string]string, len(t.Annotations))
+	for k, v := range t.Annotations {
+		r.Annotations[k] = v
+	}
+	delete(r.Annotations, redskyv1beta1.AnnotationTrialRetriedBy)
+	r.Annotations[redskyv1beta1.AnnotationTrialRetries] = strconv.Itoa(int(retries))
+	t.Spec.DeepCopyInto(&r.Spec)
+
+	// Metric values are collected for each run, the retry must collect its own
+	r.Spec.Values = nil
+
+	// The status does not carry over, only the summary of the assignments
+	UpdateStatus(r)
+
+	return r
+}
+
+// RecordRetry updates a failed trial so it is not reported once it has been replaced by the supplied retry
+func RecordRetry(t, retry *redskyv1beta1.Trial) {
+	if t.Annotations == nil {
+		t.Annotations = make(map[string]string, 1)
+	}
+	t.Annotations[redskyv1beta1.AnnotationTrialRetriedBy] = retry.Name
+	delete(t.Annotations, redskyv1beta1.AnnotationReportTrialURL)
+}
+
+// retryBaseName returns the name of the trial that was originally retried
+func retryBaseName(t *redskyv1beta1.Trial) string {
+	if Retries(t) > 0 {
+		if i := strings.LastIndex(t.Name, "-retry-"); i > 0 {
+			return t.Name[:i]
+		}
+	}
+	return t.Name
+}
+
+// failedCondition returns the failed condition if the trial has failed
+func failedCondition(t *redskyv1beta1.Trial) *redskyv1beta1.TrialCondition {
+	for i := range t.Status.Conditions {
+		c := &t.Status.Conditions[i]
+		if c.Type == redskyv1beta1.TrialFailed && c.Status == corev1.ConditionTrue {
+			return c
+		}
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trial
+
+import (
+	"testing"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestFailureClass(t *testing.T) {
+	failed := func(reason string, ready corev1.ConditionStatus) *redskyv1beta1.Trial {
+		return &redskyv1beta1.Trial{Status: redskyv1beta1.TrialStatus{Conditions: []redskyv1beta1.TrialCondition{
+			{Type: redskyv1beta1.TrialReady, Status: ready},
+			{Type: redskyv1beta1.TrialFailed, Status: corev1.ConditionTrue, Reason: reason},
+		}}}
+	}
+
+	cases := []struct {
+		desc  string
+		trial *redskyv1beta1.Trial
+		class redskyv1beta1.TrialFailureClass
+	}{
+		{desc: "not failed", trial: &redskyv1beta1.Trial{}},
+		{desc: "setup", trial: failed(ReasonSetupJobFailed, corev1.ConditionUnknown), class: redskyv1beta1.FailureSetup},
+		{desc: "setup timeout", trial: failed(ReasonSetupTimeout, corev1.ConditionUnknown), class: redskyv1beta1.FailureSetup},
+		{desc: "patch", trial: failed(ReasonPatchFailed, corev1.ConditionUnknown), class: redskyv1beta1.FailurePatch},
+		{desc: "clone", trial: failed(ReasonCloneFailed, corev1.ConditionUnknown), class: redskyv1beta1.FailurePatch},
+		{desc: "infeasible", trial: failed(ReasonInfeasible, corev1.ConditionUnknown), class: redskyv1beta1.FailurePatch},
+		{desc: "capacity timeout", trial: failed(ReasonCapacityTimeout, corev1.ConditionUnknown), class: redskyv1beta1.FailurePatch},
+		{desc: "readiness", trial: failed("ReadinessFailureThreshold", corev1.ConditionFalse), class: redskyv1beta1.FailureReadiness},
+		{desc: "job", trial: failed("BackoffLimitExceeded", corev1.ConditionTrue), class: redskyv1beta1.FailureJob},
+		{desc: "metric", trial: failed(ReasonMetricFailed, corev1.ConditionTrue), class: redskyv1beta1.FailureMetric},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			assert.Equal(t, c.class, FailureClass(c.trial))
+		})
+	}
+}
+
+func TestShouldRetry(t *testing.T) {
+	failed := func(reason string, retries string, policy *redskyv1beta1.TrialRetryPolicy) *redskyv1beta1.Trial {
+		tt := &redskyv1beta1.Trial{Spec: redskyv1beta1.TrialSpec{RetryPolicy: policy}}
+		tt.Status.Conditions = []redskyv1beta1.TrialCondition{
+			{Type: redskyv1beta1.TrialReady, Status: corev1.ConditionTrue},
+			{Type: redskyv1beta1.TrialFailed, Status: corev1.ConditionTrue, Reason: reason},
+		}
+		if retries != "" {
+			tt.Annotations = map[string]string{redskyv1beta1.AnnotationTrialRetries: retries}
+		}
+		return tt
+	}
+	twice := &redskyv1beta1.TrialRetryPolicy{Limit: 2}
+	jobOnly := &redskyv1beta1.TrialRetryPolicy{Limit: 2, RetryOn: []redskyv1beta1.TrialFailureClass{redskyv1beta1.FailureJob}}
+
+	cases := []struct {
+		desc  string
+		trial *redskyv1beta1.Trial
+		retry bool
+	}{
+		{desc: "no policy", trial: failed("Evicted", "", nil)},
+		{desc: "not failed", trial: &redskyv1beta1.Trial{Spec: redskyv1beta1.TrialSpec{RetryPolicy: twice}}},
+		{desc: "first failure", trial: failed("Evicted", "", twice), retry: true},
+		{desc: "second failure", trial: failed("Evicted", "1", twice), retry: true},
+		{desc: "limit reached", trial: failed("Evicted", "2", twice)},
+		{desc: "aborted", trial: failed(ReasonAborted, "", twice)},
+		{desc: "oom killed", trial: failed(ReasonOOMKilled, "", twice)},
+		{desc: "infeasible", trial: failed(ReasonInfeasible, "", twice)},
+		{desc: "matching class", trial: failed("Evicted", "", jobOnly), retry: true},
+		{desc: "other class", trial: failed(ReasonMetricFailed, "", jobOnly)},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			assert.Equal(t, c.retry, ShouldRetry(c.trial))
+		})
+	}
+}
+
+func TestNewRetry(t *testing.T) {
+	tt := &redskyv1beta1.Trial{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "test-001",
+			Namespace:   "default",
+			Labels:      map[string]string{redskyv1beta1.LabelExperiment: "test"},
+			Annotations: map[string]string{redskyv1beta1.AnnotationReportTrialURL: "http://example.com/trial/1"},
+			Finalizers:  []string{"example.com/finalizer"},
+		},
+		Spec: redskyv1beta1.TrialSpec{
+			Assignments: []redskyv1beta1.Assignment{{Name: "one", Value: 1}},
+			RetryPolicy: &redskyv1beta1.TrialRetryPolicy{Limit: 3},
+		},
+	}
+
+	r1 := NewRetry(tt)
+	assert.Equal(t, "test-001-retry-1", r1.Name)
+	assert.Equal(t, "default", r1.Namespace)
+	assert.Equal(t, "test", r1.Labels[redskyv1beta1.LabelExperiment])
+	assert.Equal(t, "http://example.com/trial/1", r1.Annotations[redskyv1beta1.AnnotationReportTrialURL])
+	assert.Equal(t, int32(1), Retries(r1))
+	assert.Empty(t, r1.Finalizers)
+	assert.Equal(t, tt.Spec, r1.Spec)
+	assert.Equal(t, "one=1", r1.Status.Assignments)
+
+	RecordRetry(tt, r1)
+	assert.Equal(t, "test-001-retry-1", tt.Annotations[redskyv1beta1.AnnotationTrialRetriedBy])
+	assert.Empty(t, tt.Annotations[redskyv1beta1.AnnotationReportTrialURL])
+
+	r2 := NewRetry(r1)
+	assert.Equal(t, "test-001-retry-2", r2.Name)
+	assert.Equal(t, int32(2), Retries(r2))
+
+	// A trial which failed collecting metrics must not carry the values forward
+	mf := tt.DeepCopy()
+	mf.Spec.Values = []redskyv1beta1.Value{{Name: "one", Value: "1"}, {Name: "two", AttemptsRemaining: 0}}
+	mf.Status.Conditions = []redskyv1beta1.TrialCondition{
+		{Type: redskyv1beta1.TrialFailed, Status: corev1.ConditionTrue, Reason: ReasonMetricFailed},
+	}
+	r3 := NewRetry(mf)
+	assert.Empty(t, r3.Spec.Values)
+	assert.NotEmpty(t, mf.Spec.Values)
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trial
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/redskyops/redskyops-controller/internal/template"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/util/jsonpath"
+)
+
+// ReasonRunFailed is the reason used to fail trials whose run resource meets the failure condition
+const ReasonRunFailed = "RunFailed"
+
+// NewRun returns a new trial run resource from the run template on the trial
+func NewRun(t *redskyv1beta1.Trial) (*unstructured.Unstructured, error) {
+	if t.Spec.RunTemplate == nil {
+		return nil, fmt.Errorf("trial does not have a run template")
+	}
+
+	// Start with the rendered resource template
+	data, err := template.New().RenderRunResource(t.Spec.RunTemplate, t)
+	if err != nil {
+		return nil, err
+	}
+	run := &unstructured.Unstructured{}
+	if err := run.UnmarshalJSON(data); err != nil {
+		return nil, err
+	}
+
+	// Apply labels to the resource itself
+	l := run.GetLabels()
+	if l == nil {
+		l = make(map[string]string, 3)
+	}
+	l[redskyv1beta1.LabelExperiment] = t.ExperimentNamespacedName().Name
+	l[redskyv1beta1.LabelTrial] = t.Name
+	l[redskyv1beta1.LabelTrialRole] = "trialRun"
+	run.SetLabels(l)
+
+	// Provide default metadata
+	run.SetNamespace(t.Namespace)
+	if run.GetName() == "" && run.GetGenerateName() == "" {
+		run.SetName(t.Name)
+	}
+
+	return run, nil
+}
+
+// RunSelector returns the selector for trial run resources
+func RunSelector(t *redskyv1beta1.Trial) labels.Selector {
+	return labels.SelectorFromSet(labels.Set{
+		redskyv1beta1.LabelTrial:     t.Name,
+		redskyv1beta1.LabelTrialRole: "trialRun",
+	})
+}
+
+// RunStatus returns the interval of the trial run represented by the supplied resource. The completion time is only
+// returned once the success or failure condition is met, if the completion time path is not set (or not yet populated)
+// the supplied probe time is used. A message is returned if the failure condition is met.
+func RunStatus(run *redskyv1beta1.TrialRunTemplate, u *unstructured.Unstructured, probeTime *metav1.Time) (startedAt *metav1.Time, finishedAt *metav1.Time, failure string, err error) {
+	if ct := u.GetCreationTimestamp(); !ct.IsZero() {
+		startedAt = &ct
+	}
+	if run.StartTimePath != "" {
+		if startedAt, err = findTime(run.StartTimePath, u); err != nil {
+			return nil, nil, "", err
+		}
+	}
+
+	succeeded, err := MatchRunCondition(run.SuccessCondition, u)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	failed, err := MatchRunCondition(run.FailureCondition, u)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if !succeeded && !failed {
+		return startedAt, nil, "", nil
+	}
+
+	if run.CompletionTimePath != "" {
+		if finishedAt, err = findTime(run.CompletionTimePath, u); err != nil {
+			return nil, nil, "", err
+		}
+	}
+	if finishedAt == nil {
+		finishedAt = probeTime.DeepCopy()
+	}
+
+	if failed {
+		failure = fmt.Sprintf("%s '%s' met the failure condition: %s", u.GetKind(), u.GetName(), run.FailureCondition)
+	}
+	return startedAt, finishedAt, failure, nil
+}
+
+// ParseRunCondition parses a run condition expression. Run conditions use the label selector syntax where the keys
+// are dot separated paths to the fields of the resource, e.g. "status.phase in (Failed, Error)".
+func ParseRunCondition(expr string) (labels.Selector, error) {
+	return labels.Parse(expr)
+}
+
+// MatchRunCondition evaluates a run condition expression against the supplied resource, an empty expression never matches
+func MatchRunCondition(expr string, u *unstructured.Unstructured) (bool, error) {
+	if strings.TrimSpace(expr) == "" {
+		return false, nil
+	}
+
+	sel, err := ParseRunCondition(expr)
+	if err != nil {
+		return false, err
+	}
+	reqs, _ := sel.Requirements()
+	for _, req := range reqs {
+		// Each requirement is evaluated against the string representation of a single field
+		fields := labels.Set{}
+		if v, ok, err := unstructured.NestedFieldNoCopy(u.Object, strings.Split(req.Key(), ".")...); err != nil {
+			return false, err
+		} else if ok {
+			fields[req.Key()] = fmt.Sprint(v)
+		}
+		if !req.Matches(fields) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// findTime evaluates a JSONPath expression against the resource, returning nil if the time is not available yet
+func findTime(path string, u *unstructured.Unstructured) (*metav1.Time, error) {
+	jp := jsonpath.New("time").AllowMissingKeys(true)
+	if err := jp.Parse(path); err != nil {
+		return nil, err
+	}
+	results, err := jp.FindResults(u.Object)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range results {
+		for j := range results[i] {
+			s, ok := results[i][j].Interface().(string)
+			if !ok || s == "" {
+				continue
+			}
+			tt, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return nil, err
+			}
+			return &metav1.Time{Time: tt}, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trial
+
+import (
+	"testing"
+	"time"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestNewRun(t *testing.T) {
+	tt := &redskyv1beta1.Trial{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-trial", Namespace: "default"},
+		Spec: redskyv1beta1.TrialSpec{
+			ExperimentRef: &corev1.ObjectReference{Name: "test-experiment"},
+			Assignments:   []redskyv1beta1.Assignment{{Name: "users", Value: 10}},
+			RunTemplate: &redskyv1beta1.TrialRunTemplate{
+				Resource: runtime.RawExtension{Raw: []byte(`{"apiVersion":"k6.io/v1alpha1","kind":"K6","spec":{"arguments":"--vus {{ .Values.users }}"}}`)},
+			},
+		},
+	}
+
+	run, err := NewRun(tt)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "K6", run.GetKind())
+		assert.Equal(t, "test-trial", run.GetName())
+		assert.Equal(t, "default", run.GetNamespace())
+		assert.Equal(t, "trialRun", run.GetLabels()[redskyv1beta1.LabelTrialRole])
+		assert.Equal(t, "test-experiment", run.GetLabels()[redskyv1beta1.LabelExperiment])
+		args, _, _ := unstructured.NestedString(run.Object, "spec", "arguments")
+		assert.Equal(t, "--vus 10", args)
+	}
+}
+
+func TestRunStatus(t *testing.T) {
+	created := metav1.NewTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	now := metav1.NewTime(created.Add(time.Hour))
+	started := metav1.NewTime(created.Add(time.Minute))
+	finished := metav1.NewTime(created.Add(10 * time.Minute))
+
+	workflow := func(status map[string]interface{}) *unstructured.Unstructured {
+		u := &unstructured.Unstructured{Object: map[string]interface{}{"status": status}}
+		u.SetKind("Workflow")
+		u.SetName("test")
+		u.SetCreationTimestamp(created)
+		return u
+	}
+	argo := &redskyv1beta1.TrialRunTemplate{
+		SuccessCondition:   "status.phase == Succeeded",
+		FailureCondition:   "status.phase in (Failed, Error)",
+		StartTimePath:      "{.status.startedAt}",
+		CompletionTimePath: "{.status.finishedAt}",
+	}
+
+	cases := []struct {
+		desc       string
+		run        *redskyv1beta1.TrialRunTemplate
+		resource   *unstructured.Unstructured
+		startedAt  *metav1.Time
+		finishedAt *metav1.Time
+		failed     bool
+	}{
+		{
+			desc:     "not started",
+			run:      argo,
+			resource: workflow(map[string]interface{}{}),
+		},
+		{
+			desc:      "running",
+			run:       argo,
+			resource:  workflow(map[string]interface{}{"phase": "Running", "startedAt": started.Format(time.RFC3339)}),
+			startedAt: &started,
+		},
+		{
+			desc:       "succeeded",
+			run:        argo,
+			resource:   workflow(map[string]interface{}{"phase": "Succeeded", "startedAt": started.Format(time.RFC3339), "finishedAt": finished.Format(time.RFC3339)}),
+			startedAt:  &started,
+			finishedAt: &finished,
+		},
+		{
+			desc:       "failed",
+			run:        argo,
+			resource:   workflow(map[string]interface{}{"phase": "Error", "startedAt": started.Format(time.RFC3339)}),
+			startedAt:  &started,
+			finishedAt: &now,
+			failed:     true,
+		},
+		{
+			desc:       "default times",
+			run:        &redskyv1beta1.TrialRunTemplate{SuccessCondition: "status.succeeded > 0"},
+			resource:   workflow(map[string]interface{}{"succeeded": int64(1)}),
+			startedAt:  &created,
+			finishedAt: &now,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			startedAt, finishedAt, failure, err := RunStatus(c.run, c.resource, &now)
+			if assert.NoError(t, err) {
+				assertTime(t, c.startedAt, startedAt)
+				assertTime(t, c.finishedAt, finishedAt)
+				assert.Equal(t, c.failed, failure != "")
+			}
+		})
+	}
+}
+
+func TestMatchRunCondition(t *testing.T) {
+	u := &unstructured.Unstructured{Object: map[string]interface{}{
+		"status": map[string]interface{}{"phase": "Succeeded", "succeeded": int64(2), "ready": true},
+	}}
+
+	cases := []struct {
+		expr    string
+		matched bool
+	}{
+		{expr: ""},
+		{expr: "status.phase == Succeeded", matched: true},
+		{expr: "status.phase != Succeeded"},
+		{expr: "status.phase in (Failed, Error)"},
+		{expr: "status.succeeded > 1", matched: true},
+		{expr: "status.succeeded > 1, status.ready == true", matched: true},
+		{expr: "status.failed"},
+		{expr: "!status.failed", matched: true},
+	}
+	for _, c := range cases {
+		t.Run(c.expr, func(t *testing.T) {
+			matched, err := MatchRunCondition(c.expr, u)
+			if assert.NoError(t, err) {
+				assert.Equal(t, c.matched, matched)
+			}
+		})
+	}
+}
+
+func assertTime(t *testing.T, expected, actual *metav1.Time) {
+	if expected == nil {
+		assert.Nil(t, actual)
+	} else if assert.NotNil(t, actual) {
+		assert.True(t, expected.Equal(actual), "expected %s, got %s", expected, actual)
+	}
+}
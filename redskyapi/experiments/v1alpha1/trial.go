@@ -71,6 +71,10 @@ type TrialValues struct {
 	Values []Value `json:"values,omitempty"`
 	// Indicator that the trial failed, Values is ignored when true.
 	Failed bool `json:"failed,omitempty"`
+	// A machine-readable reason the trial failed.
+	FailureReason string `json:"failureReason,omitempty"`
+	// A human-readable description of why the trial failed.
+	FailureMessage string `json:"failureMessage,omitempty"`
 }
 
 type TrialStatus string
@@ -19,14 +19,18 @@ package check
 import (
 	"fmt"
 	"io/ioutil"
+	"path"
 	"strings"
 
 	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/redskyops/redskyops-controller/internal/metric"
 	"github.com/redskyops/redskyops-controller/internal/template"
+	"github.com/redskyops/redskyops-controller/internal/trial"
 	"github.com/redskyops/redskyops-controller/redskyctl/internal/commander"
 	"github.com/spf13/cobra"
 	batchv1 "k8s.io/api/batch/v1"
 	"k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -38,7 +42,8 @@ type ExperimentOptions struct {
 	// IOStreams are used to access the standard process streams
 	commander.IOStreams
 
-	Filename string
+	Filename        string
+	PricingFilename string
 }
 
 // NewExperimentCommand creates a new command for checking an experiment manifest
@@ -53,8 +58,10 @@ func NewExperimentCommand(o *ExperimentOptions) *cobra.Command {
 	}
 
 	cmd.Flags().StringVarP(&o.Filename, "filename", "f", "", "File that contains the experiment to check.")
+	cmd.Flags().StringVar(&o.PricingFilename, "pricing", "", "File that contains the pricing config map used by cost metrics.")
 
 	_ = cmd.MarkFlagFilename("filename", "yml", "yaml")
+	_ = cmd.MarkFlagFilename("pricing", "yml", "yaml")
 
 	commander.ExitOnError(cmd)
 	return cmd
@@ -83,6 +90,18 @@ func (o *ExperimentOptions) checkExperiment() error {
 	linter := &AllTheLint{}
 	checkExperiment(linter.For("experiment"), experiment)
 
+	// Check the pricing used by cost metrics
+	if o.PricingFilename != "" {
+		pricing := &corev1.ConfigMap{}
+		if data, err = ioutil.ReadFile(o.PricingFilename); err != nil {
+			return err
+		}
+		if err = yaml.Unmarshal(data, pricing); err != nil {
+			return err
+		}
+		checkPricing(linter.For("pricing"), experiment.Spec.Metrics, pricing)
+	}
+
 	// Share the results
 	// TODO Filter/sort?
 	for _, p := range linter.Problems {
@@ -101,7 +120,19 @@ func checkExperiment(lint Linter, experiment *redskyv1beta1.Experiment) {
 	checkParameters(lint.For("spec", "parameters"), experiment.Spec.Parameters)
 	checkMetrics(lint.For("spec", "metrics"), experiment.Spec.Metrics)
 	checkPatches(lint.For("spec", "patches"), experiment.Spec.Patches)
+	checkAbortConditions(lint.For("spec", "abortConditions"), experiment.Spec.AbortConditions)
 	checkTrialTemplate(lint.For("spec", "template"), &experiment.Spec.TrialTemplate)
+	if experiment.Spec.NamespaceTemplate != nil {
+		checkNamespaceTemplate(lint.For("spec", "namespaceTemplate"), experiment.Spec.NamespaceTemplate, experiment.Replicas())
+	}
+	if experiment.Spec.Clone != nil {
+		checkClone(lint.For("spec", "clone"), experiment.Spec.Clone)
+	}
+
+	// Replica trials are reported as a group, a failed replica fails the whole suggestion without a retry
+	if experiment.ReplicationCount() > 1 && experiment.Spec.TrialTemplate.Spec.RetryPolicy != nil {
+		lint.For("spec", "template", "spec").Error().Failed("retryPolicy", fmt.Errorf("replicated trials are not retried"))
+	}
 
 	// TODO Some checks are higher level and need a combination of pieces: e.g. selector/template matching
 
@@ -124,6 +155,44 @@ func checkTypeMeta(lint Linter, typeMeta *metav1.TypeMeta) bool {
 	return ok
 }
 
+func checkNamespaceTemplate(lint Linter, template *redskyv1beta1.NamespaceTemplateSpec, replicas int32) {
+
+	if template.PoolSize < 0 {
+		lint.Error().Invalid("poolSize", template.PoolSize, "greater than or equal to 0")
+	}
+
+	if template.PoolSize > 1 && template.Name != "" {
+		lint.Error().Invalid("metadata.name", template.Name, "empty when poolSize is greater than 1")
+	}
+
+	if template.PoolSize > 0 && template.PoolSize < replicas {
+		lint.Warning().Invalid("poolSize", template.PoolSize, fmt.Sprintf("at least %d replicas", replicas))
+	}
+
+	if template.PoolSize > 0 && template.TTLSecondsAfterFinished != nil {
+		lint.Warning().Invalid("ttlSecondsAfterFinished", *template.TTLSecondsAfterFinished, "empty when poolSize is set")
+	}
+
+}
+
+func checkClone(lint Linter, clone *redskyv1beta1.ApplicationClone) {
+
+	if _, err := metav1.LabelSelectorAsSelector(clone.Selector); err != nil {
+		lint.Error().Failed("selector", err)
+	}
+
+	if len(clone.Kinds) > 0 && clone.Selector == nil {
+		lint.Warning().Missing("selector")
+	}
+
+	for i, k := range clone.Kinds {
+		if k.Version == "" || k.Kind == "" {
+			lint.For("kinds", i).Error().Missing("version and kind")
+		}
+	}
+
+}
+
 func checkParameters(lint Linter, parameters []redskyv1beta1.Parameter) {
 
 	if len(parameters) == 0 {
@@ -146,8 +215,17 @@ func checkMetrics(lint Linter, metrics []redskyv1beta1.Metric) {
 		lint.Error().Missing("metrics")
 	}
 
+	names := make(map[string]bool, len(metrics))
+	for i := range metrics {
+		names[metrics[i].Name] = true
+	}
+
 	for i := range metrics {
 		checkMetric(lint.For(i), &metrics[i])
+
+		if metrics[i].Type == redskyv1beta1.MetricDerived {
+			checkDerivedMetric(lint.For(i), &metrics[i], names)
+		}
 	}
 
 }
@@ -158,11 +236,43 @@ func checkMetric(lint Linter, metric *redskyv1beta1.Metric) {
 		lint.Error().Missing("query")
 	}
 
-	if metric.Type == redskyv1beta1.MetricPrometheus && metric.Selector == nil {
+	if metric.Type == redskyv1beta1.MetricPrometheus && metric.Selector == nil && metric.PodSelector == nil {
 		lint.Error().Missing("selector for Prometheus metric")
 	}
 
-	if metric.Type == redskyv1beta1.MetricJSONPath {
+	if metric.Selector != nil && metric.PodSelector != nil {
+		lint.Error().Invalid("podSelector", "both selector and podSelector")
+	}
+
+	if metric.Type == redskyv1beta1.MetricPrometheus && metric.Aggregation != "" && metric.Step == nil {
+		lint.Warning().Missing("step for Prometheus metric aggregation")
+	}
+
+	if metric.Samples > 1 && metric.Type != redskyv1beta1.MetricPrometheus && metric.Type != redskyv1beta1.MetricDatadog {
+		lint.Warning().Invalid("samples", metric.Samples, 0, 1)
+	}
+
+	if metric.Samples > 1 && metric.ErrorQuery != "" {
+		lint.Warning().Invalid("errorQuery", metric.ErrorQuery, "")
+	}
+
+	if metric.Type == redskyv1beta1.MetricKubernetes && metric.Selector == nil {
+		lint.Error().Missing("selector for Kubernetes metric")
+	}
+
+	if metric.Type == redskyv1beta1.MetricCost {
+		if metric.Pricing == nil {
+			lint.Error().Missing("pricing for cost metric")
+		}
+		if metric.Selector == nil {
+			lint.Error().Missing("selector for cost metric")
+		}
+		if metric.Query != "requests" && metric.Query != "usage" {
+			lint.Error().Invalid("query", metric.Query, "requests", "usage")
+		}
+	}
+
+	if metric.Type == redskyv1beta1.MetricJSONPath || metric.Type == redskyv1beta1.MetricJob {
 		// TODO We need to render the template first
 		if !strings.Contains(metric.Query, "{") {
 			lint.Error().Invalid("query", metric.Query)
@@ -177,6 +287,79 @@ func checkMetric(lint Linter, metric *redskyv1beta1.Metric) {
 		lint.Error().Failed("query", err)
 	}
 
+	if _, err := template.New().RenderMetricBody(metric, &redskyv1beta1.Trial{}, nil); err != nil {
+		lint.Error().Failed("body", err)
+	}
+
+}
+
+func checkDerivedMetric(lint Linter, m *redskyv1beta1.Metric, names map[string]bool) {
+	inputs, err := metric.DerivedInputs(m.Query)
+	if err != nil {
+		lint.Error().Failed("query", err)
+		return
+	}
+
+	for _, name := range inputs {
+		if name == m.Name || !names[name] {
+			lint.Error().Invalid("query", name)
+		}
+	}
+}
+
+func checkPricing(lint Linter, metrics []redskyv1beta1.Metric, pricing *corev1.ConfigMap) {
+	var found bool
+	for i := range metrics {
+		m := &metrics[i]
+		if m.Type != redskyv1beta1.MetricCost || m.Pricing == nil || m.Pricing.Name != pricing.Name {
+			continue
+		}
+
+		found = true
+		data, ok := pricing.Data[m.Pricing.Key]
+		if !ok {
+			lint.For(m.Name).Error().Missing(m.Pricing.Key)
+			continue
+		}
+
+		if _, err := metric.ParsePricing(data); err != nil {
+			lint.For(m.Name).Error().Failed(m.Pricing.Key, err)
+		}
+	}
+
+	if !found {
+		lint.Warning().Missing(fmt.Sprintf("cost metric using config map '%s'", pricing.Name))
+	}
+}
+
+func checkAbortConditions(lint Linter, conditions []redskyv1beta1.AbortCondition) {
+	for i := range conditions {
+		checkAbortCondition(lint.For(i), &conditions[i])
+	}
+}
+
+func checkAbortCondition(lint Linter, condition *redskyv1beta1.AbortCondition) {
+
+	if condition.Name == "" {
+		lint.Error().Missing("name")
+	}
+
+	switch condition.Type {
+	case redskyv1beta1.AbortPrometheus:
+		if condition.Query == "" {
+			lint.Error().Missing("query")
+		}
+		if condition.Selector == nil {
+			lint.Error().Missing("selector for Prometheus abort condition")
+		}
+	case redskyv1beta1.AbortRestarts, redskyv1beta1.AbortOOMKilled:
+		if condition.Query != "" {
+			lint.Warning().Invalid("query", condition.Query, "")
+		}
+	default:
+		lint.Error().Invalid("type", condition.Type, redskyv1beta1.AbortPrometheus, redskyv1beta1.AbortRestarts, redskyv1beta1.AbortOOMKilled)
+	}
+
 }
 
 func checkPatches(lint Linter, patches []redskyv1beta1.PatchTemplate) {
@@ -214,10 +397,96 @@ func checkTrialTemplate(lint Linter, template *redskyv1beta1.TrialTemplateSpec)
 	checkTrial(lint.For("spec"), &template.Spec)
 }
 
-func checkTrial(lint Linter, trial *redskyv1beta1.TrialSpec) {
-	if trial.JobTemplate != nil {
-		checkJobTemplate(lint.For("jobTemplate"), trial.JobTemplate)
+func checkTrial(lint Linter, t *redskyv1beta1.TrialSpec) {
+	if t.JobTemplate != nil {
+		checkJobTemplate(lint.For("jobTemplate"), t.JobTemplate)
+	}
+
+	if t.RunTimeout != nil && t.ApproximateRuntime != nil && t.RunTimeout.Duration <= t.ApproximateRuntime.Duration {
+		lint.Warning().Invalid("runTimeout", t.RunTimeout.Duration, fmt.Sprintf("greater than %s", t.ApproximateRuntime.Duration))
+	}
+
+	if t.RetryPolicy != nil {
+		checkRetryPolicy(lint.For("retryPolicy"), t.RetryPolicy)
+	}
+
+	if t.RunTemplate != nil {
+		if t.JobTemplate != nil {
+			lint.Error().Invalid("runTemplate", "both jobTemplate and runTemplate")
+		}
+		checkRunTemplate(lint.For("runTemplate"), t.RunTemplate)
+		if t.ResultsFile {
+			lint.Warning().Invalid("resultsFile", "true", "false when using a runTemplate")
+		}
 	}
+
+	if t.AssignmentsDelivery != nil {
+		checkAssignmentsDelivery(lint.For("assignmentsDelivery"), t.AssignmentsDelivery)
+	}
+}
+
+func checkAssignmentsDelivery(lint Linter, d *redskyv1beta1.AssignmentsDelivery) {
+
+	switch strings.ToLower(d.EnvCase) {
+	case "", "upper", "lower", "preserve":
+	default:
+		lint.Error().Invalid("envCase", d.EnvCase, "upper", "lower", "preserve")
+	}
+
+	switch d.FileFormat {
+	case "", redskyv1beta1.AssignmentsJSON, redskyv1beta1.AssignmentsYAML, redskyv1beta1.AssignmentsProperties:
+	default:
+		lint.Error().Invalid("fileFormat", d.FileFormat, redskyv1beta1.AssignmentsJSON, redskyv1beta1.AssignmentsYAML, redskyv1beta1.AssignmentsProperties)
+	}
+
+	if d.FilePath != "" && !path.IsAbs(d.FilePath) {
+		lint.Error().Invalid("filePath", d.FilePath, "an absolute path")
+	}
+
+	if d.DisableEnv && d.FilePath == "" {
+		lint.Warning().Missing("filePath")
+	}
+
+}
+
+func checkRetryPolicy(lint Linter, policy *redskyv1beta1.TrialRetryPolicy) {
+
+	if policy.Limit <= 0 {
+		lint.Warning().Invalid("limit", policy.Limit, "greater than 0")
+	}
+
+	for _, c := range policy.RetryOn {
+		switch c {
+		case redskyv1beta1.FailureSetup, redskyv1beta1.FailurePatch, redskyv1beta1.FailureReadiness, redskyv1beta1.FailureJob, redskyv1beta1.FailureMetric:
+		default:
+			lint.Error().Invalid("retryOn", c, redskyv1beta1.FailureSetup, redskyv1beta1.FailurePatch, redskyv1beta1.FailureReadiness, redskyv1beta1.FailureJob, redskyv1beta1.FailureMetric)
+		}
+	}
+
+}
+
+func checkRunTemplate(lint Linter, run *redskyv1beta1.TrialRunTemplate) {
+
+	if len(run.Resource.Raw) == 0 {
+		lint.Error().Missing("resource")
+	} else if u, err := trial.NewRun(&redskyv1beta1.Trial{Spec: redskyv1beta1.TrialSpec{RunTemplate: run}}); err != nil {
+		lint.Error().Failed("resource", err)
+	} else if u.GetAPIVersion() == "" || u.GetKind() == "" {
+		lint.Error().Missing("resource API version and kind")
+	}
+
+	if run.SuccessCondition == "" {
+		lint.Error().Missing("successCondition")
+	} else if _, err := trial.ParseRunCondition(run.SuccessCondition); err != nil {
+		lint.Error().Failed("successCondition", err)
+	}
+
+	if run.FailureCondition == "" {
+		lint.Warning().Missing("failureCondition")
+	} else if _, err := trial.ParseRunCondition(run.FailureCondition); err != nil {
+		lint.Error().Failed("failureCondition", err)
+	}
+
 }
 
 func checkJobTemplate(lint Linter, template *v1beta1.JobTemplateSpec) {
@@ -50,6 +50,7 @@ func NewCommand(o *Options) *cobra.Command {
 
 	cmd.AddCommand(NewRBACCommand(&RBACOptions{Config: o.Config, ClusterRole: true, ClusterRoleBinding: true}))
 	cmd.AddCommand(NewTrialCommand(&TrialOptions{}))
+	cmd.AddCommand(NewTrialJobCommand(&TrialJobOptions{}))
 
 	// Also include plumbing generators used by other commands
 	cmd.AddCommand(authorize_cluster.NewGeneratorCommand(&authorize_cluster.GeneratorOptions{Config: o.Config}))
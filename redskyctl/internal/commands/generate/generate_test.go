@@ -39,6 +39,13 @@ func TestGenerate(t *testing.T) {
 
 	defer os.Remove(experimentFile.Name())
 
+	jobExperimentFile, err := ioutil.TempFile("", "trial")
+	require.NoError(t, err)
+	_, err = jobExperimentFile.Write(jobExperiment)
+	require.NoError(t, err)
+
+	defer os.Remove(jobExperimentFile.Name())
+
 	rsConfig, err := ioutil.TempFile("", "rsConfig")
 	require.NoError(t, err)
 	_, err = rsConfig.Write(configData)
@@ -146,6 +153,65 @@ func TestGenerate(t *testing.T) {
 				"value: 500",
 			},
 		},
+		{
+			desc: "gen trial-job",
+			args: []string{
+				"trial-job",
+				"--tool", "k6",
+				"--url", "http://frontend",
+				"--users", "10",
+			},
+			expectedError: false,
+			expectedPatterns: []string{
+				"image: loadimpact/k6",
+				"k6 run --vus 10 --duration 2m0s",
+				"value: http://frontend",
+				"type: job",
+			},
+		},
+		{
+			desc: "gen trial-job experiment",
+			args: []string{
+				"trial-job",
+				"--filename", experimentFile.Name(),
+				"--tool", "locust",
+				"--url", "http://frontend",
+				"--duration", "5m",
+			},
+			expectedError: false,
+			expectedPatterns: []string{
+				"name: postgres-example",
+				"image: locustio/locust",
+				"--run-time 300s",
+				"approximateRuntime: 5m0s",
+			},
+		},
+		{
+			desc: "gen trial-job existing job template",
+			args: []string{
+				"trial-job",
+				"--filename", jobExperimentFile.Name(),
+				"--tool", "locust",
+				"--url", "http://frontend",
+			},
+			expectedError: false,
+			expectedPatterns: []string{
+				"backoffLimit: 2",
+				"serviceAccountName: load-test",
+				"name: results",
+				"image: busybox",
+				"image: locustio/locust",
+				"--run-time 600s",
+			},
+			unexpectedPatterns: []string{
+				"image: locustio/locust:0.1",
+			},
+		},
+		{
+			desc:          "gen trial-job (no args)",
+			args:          []string{"trial-job"},
+			expectedError: true,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -194,6 +260,33 @@ spec:
     min: 100
     max: 4000`)
 
+var jobExperiment = []byte(`apiVersion: redskyops.dev/v1beta1
+kind: Experiment
+metadata:
+  name: load-example
+spec:
+  parameters:
+  - name: memory
+    min: 500
+    max: 4000
+  trialTemplate:
+    spec:
+      approximateRuntime: 10m
+      jobTemplate:
+        spec:
+          backoffLimit: 2
+          template:
+            spec:
+              serviceAccountName: load-test
+              volumes:
+              - name: results
+                emptyDir: {}
+              containers:
+              - name: locust
+                image: locustio/locust:0.1
+              - name: sidecar
+                image: busybox`)
+
 var configData = []byte(`
 authorizations:
 - authorization:
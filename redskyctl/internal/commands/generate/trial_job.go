@@ -0,0 +1,249 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package generate
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/redskyops/redskyops-controller/redskyctl/internal/commander"
+	"github.com/spf13/cobra"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// loadGenerator produces the trial run container for a load generation tool, the container must write a JSON document
+// with the "throughput", "latency" and "errors" results to its termination message
+type loadGenerator func(o *TrialJobOptions, duration time.Duration) corev1.Container
+
+// loadGenerators are the supported load generation tools
+var loadGenerators = map[string]loadGenerator{
+	"k6":     k6Container,
+	"locust": locustContainer,
+	"wrk":    wrkContainer,
+}
+
+// TrialJobOptions are the options for generating a trial job template
+type TrialJobOptions struct {
+	// Printer is the resource printer used to render generated objects
+	Printer commander.ResourcePrinter
+	// IOStreams are used to access the standard process streams
+	commander.IOStreams
+
+	Filename string
+	Tool     string
+	Image    string
+	URL      string
+	Users    int
+	Duration time.Duration
+}
+
+// NewTrialJobCommand creates a new command for generating trial job templates
+func NewTrialJobCommand(o *TrialJobOptions) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "trial-job",
+		Short: "Generate load generator trial jobs",
+		Long:  "Generate a trial job template and metrics for a load generation tool",
+
+		Annotations: map[string]string{
+			commander.PrinterAllowedFormats: "json,yaml",
+			commander.PrinterOutputFormat:   "yaml",
+			commander.PrinterHideStatus:     "true",
+		},
+
+		PreRun: commander.StreamsPreRun(&o.IOStreams),
+		RunE:   commander.WithoutArgsE(o.generate),
+	}
+
+	cmd.Flags().StringVarP(&o.Filename, "filename", "f", o.Filename, "File that contains the experiment to add the trial job to.")
+	cmd.Flags().StringVar(&o.Tool, "tool", o.Tool, fmt.Sprintf("Load generation `tool` to use; one of: %s.", strings.Join(loadGeneratorNames(), "|")))
+	cmd.Flags().StringVar(&o.Image, "image", o.Image, "Override the load generator container image.")
+	cmd.Flags().StringVar(&o.URL, "url", o.URL, "Target URL to generate load against.")
+	cmd.Flags().IntVar(&o.Users, "users", o.Users, "Number of concurrent users (or connections) generating load.")
+	cmd.Flags().DurationVar(&o.Duration, "duration", o.Duration, "Duration of the load test (default is the approximate runtime of the trial).")
+
+	_ = cmd.MarkFlagFilename("filename", "yml", "yaml")
+	_ = cmd.MarkFlagRequired("tool")
+	_ = cmd.MarkFlagRequired("url")
+
+	commander.SetKubePrinter(&o.Printer, cmd)
+	commander.ExitOnError(cmd)
+	return cmd
+}
+
+func (o *TrialJobOptions) generate() error {
+	lg, ok := loadGenerators[o.Tool]
+	if !ok {
+		return fmt.Errorf("unknown load generation tool '%s', expected one of: %s", o.Tool, strings.Join(loadGeneratorNames(), ", "))
+	}
+	if o.URL == "" {
+		return fmt.Errorf("target URL is required")
+	}
+	if o.Users <= 0 {
+		o.Users = 1
+	}
+
+	// Start with the supplied experiment, or an empty one
+	exp := &redskyv1beta1.Experiment{}
+	if o.Filename != "" {
+		experimentList := &redskyv1beta1.ExperimentList{}
+		if err := readExperiments(o.Filename, o.In, experimentList); err != nil {
+			return err
+		}
+		if len(experimentList.Items) != 1 {
+			return fmt.Errorf("trial job generation requires a single experiment as input")
+		}
+		exp = &experimentList.Items[0]
+	}
+	exp.APIVersion = redskyv1beta1.GroupVersion.String()
+	exp.Kind = "Experiment"
+
+	// The duration defaults to the approximate runtime of the trial
+	duration := o.Duration
+	if duration <= 0 {
+		duration = 2 * time.Minute
+		if ar := exp.Spec.TrialTemplate.Spec.ApproximateRuntime; ar != nil && ar.Duration > 0 {
+			duration = ar.Duration
+		}
+	}
+
+	// Build the job template
+	c := lg(o, duration)
+	if o.Image != "" {
+		c.Image = o.Image
+	}
+	if exp.Spec.TrialTemplate.Spec.JobTemplate == nil {
+		exp.Spec.TrialTemplate.Spec.JobTemplate = &batchv1beta1.JobTemplateSpec{}
+	}
+	setContainer(&exp.Spec.TrialTemplate.Spec.JobTemplate.Spec.Template.Spec, c)
+	if exp.Spec.TrialTemplate.Spec.ApproximateRuntime == nil {
+		exp.Spec.TrialTemplate.Spec.ApproximateRuntime = &metav1.Duration{Duration: duration}
+	}
+
+	// Add the metrics reported by the job
+	exp.Spec.Metrics = appendMetrics(exp.Spec.Metrics,
+		redskyv1beta1.Metric{Name: "throughput", Type: redskyv1beta1.MetricJob, Query: "{.throughput}"},
+		redskyv1beta1.Metric{Name: "latency", Type: redskyv1beta1.MetricJob, Query: "{.latency}", Minimize: true},
+		redskyv1beta1.Metric{Name: "errors", Type: redskyv1beta1.MetricJob, Query: "{.errors}", Minimize: true},
+	)
+
+	return o.Printer.PrintObj(exp, o.Out)
+}
+
+// setContainer replaces the container with the same name, or adds it if there is no such container; the rest of the
+// pod specification is left as is
+func setContainer(spec *corev1.PodSpec, c corev1.Container) {
+	for i := range spec.Containers {
+		if spec.Containers[i].Name == c.Name {
+			spec.Containers[i] = c
+			return
+		}
+	}
+	spec.Containers = append(spec.Containers, c)
+}
+
+// appendMetrics adds metrics which are not already defined
+func appendMetrics(metrics []redskyv1beta1.Metric, add ...redskyv1beta1.Metric) []redskyv1beta1.Metric {
+	names := make(map[string]bool, len(metrics))
+	for i := range metrics {
+		names[metrics[i].Name] = true
+	}
+	for i := range add {
+		if !names[add[i].Name] {
+			metrics = append(metrics, add[i])
+		}
+	}
+	return metrics
+}
+
+func loadGeneratorNames() []string {
+	names := make([]string, 0, len(loadGenerators))
+	for name := range loadGenerators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// k6Container runs an inline k6 script whose summary is reported as the termination message
+func k6Container(o *TrialJobOptions, duration time.Duration) corev1.Container {
+	script := `import http from 'k6/http';
+export default function () { http.get(__ENV.TARGET_URL); }
+export function handleSummary(data) {
+  return { '/dev/termination-log': JSON.stringify({
+    throughput: data.metrics.http_reqs.values.rate,
+    latency: data.metrics.http_req_duration.values['p(95)'] / 1000,
+    errors: data.metrics.http_req_failed.values.rate,
+  }) };
+}`
+	return corev1.Container{
+		Name:    "k6",
+		Image:   "loadimpact/k6",
+		Command: []string{"/bin/sh", "-c"},
+		Args:    []string{fmt.Sprintf("k6 run --vus %d --duration %s - <<'EOF'\n%s\nEOF", o.Users, duration, script)},
+		Env:     []corev1.EnvVar{{Name: "TARGET_URL", Value: o.URL}},
+	}
+}
+
+// locustContainer runs an inline locustfile in headless mode, the total statistics are reported as the termination message
+func locustContainer(o *TrialJobOptions, duration time.Duration) corev1.Container {
+	script := `import json
+from locust import HttpUser, events, task
+
+class TrialUser(HttpUser):
+    @task
+    def get(self):
+        self.client.get("")
+
+@events.quitting.add_listener
+def report(environment, **kwargs):
+    s = environment.stats.total
+    with open("/dev/termination-log", "w") as f:
+        json.dump({"throughput": s.total_rps, "latency": s.get_response_time_percentile(0.95) / 1000.0, "errors": s.fail_ratio}, f)`
+	return corev1.Container{
+		Name:    "locust",
+		Image:   "locustio/locust",
+		Command: []string{"/bin/sh", "-c"},
+		Args: []string{fmt.Sprintf("cat > /tmp/locustfile.py <<'EOF'\n%s\nEOF\nlocust -f /tmp/locustfile.py --headless --host \"$TARGET_URL\" --users %d --spawn-rate %d --run-time %.0fs",
+			script, o.Users, o.Users, duration.Seconds())},
+		Env: []corev1.EnvVar{{Name: "TARGET_URL", Value: o.URL}},
+	}
+}
+
+// wrkContainer runs wrk with an inline Lua script that reports the summary as the termination message
+func wrkContainer(o *TrialJobOptions, duration time.Duration) corev1.Container {
+	script := `done = function(summary, latency, requests)
+  local e = summary.errors
+  local errors = e.connect + e.read + e.write + e.status + e.timeout
+  local f = io.open("/dev/termination-log", "w")
+  f:write(string.format('{"throughput":%f,"latency":%f,"errors":%f}',
+    summary.requests / (summary.duration / 1000000), latency:percentile(95) / 1000000, errors / math.max(summary.requests, 1)))
+  f:close()
+end`
+	return corev1.Container{
+		Name:    "wrk",
+		Image:   "williamyeh/wrk",
+		Command: []string{"/bin/sh", "-c"},
+		Args: []string{fmt.Sprintf("cat > /tmp/report.lua <<'EOF'\n%s\nEOF\nwrk --threads 1 --connections %d --duration %.0fs --script /tmp/report.lua \"$TARGET_URL\"",
+			script, o.Users, duration.Seconds())},
+		Env: []corev1.EnvVar{{Name: "TARGET_URL", Value: o.URL}},
+	}
+}
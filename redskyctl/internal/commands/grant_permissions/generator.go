@@ -20,6 +20,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"strings"
 
 	"github.com/redskyops/redskyops-controller/internal/config"
 	"github.com/redskyops/redskyops-controller/redskyctl/internal/commander"
@@ -43,6 +44,10 @@ type GeneratorOptions struct {
 	SkipDefault bool
 	// CreateTrialNamespaces includes additional permissions to allow the controller to create trial namespaces
 	CreateTrialNamespaces bool
+	// CloneApplication includes additional permissions to allow the controller to clone the application into trial namespaces
+	CloneApplication bool
+	// RunResources are the resources (e.g. "workflows.argoproj.io") created by trial run templates
+	RunResources []string
 	// NamespaceSelector generates namespaced bindings instead of cluster bindings
 	NamespaceSelector string
 	// IncludeManagerRole generates an additional binding to the manager role for each matched namespace
@@ -75,6 +80,8 @@ func NewGeneratorCommand(o *GeneratorOptions) *cobra.Command {
 func (o *GeneratorOptions) addFlags(cmd *cobra.Command) {
 	cmd.Flags().BoolVar(&o.SkipDefault, "skip-default", o.SkipDefault, "Skip default permissions.")
 	cmd.Flags().BoolVar(&o.CreateTrialNamespaces, "create-trial-namespace", o.CreateTrialNamespaces, "Include trial namespace creation permissions.")
+	cmd.Flags().BoolVar(&o.CloneApplication, "clone-application", o.CloneApplication, "Include application cloning permissions.")
+	cmd.Flags().StringSliceVar(&o.RunResources, "run-resource", o.RunResources, "Include trial run template permissions for the `resource` (e.g. workflows.argoproj.io).")
 	cmd.Flags().StringVar(&o.NamespaceSelector, "ns-selector", o.NamespaceSelector, "Bind to matching namespaces.")
 	cmd.Flags().BoolVar(&o.IncludeManagerRole, "include-manager", o.IncludeManagerRole, "Bind manager to matching namespaces.")
 }
@@ -138,7 +145,7 @@ func (o *GeneratorOptions) bindingTargets() (*rbacv1.RoleRef, *rbacv1.Subject, e
 }
 
 func (o *GeneratorOptions) generateClusterRole(roleRef *rbacv1.RoleRef) *rbacv1.ClusterRole {
-	if roleRef == nil || (o.SkipDefault && !o.CreateTrialNamespaces) {
+	if roleRef == nil || (o.SkipDefault && !o.CreateTrialNamespaces && !o.CloneApplication && len(o.RunResources) == 0) {
 		return nil
 	}
 
@@ -168,9 +175,54 @@ func (o *GeneratorOptions) generateClusterRole(roleRef *rbacv1.RoleRef) *rbacv1.
 				APIGroups: []string{""},
 				Resources: []string{"namespaces,serviceaccounts"},
 			},
+			rbacv1.PolicyRule{
+				Verbs:     []string{"update", "delete"},
+				APIGroups: []string{""},
+				Resources: []string{"namespaces"},
+			},
+			rbacv1.PolicyRule{
+				Verbs:     []string{"create"},
+				APIGroups: []string{""},
+				Resources: []string{"resourcequotas", "limitranges"},
+			},
+			rbacv1.PolicyRule{
+				Verbs:     []string{"create"},
+				APIGroups: []string{"networking.k8s.io"},
+				Resources: []string{"networkpolicies"},
+			},
 		)
 	}
 
+	// Cloning the application copies the default kinds from the source namespace into the trial namespaces
+	if o.CloneApplication {
+		clusterRole.Rules = append(clusterRole.Rules,
+			rbacv1.PolicyRule{
+				Verbs:     []string{"get", "list", "create", "patch"},
+				APIGroups: []string{""},
+				Resources: []string{"configmaps", "secrets", "services", "serviceaccounts"},
+			},
+			rbacv1.PolicyRule{
+				Verbs:     []string{"get", "list", "create", "patch"},
+				APIGroups: []string{"apps"},
+				Resources: []string{"deployments", "statefulsets"},
+			},
+		)
+	}
+
+	// Trial run templates create arbitrary resources
+	for _, r := range o.RunResources {
+		// Split "resource.group" the same way `kubectl create clusterrole --resource` does
+		resource, group := r, ""
+		if pos := strings.Index(r, "."); pos >= 0 {
+			resource, group = r[:pos], r[pos+1:]
+		}
+		clusterRole.Rules = append(clusterRole.Rules, rbacv1.PolicyRule{
+			Verbs:     []string{"list", "create", "delete"},
+			APIGroups: []string{group},
+			Resources: []string{resource},
+		})
+	}
+
 	return clusterRole
 }
 
@@ -0,0 +1,227 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespaces
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/redskyops/redskyops-controller/internal/config"
+	"github.com/redskyops/redskyops-controller/redskyctl/internal/commander"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Options are the configuration for listing and pruning trial namespaces
+type Options struct {
+	// Config is the Red Sky Configuration for connecting to the cluster
+	Config *config.RedSkyConfig
+	// IOStreams are used to access the standard process streams
+	commander.IOStreams
+
+	// Prune deletes the orphaned namespaces
+	Prune bool
+	// Yes skips the confirmation before deleting the orphaned namespaces
+	Yes bool
+	// DryRun reports the orphaned namespaces which would be deleted without deleting them
+	DryRun bool
+	// All includes namespaces which are still in use by an experiment
+	All bool
+}
+
+// NewCommand creates a new command for listing and pruning trial namespaces
+func NewCommand(o *Options) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "namespaces",
+		Short: "List orphaned trial namespaces",
+		Long:  "List or prune namespaces created from experiment namespace templates whose experiment no longer exists",
+
+		PreRun: commander.StreamsPreRun(&o.IOStreams),
+		RunE:   commander.WithContextE(o.run),
+	}
+
+	cmd.Flags().BoolVar(&o.Prune, "prune", o.Prune, "Delete the orphaned namespaces.")
+	cmd.Flags().BoolVarP(&o.Yes, "yes", "y", o.Yes, "Delete the orphaned namespaces without prompting for confirmation.")
+	cmd.Flags().BoolVar(&o.DryRun, "dry-run", o.DryRun, "Only report the orphaned namespaces that would be deleted.")
+	cmd.Flags().BoolVarP(&o.All, "all", "A", o.All, "Include namespaces that are still in use by an experiment.")
+
+	commander.ExitOnError(cmd)
+	return cmd
+}
+
+// trialNamespace is a namespace created from an experiment namespace template
+type trialNamespace struct {
+	// Name is the name of the namespace
+	Name string
+	// Experiment is the namespace and name (or just the name for older namespaces) of the experiment
+	Experiment string
+	// Orphaned is true if the experiment no longer exists
+	Orphaned bool
+	// Pooled is true if the namespace belongs to a namespace pool, pooled namespaces are never pruned
+	Pooled bool
+}
+
+func (o *Options) run(ctx context.Context) error {
+	namespaceList, err := o.getNamespaces(ctx)
+	if err != nil {
+		return err
+	}
+
+	experiments, err := o.getExperiments(ctx)
+	if err != nil {
+		return err
+	}
+
+	var orphaned []string
+	w := tabwriter.NewWriter(o.Out, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "NAME\tEXPERIMENT\tSTATUS")
+	for _, tns := range trialNamespaces(namespaceList, experiments) {
+		status := "Active"
+		if tns.Pooled {
+			status = "Pooled"
+			if !o.All {
+				continue
+			}
+		} else if tns.Orphaned {
+			status = "Orphaned"
+			orphaned = append(orphaned, tns.Name)
+		} else if !o.All {
+			continue
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", tns.Name, tns.Experiment, status)
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if !o.Prune || len(orphaned) == 0 {
+		return nil
+	}
+
+	if o.DryRun {
+		for _, name := range orphaned {
+			_, _ = fmt.Fprintf(o.Out, "namespace %q deleted (dry run)\n", name)
+		}
+		return nil
+	}
+
+	if !o.Yes && !o.confirm(len(orphaned)) {
+		return fmt.Errorf("aborted, no namespaces were deleted")
+	}
+
+	// Delegate the deletion to kubectl
+	del, err := o.Config.Kubectl(ctx, append([]string{"delete", "namespace", "--ignore-not-found"}, orphaned...)...)
+	if err != nil {
+		return err
+	}
+	del.Stdout = o.Out
+	del.Stderr = o.ErrOut
+	return del.Run()
+}
+
+// confirm prompts for confirmation before deleting the orphaned namespaces
+func (o *Options) confirm(count int) bool {
+	_, _ = fmt.Fprintf(o.ErrOut, "Delete %d orphaned namespace(s)? [y/N]: ", count)
+	s := bufio.NewScanner(o.In)
+	if !s.Scan() {
+		return false
+	}
+	switch strings.ToLower(strings.TrimSpace(s.Text())) {
+	case "y", "yes":
+		return true
+	default:
+		return false
+	}
+}
+
+// getNamespaces returns the namespaces which may have been created from a namespace template
+func (o *Options) getNamespaces(ctx context.Context) (*corev1.NamespaceList, error) {
+	selector := fmt.Sprintf("%s=trialSetup,%s", redskyv1beta1.LabelTrialRole, redskyv1beta1.LabelExperiment)
+	get, err := o.Config.Kubectl(ctx, "get", "namespaces", "--selector", selector, "--output", "yaml")
+	if err != nil {
+		return nil, err
+	}
+	get.Stderr = o.ErrOut
+	output, err := get.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	namespaceList := &corev1.NamespaceList{}
+	if err := yaml.Unmarshal(output, namespaceList); err != nil {
+		return nil, err
+	}
+	return namespaceList, nil
+}
+
+// getExperiments returns every experiment in the cluster
+func (o *Options) getExperiments(ctx context.Context) (*redskyv1beta1.ExperimentList, error) {
+	// Request the version we decode explicitly, the preferred version is not necessarily the same
+	get, err := o.Config.Kubectl(ctx, "get", "experiments.v1beta1.redskyops.dev", "--all-namespaces", "--output", "yaml")
+	if err != nil {
+		return nil, err
+	}
+	get.Stderr = o.ErrOut
+	output, err := get.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	experimentList := &redskyv1beta1.ExperimentList{}
+	if err := yaml.Unmarshal(output, experimentList); err != nil {
+		return nil, err
+	}
+	return experimentList, nil
+}
+
+// trialNamespaces returns the namespaces created from namespace templates. Namespaces created before the experiment
+// reference was recorded only have the experiment name, they are considered in use if any experiment with that name
+// has a namespace template (an experiment without a namespace template could not have created them).
+func trialNamespaces(namespaceList *corev1.NamespaceList, experimentList *redskyv1beta1.ExperimentList) []trialNamespace {
+	refs := make(map[string]bool, len(experimentList.Items))
+	names := make(map[string]bool, len(experimentList.Items))
+	for i := range experimentList.Items {
+		exp := &experimentList.Items[i]
+		refs[exp.Namespace+"/"+exp.Name] = true
+		if exp.Spec.NamespaceTemplate != nil {
+			names[exp.Name] = true
+		}
+	}
+
+	var result []trialNamespace
+	for i := range namespaceList.Items {
+		n := &namespaceList.Items[i]
+		tns := trialNamespace{Name: n.Name, Pooled: n.Labels[redskyv1beta1.LabelNamespacePool] != ""}
+		if ref, ok := n.Annotations[redskyv1beta1.AnnotationNamespaceTemplate]; ok {
+			tns.Experiment = ref
+			tns.Orphaned = !refs[ref]
+		} else {
+			tns.Experiment = n.Labels[redskyv1beta1.LabelExperiment]
+			tns.Orphaned = !names[tns.Experiment]
+		}
+		result = append(result, tns)
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
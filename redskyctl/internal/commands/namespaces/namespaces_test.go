@@ -0,0 +1,94 @@
+/*
+Copyright 2020 GramLabs, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package namespaces
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	redskyv1beta1 "github.com/redskyops/redskyops-controller/api/v1beta1"
+	"github.com/redskyops/redskyops-controller/redskyctl/internal/commander"
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestTrialNamespaces(t *testing.T) {
+	created := func(name, experiment, ref string) corev1.Namespace {
+		n := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{redskyv1beta1.LabelExperiment: experiment},
+		}}
+		if ref != "" {
+			n.Annotations = map[string]string{redskyv1beta1.AnnotationNamespaceTemplate: ref}
+		}
+		return n
+	}
+
+	namespaceList := &corev1.NamespaceList{Items: []corev1.Namespace{
+		created("b-1", "b", "default/b"),
+		created("a-1", "a", "default/a"),
+		created("a-2", "a", "other/a"),
+		created("c-1", "c", ""),
+		created("a-3", "a", ""),
+		created("d-1", "d", ""),
+		created("e-1", "e", "default/e"),
+	}}
+	namespaceList.Items[len(namespaceList.Items)-1].Labels[redskyv1beta1.LabelNamespacePool] = "e"
+
+	experiment := func(namespace, name string, template bool) redskyv1beta1.Experiment {
+		exp := redskyv1beta1.Experiment{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+		if template {
+			exp.Spec.NamespaceTemplate = &redskyv1beta1.NamespaceTemplateSpec{}
+		}
+		return exp
+	}
+	experimentList := &redskyv1beta1.ExperimentList{Items: []redskyv1beta1.Experiment{
+		experiment("default", "a", true),
+		experiment("other", "d", false),
+	}}
+
+	assert.Equal(t, []trialNamespace{
+		{Name: "a-1", Experiment: "default/a"},
+		{Name: "a-2", Experiment: "other/a", Orphaned: true},
+		{Name: "a-3", Experiment: "a"},
+		{Name: "b-1", Experiment: "default/b", Orphaned: true},
+		{Name: "c-1", Experiment: "c", Orphaned: true},
+		{Name: "d-1", Experiment: "d", Orphaned: true},
+		{Name: "e-1", Experiment: "default/e", Orphaned: true, Pooled: true},
+	}, trialNamespaces(namespaceList, experimentList))
+}
+
+func TestConfirm(t *testing.T) {
+	for _, tc := range []struct {
+		input    string
+		expected bool
+	}{
+		{input: "y\n", expected: true},
+		{input: "Yes\n", expected: true},
+		{input: "n\n"},
+		{input: "\n"},
+		{input: ""},
+	} {
+		t.Run(fmt.Sprintf("%q", tc.input), func(t *testing.T) {
+			o := &Options{IOStreams: commander.IOStreams{In: strings.NewReader(tc.input), Out: ioutil.Discard, ErrOut: ioutil.Discard}}
+			assert.Equal(t, tc.expected, o.confirm(3))
+		})
+	}
+}